@@ -10,6 +10,7 @@ import (
 
 	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
 	"github.com/saurabhraut1212/notes_sharing_api/internal/db"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/mailer"
 	"github.com/saurabhraut1212/notes_sharing_api/internal/router"
 )
 
@@ -22,7 +23,12 @@ func main() {
 		log.Fatal(err)
 	}
 
-	app := router.Setup(client, cfg)
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app := router.Setup(client, cfg, mail)
 
 	// Channel to listen for OS signals
 	done := make(chan os.Signal, 1)
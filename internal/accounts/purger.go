@@ -0,0 +1,93 @@
+// Package accounts carries out account deletions once their grace period ends.
+package accounts
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/periodic"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DataOwner is a store holding documents that belong to a user.
+type DataOwner interface {
+	DeleteByUser(ctx context.Context, userID primitive.ObjectID) (int64, error)
+}
+
+// Eraser is a store that mentions users without owning documents for them,
+// such as the audit log or login throttles keyed by email, and must forget
+// the personal data it holds about a deleted user.
+type Eraser interface {
+	EraseUser(ctx context.Context, user *models.User) (int64, error)
+}
+
+// Purger deletes users whose deletion is due, together with everything they own.
+type Purger struct {
+	users   *repo.UserRepo
+	owners  map[string]DataOwner
+	erasers map[string]Eraser
+}
+
+// NewPurger takes the user-owned stores and the erasers by name, for logging.
+func NewPurger(users *repo.UserRepo, owners map[string]DataOwner, erasers map[string]Eraser) *Purger {
+	return &Purger{
+		users:   users,
+		owners:  owners,
+		erasers: erasers,
+	}
+}
+
+// Start runs the purge every interval until the returned stop func is called.
+func (p *Purger) Start(interval time.Duration) (stop func()) {
+	return periodic.Start("account purge", interval, time.Minute, p.Purge)
+}
+
+// Purge deletes every user whose grace period has passed. A user is marked as
+// started first so that a cancellation cannot interleave with the cascade; if
+// the cascade fails the user stays due and is retried on the next run.
+func (p *Purger) Purge(ctx context.Context) error {
+	now := time.Now().UTC()
+	due, err := p.users.ListDueForDeletion(ctx, now, 100)
+	if err != nil {
+		return err
+	}
+	for _, u := range due {
+		ok, err := p.users.StartDeletion(ctx, u.ID, now)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := p.deleteUser(ctx, &u); err != nil {
+			return err
+		}
+		log.Printf("account purge: deleted user %s", u.ID.Hex())
+	}
+	return nil
+}
+
+func (p *Purger) deleteUser(ctx context.Context, u *models.User) error {
+	for name, owner := range p.owners {
+		n, err := owner.DeleteByUser(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("account purge: removed %d %s of user %s", n, name, u.ID.Hex())
+		}
+	}
+	for name, eraser := range p.erasers {
+		n, err := eraser.EraseUser(ctx, u)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("account purge: erased %d %s of user %s", n, name, u.ID.Hex())
+		}
+	}
+	return p.users.Delete(ctx, u.ID)
+}
@@ -0,0 +1,138 @@
+// Package audit records security-relevant events and renders them for export
+// as JSON lines or RFC 5424 syslog messages.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+)
+
+// Store persists audit events.
+type Store interface {
+	Insert(ctx context.Context, ev *models.AuditEvent) error
+}
+
+// Recorder is what handlers use to write the audit trail.
+type Recorder struct {
+	store Store
+}
+
+func NewRecorder(store Store) *Recorder {
+	return &Recorder{store: store}
+}
+
+// Record stores ev. Failures are logged rather than returned so that a
+// struggling audit store does not fail the action being audited.
+func (r *Recorder) Record(ctx context.Context, ev *models.AuditEvent) {
+	if err := r.store.Insert(ctx, ev); err != nil {
+		log.Printf("audit: failed to record %s: %v", ev.Action, err)
+	}
+}
+
+// WriteJSONLines writes one JSON object per line.
+func WriteJSONLines(w io.Writer, events []models.AuditEvent) error {
+	enc := json.NewEncoder(w)
+	for i := range events {
+		if err := enc.Encode(&events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSyslog writes one RFC 5424 message per line, as accepted by most log
+// collectors over TCP with newline framing.
+func WriteSyslog(w io.Writer, events []models.AuditEvent, hostname, appName string) error {
+	for i := range events {
+		if _, err := io.WriteString(w, SyslogMessage(&events[i], hostname, appName)+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	// facility 13 is "log audit"
+	syslogFacility = 13
+	severityNotice = 5
+	severityWarn   = 4
+	// 32473 is the enterprise number RFC 5612 reserves for documentation
+	sdID = "audit@32473"
+)
+
+// SyslogMessage formats ev as an RFC 5424 message. Event fields go into a
+// structured data element; failures get warning severity, the rest notice.
+func SyslogMessage(ev *models.AuditEvent, hostname, appName string) string {
+	severity := severityNotice
+	if ev.Outcome == models.AuditFailure {
+		severity = severityWarn
+	}
+
+	params := [][2]string{{"outcome", ev.Outcome}}
+	if ev.ActorID != nil {
+		params = append(params, [2]string{"actor", ev.ActorID.Hex()})
+	}
+	for _, p := range [][2]string{
+		{"email", ev.Email},
+		{"target_type", ev.TargetType},
+		{"target_id", ev.TargetID},
+		{"ip", ev.IP},
+		{"user_agent", ev.UserAgent},
+	} {
+		if p[1] != "" {
+			params = append(params, p)
+		}
+	}
+	keys := make([]string, 0, len(ev.Details))
+	for k := range ev.Details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		params = append(params, [2]string{k, ev.Details[k]})
+	}
+
+	var sd strings.Builder
+	sd.WriteString("[" + sdID)
+	for _, p := range params {
+		fmt.Fprintf(&sd, ` %s="%s"`, syslogName(p[0], 32), sdEscaper.Replace(p[1]))
+	}
+	sd.WriteString("]")
+
+	return fmt.Sprintf("<%d>1 %s %s %s - %s %s %s %s",
+		syslogFacility*8+severity,
+		ev.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogName(hostname, 255),
+		syslogName(appName, 48),
+		syslogName(ev.Action, 32),
+		sd.String(),
+		ev.Action, ev.Outcome,
+	)
+}
+
+// sdEscaper escapes the characters RFC 5424 reserves in parameter values.
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogName reduces s to the printable ASCII header fields allow, using the
+// nil value "-" when nothing is left. Structured data names additionally
+// exclude '=', ']' and '"', which is harmless for the other fields.
+func syslogName(s string, max int) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < max; i++ {
+		ch := s[i]
+		if ch > 32 && ch < 127 && ch != '=' && ch != ']' && ch != '"' {
+			b = append(b, ch)
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
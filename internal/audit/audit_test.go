@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var eventTime = time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+
+func TestSyslogMessage(t *testing.T) {
+	actor, _ := primitive.ObjectIDFromHex("65f000000000000000000001")
+	tests := []struct {
+		name              string
+		ev                models.AuditEvent
+		hostname, appName string
+		want              string
+	}{
+		{
+			"success is notice",
+			models.AuditEvent{Time: eventTime, Action: models.AuditLogin, Outcome: models.AuditSuccess},
+			"api-1", "notes",
+			`<109>1 2024-05-06T07:08:09.123456Z api-1 notes - auth.login [audit@32473 outcome="success"] auth.login success`,
+		},
+		{
+			"failure is warning",
+			models.AuditEvent{Time: eventTime, Action: models.AuditLogin, Outcome: models.AuditFailure},
+			"api-1", "notes",
+			`<108>1 2024-05-06T07:08:09.123456Z api-1 notes - auth.login [audit@32473 outcome="failure"] auth.login failure`,
+		},
+		{
+			"time is given in UTC",
+			models.AuditEvent{Time: eventTime.In(time.FixedZone("CEST", 2*60*60)), Action: models.AuditLogout, Outcome: models.AuditSuccess},
+			"api-1", "notes",
+			`<109>1 2024-05-06T07:08:09.123456Z api-1 notes - auth.logout [audit@32473 outcome="success"] auth.logout success`,
+		},
+		{
+			"fields in fixed order, details sorted",
+			models.AuditEvent{
+				Time:       eventTime,
+				Action:     models.AuditNoteDelete,
+				Outcome:    models.AuditSuccess,
+				ActorID:    &actor,
+				Email:      "a@example.com",
+				TargetType: "note",
+				TargetID:   "65f000000000000000000002",
+				IP:         "203.0.113.7",
+				UserAgent:  "curl/8.0",
+				Details:    map[string]string{"z": "last", "a": "first"},
+			},
+			"api-1", "notes",
+			`<109>1 2024-05-06T07:08:09.123456Z api-1 notes - note.delete [audit@32473 outcome="success" actor="65f000000000000000000001" email="a@example.com" target_type="note" target_id="65f000000000000000000002" ip="203.0.113.7" user_agent="curl/8.0" a="first" z="last"] note.delete success`,
+		},
+		{
+			"reserved characters in values are escaped",
+			models.AuditEvent{
+				Time:      eventTime,
+				Action:    models.AuditLogin,
+				Outcome:   models.AuditFailure,
+				UserAgent: `Mozilla/5.0 (X11; "quoted") [x]`,
+				Details:   map[string]string{"reason": `a\b"c]d`},
+			},
+			"api-1", "notes",
+			`<108>1 2024-05-06T07:08:09.123456Z api-1 notes - auth.login [audit@32473 outcome="failure" user_agent="Mozilla/5.0 (X11; \"quoted\") [x\]" reason="a\\b\"c\]d"] auth.login failure`,
+		},
+		{
+			"names keep printable ASCII only",
+			models.AuditEvent{
+				Time:    eventTime,
+				Action:  "auth login",
+				Outcome: models.AuditSuccess,
+				Details: map[string]string{`k=e"y]`: "v", "é": "w"},
+			},
+			"", "my app\n",
+			`<109>1 2024-05-06T07:08:09.123456Z - myapp - authlogin [audit@32473 outcome="success" key="v" -="w"] auth login success`,
+		},
+		{
+			"names are truncated",
+			models.AuditEvent{
+				Time:    eventTime,
+				Action:  strings.Repeat("a", 40),
+				Outcome: models.AuditSuccess,
+				Details: map[string]string{strings.Repeat("k", 40): "v"},
+			},
+			strings.Repeat("h", 300), strings.Repeat("n", 60),
+			"<109>1 2024-05-06T07:08:09.123456Z " + strings.Repeat("h", 255) + " " + strings.Repeat("n", 48) + " - " + strings.Repeat("a", 32) +
+				` [audit@32473 outcome="success" ` + strings.Repeat("k", 32) + `="v"] ` + strings.Repeat("a", 40) + " success",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SyslogMessage(&tt.ev, tt.hostname, tt.appName); got != tt.want {
+				t.Errorf("SyslogMessage() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteSyslog(t *testing.T) {
+	events := []models.AuditEvent{
+		{Time: eventTime, Action: models.AuditLogin, Outcome: models.AuditSuccess},
+		{Time: eventTime, Action: models.AuditLogout, Outcome: models.AuditSuccess},
+	}
+	var buf bytes.Buffer
+	if err := WriteSyslog(&buf, events, "api-1", "notes"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(events) {
+		t.Fatalf("%d lines, want %d:\n%s", len(lines), len(events), buf.String())
+	}
+	for i, line := range lines {
+		if want := SyslogMessage(&events[i], "api-1", "notes"); line != want {
+			t.Errorf("line %d = %s, want %s", i, line, want)
+		}
+	}
+}
+
+func TestJSONLinesRoundTrip(t *testing.T) {
+	actor := primitive.NewObjectID()
+	events := []models.AuditEvent{
+		{
+			ID:         primitive.NewObjectID(),
+			Time:       eventTime,
+			Action:     models.AuditRoleChange,
+			Outcome:    models.AuditSuccess,
+			ActorID:    &actor,
+			Email:      "a@example.com",
+			TargetType: "user",
+			TargetID:   primitive.NewObjectID().Hex(),
+			IP:         "203.0.113.7",
+			UserAgent:  "line\nbreak \"quoted\"",
+			Details:    map[string]string{"from": "user", "to": "moderator"},
+		},
+		{ID: primitive.NewObjectID(), Time: eventTime, Action: models.AuditLogin, Outcome: models.AuditFailure},
+	}
+	var buf bytes.Buffer
+	if err := WriteJSONLines(&buf, events); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != len(events) {
+		t.Fatalf("%d lines, want %d:\n%s", n, len(events), buf.String())
+	}
+
+	var got []models.AuditEvent
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var ev models.AuditEvent
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ev)
+	}
+	if !reflect.DeepEqual(got, events) {
+		t.Errorf("round trip =\n%+v\nwant\n%+v", got, events)
+	}
+}
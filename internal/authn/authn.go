@@ -0,0 +1,95 @@
+// Package authn checks login credentials against the configured backends and
+// returns the local account they belong to.
+package authn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/password"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+)
+
+// ErrInvalidCredentials means the backend does not know the login or the
+// password is wrong; the two are deliberately not told apart.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrLinkRequired means the credentials are valid, but they belong to an
+// identity whose email matches an account with credentials of its own. The
+// error is a *LinkRequiredError.
+var ErrLinkRequired = errors.New("account link requires confirmation")
+
+// LinkRequiredError names the account and the identity that may only be
+// linked once the account's owner has confirmed it.
+type LinkRequiredError struct {
+	User     *models.User
+	Identity models.Identity
+}
+
+func (e *LinkRequiredError) Error() string { return ErrLinkRequired.Error() }
+
+func (e *LinkRequiredError) Unwrap() error { return ErrLinkRequired }
+
+// ErrRegistrationClosed means the credentials are valid, but no account
+// exists for them yet and the registration mode does not allow creating one.
+var ErrRegistrationClosed = errors.New("registration is closed")
+
+// Authenticator verifies a login (an email, or whatever the backend accepts)
+// and password.
+type Authenticator interface {
+	Authenticate(ctx context.Context, login, password string) (*models.User, error)
+}
+
+// Chain tries each backend in order and returns the first match.
+type Chain []Authenticator
+
+// Authenticate returns ErrInvalidCredentials only when every backend rejected
+// the credentials; if one failed for another reason, that error is returned
+// so an unreachable directory is not reported as a wrong password. Callers
+// should count either as a failed attempt. A backend that accepted the
+// credentials but cannot sign the user in ends the chain with ErrLinkRequired
+// or ErrRegistrationClosed.
+func (c Chain) Authenticate(ctx context.Context, login, password string) (*models.User, error) {
+	var failure error
+	for _, a := range c {
+		user, err := a.Authenticate(ctx, login, password)
+		if err == nil || errors.Is(err, ErrLinkRequired) || errors.Is(err, ErrRegistrationClosed) {
+			return user, err
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			log.Printf("authentication backend %T: %v", a, err)
+			failure = err
+		}
+	}
+	if failure != nil {
+		return nil, failure
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// New builds the chain configured by AUTH_BACKENDS.
+func New(cfg *config.Config, users *repo.UserRepo, passwords *password.Hasher) (Authenticator, error) {
+	var chain Chain
+	for _, name := range cfg.AuthBackends {
+		switch name {
+		case "local":
+			chain = append(chain, NewLocal(users, passwords))
+		case "ldap":
+			l, err := NewLDAP(cfg, users)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, l)
+		default:
+			return nil, fmt.Errorf("unknown auth backend %q", name)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no auth backends configured")
+	}
+	return chain, nil
+}
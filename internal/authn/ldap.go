@@ -0,0 +1,261 @@
+package authn
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const ldapTimeout = 5 * time.Second
+
+// ldapConn is the part of *ldap.Conn the backend uses.
+type ldapConn interface {
+	StartTLS(*tls.Config) error
+	Bind(username, password string) error
+	Search(*ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// ldapUsers is the part of the user repository LDAP provisioning needs.
+type ldapUsers interface {
+	FindByIdentity(ctx context.Context, issuer, subject string) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	LinkIdentity(ctx context.Context, id primitive.ObjectID, identity models.Identity) error
+	SetRole(ctx context.Context, id primitive.ObjectID, role models.Role) (bool, error)
+	MarkDirectoryManaged(ctx context.Context, id primitive.ObjectID) error
+	Create(ctx context.Context, user *models.User) error
+}
+
+// LDAP authenticates by binding to a directory as the user. Accounts are
+// provisioned on first login and, when group mappings are configured, the
+// role of the accounts it created follows their directory groups on every
+// login. A local account with the same email is only linked by itself if it
+// has no credentials of its own; otherwise its owner has to confirm the link.
+// Linked local accounts keep the role they were given here.
+type LDAP struct {
+	url             string
+	startTLS        bool
+	bindDN          string
+	bindPassword    string
+	baseDN          string
+	userFilter      string
+	emailAttr       string
+	usernameAttr    string
+	displayNameAttr string
+	groupAttr       string
+	groupRoles      map[string]models.Role
+	// registrationMode decides whether unknown directory users get an
+	// account; with invite-only, directory membership counts as the invite.
+	registrationMode string
+	users            ldapUsers
+
+	dial func() (ldapConn, error)
+}
+
+func NewLDAP(cfg *config.Config, users *repo.UserRepo) (*LDAP, error) {
+	if cfg.LDAPURL == "" || cfg.LDAPBaseDN == "" {
+		return nil, errors.New("ldap backend needs LDAP_URL and LDAP_BASE_DN")
+	}
+	if !strings.Contains(cfg.LDAPUserFilter, "%s") {
+		return nil, fmt.Errorf("LDAP_USER_FILTER %q has no %%s placeholder", cfg.LDAPUserFilter)
+	}
+	roles := map[string]models.Role{}
+	for group, role := range cfg.LDAPGroupRoles {
+		if !models.Role(role).Valid() {
+			return nil, fmt.Errorf("LDAP_GROUP_ROLES: unknown role %q for %s", role, group)
+		}
+		roles[group] = models.Role(role)
+	}
+	l := &LDAP{
+		url:              cfg.LDAPURL,
+		startTLS:         cfg.LDAPStartTLS,
+		bindDN:           cfg.LDAPBindDN,
+		bindPassword:     cfg.LDAPBindPassword,
+		baseDN:           cfg.LDAPBaseDN,
+		userFilter:       cfg.LDAPUserFilter,
+		emailAttr:        cfg.LDAPEmailAttr,
+		usernameAttr:     cfg.LDAPUsernameAttr,
+		displayNameAttr:  cfg.LDAPDisplayNameAttr,
+		groupAttr:        cfg.LDAPGroupAttr,
+		groupRoles:       roles,
+		registrationMode: cfg.RegistrationMode,
+		users:            users,
+	}
+	l.dial = l.dialURL
+	return l, nil
+}
+
+func (l *LDAP) dialURL() (ldapConn, error) {
+	conn, err := ldap.DialURL(l.url, ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+	if l.startTLS {
+		u, err := url.Parse(l.url)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (l *LDAP) Authenticate(ctx context.Context, login, password string) (*models.User, error) {
+	// an empty password would make the bind an anonymous one, which succeeds
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if l.bindDN != "" {
+		if err := conn.Bind(l.bindDN, l.bindPassword); err != nil {
+			return nil, fmt.Errorf("service bind: %w", err)
+		}
+	}
+	res, err := conn.Search(ldap.NewSearchRequest(
+		l.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false,
+		strings.ReplaceAll(l.userFilter, "%s", ldap.EscapeFilter(login)),
+		[]string{l.emailAttr, l.usernameAttr, l.displayNameAttr, l.groupAttr},
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("user search: %w", err)
+	}
+	if res == nil || len(res.Entries) != 1 {
+		if res != nil && len(res.Entries) > 1 {
+			log.Printf("ldap: login %q matches several entries, refusing", login)
+		}
+		return nil, ErrInvalidCredentials
+	}
+	entry := res.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("user bind: %w", err)
+	}
+	return l.provision(ctx, entry)
+}
+
+// roleFor returns the highest role mapped to any of the groups.
+func (l *LDAP) roleFor(groups []string) models.Role {
+	role := models.RoleUser
+	for _, g := range groups {
+		if r, ok := l.groupRoles[strings.ToLower(g)]; ok && roleRank[r] > roleRank[role] {
+			role = r
+		}
+	}
+	return role
+}
+
+var roleRank = map[models.Role]int{models.RoleUser: 0, models.RoleModerator: 1, models.RoleAdmin: 2}
+
+// provision returns the local account for a directory entry, linking an
+// account with the same email or creating one on first login. Linking an
+// account that can sign in by itself fails with a *LinkRequiredError, since
+// whoever controls the entry's email would otherwise take it over.
+func (l *LDAP) provision(ctx context.Context, entry *ldap.Entry) (*models.User, error) {
+	identity := models.Identity{Issuer: l.url, Subject: strings.ToLower(entry.DN)}
+	role := l.roleFor(entry.GetAttributeValues(l.groupAttr))
+
+	user, err := l.users.FindByIdentity(ctx, identity.Issuer, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil && !user.DirectoryManaged && createdBy(user, identity) {
+		// provisioned before accounts were flagged
+		if err := l.users.MarkDirectoryManaged(ctx, user.ID); err != nil {
+			return nil, err
+		}
+		user.DirectoryManaged = true
+	}
+	if user == nil {
+		email := models.NormalizeEmail(entry.GetAttributeValue(l.emailAttr))
+		if email == "" {
+			return nil, fmt.Errorf("directory entry %s has no %s", entry.DN, l.emailAttr)
+		}
+		if user, err = l.users.FindByEmail(ctx, email); err != nil {
+			return nil, err
+		}
+		if user != nil {
+			if user.HasCredentials() {
+				return nil, &LinkRequiredError{User: user, Identity: identity}
+			}
+			if err := l.users.LinkIdentity(ctx, user.ID, identity); err != nil {
+				return nil, err
+			}
+			user.EmailVerified = true
+		} else {
+			return l.create(ctx, entry, identity, email, role)
+		}
+	}
+
+	if len(l.groupRoles) > 0 && user.DirectoryManaged && user.EffectiveRole() != role {
+		if _, err := l.users.SetRole(ctx, user.ID, role); err != nil {
+			return nil, err
+		}
+		user.Role = role
+	}
+	return user, nil
+}
+
+// createdBy reports whether the account looks like one provision created:
+// no local password and no identity but the directory's.
+func createdBy(user *models.User, identity models.Identity) bool {
+	return user.Password == "" && len(user.Identities) == 1 && user.Identities[0] == identity
+}
+
+// create gives a directory user seen for the first time an account, unless
+// registration is closed.
+func (l *LDAP) create(ctx context.Context, entry *ldap.Entry, identity models.Identity, email string, role models.Role) (*models.User, error) {
+	if l.registrationMode == config.RegistrationClosed {
+		return nil, ErrRegistrationClosed
+	}
+	username := entry.GetAttributeValue(l.usernameAttr)
+	if !models.ValidUsername(username) {
+		username = ""
+	} else if taken, err := l.users.FindByUsername(ctx, username); err != nil {
+		return nil, err
+	} else if taken != nil {
+		username = ""
+	}
+
+	now := time.Now().UTC()
+	user := &models.User{
+		Username:         username,
+		Email:            email,
+		DisplayName:      entry.GetAttributeValue(l.displayNameAttr),
+		Role:             role,
+		EmailVerified:    true,
+		EmailVerifiedAt:  &now,
+		Identities:       []models.Identity{identity},
+		DirectoryManaged: true,
+	}
+	if err := l.users.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,512 @@
+package authn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// directoryEntry is a user in fakeDirectory.
+type directoryEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// fakeDirectory is an LDAP server speaking just enough of the protocol for
+// the backend: simple binds and searches with an equality filter.
+type fakeDirectory struct {
+	ln              net.Listener
+	serviceDN       string
+	servicePassword string
+
+	mu      sync.Mutex
+	entries []directoryEntry
+}
+
+func newFakeDirectory(t *testing.T, entries ...directoryEntry) *fakeDirectory {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDirectory{
+		ln:              ln,
+		serviceDN:       "cn=svc,dc=example,dc=com",
+		servicePassword: "svc-secret",
+		entries:         entries,
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return d
+}
+
+func (d *fakeDirectory) url() string { return "ldap://" + d.ln.Addr().String() }
+
+// setGroups replaces the groups of the entry with the given DN.
+func (d *fakeDirectory) setGroups(dn string, groups ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range d.entries {
+		if e.dn == dn {
+			e.attrs["memberOf"] = groups
+		}
+	}
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		id, _ := p.Children[0].Value.(int64)
+		op := p.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := d.bind(op.Children[1].Data.String(), op.Children[2].Data.String())
+			conn.Write(ldapResult(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError).Bytes())
+				continue
+			}
+			for _, e := range d.search(filter) {
+				conn.Write(ldapEntryPacket(id, e).Bytes())
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func (d *fakeDirectory) bind(dn, password string) int {
+	if dn == d.serviceDN && password == d.servicePassword {
+		return ldap.LDAPResultSuccess
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range d.entries {
+		if e.dn == dn && e.password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials
+}
+
+var equalityFilter = regexp.MustCompile(`^\(([a-zA-Z]+)=([^()*]*)\)$`)
+
+func (d *fakeDirectory) search(filter string) []directoryEntry {
+	m := equalityFilter.FindStringSubmatch(filter)
+	if m == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []directoryEntry
+	for _, e := range d.entries {
+		for _, v := range e.attrs[m[1]] {
+			if strings.EqualFold(v, m[2]) {
+				out = append(out, e)
+				break
+			}
+		}
+	}
+	return out
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	p.AppendChild(op)
+	return p
+}
+
+func ldapResult(id int64, tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return ldapMessage(id, op)
+}
+
+func ldapEntryPacket(id int64, e directoryEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range e.attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return ldapMessage(id, op)
+}
+
+// fakeUsers is an in-memory ldapUsers.
+type fakeUsers struct {
+	users []*models.User
+}
+
+func (f *fakeUsers) FindByIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	for _, u := range f.users {
+		for _, id := range u.Identities {
+			if id.Issuer == issuer && id.Subject == subject {
+				return u, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUsers) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	for _, u := range f.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUsers) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	for _, u := range f.users {
+		if u.UsernameKey == models.FoldUsername(username) {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUsers) LinkIdentity(ctx context.Context, id primitive.ObjectID, identity models.Identity) error {
+	for _, u := range f.users {
+		if u.ID == id {
+			u.Identities = append(u.Identities, identity)
+			u.EmailVerified = true
+		}
+	}
+	return nil
+}
+
+func (f *fakeUsers) SetRole(ctx context.Context, id primitive.ObjectID, role models.Role) (bool, error) {
+	for _, u := range f.users {
+		if u.ID == id {
+			u.Role = role
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeUsers) MarkDirectoryManaged(ctx context.Context, id primitive.ObjectID) error {
+	for _, u := range f.users {
+		if u.ID == id {
+			u.DirectoryManaged = true
+		}
+	}
+	return nil
+}
+
+func (f *fakeUsers) Create(ctx context.Context, user *models.User) error {
+	user.ID = primitive.NewObjectID()
+	user.UsernameKey = models.FoldUsername(user.Username)
+	f.users = append(f.users, user)
+	return nil
+}
+
+const (
+	aliceDN  = "uid=alice,ou=people,dc=example,dc=com"
+	adminsDN = "cn=admins,ou=groups,dc=example,dc=com"
+	modsDN   = "cn=moderators,ou=groups,dc=example,dc=com"
+)
+
+func newTestLDAP(t *testing.T, d *fakeDirectory, users *fakeUsers) *LDAP {
+	t.Helper()
+	l, err := NewLDAP(&config.Config{
+		LDAPURL:             d.url(),
+		LDAPBindDN:          d.serviceDN,
+		LDAPBindPassword:    d.servicePassword,
+		LDAPBaseDN:          "dc=example,dc=com",
+		LDAPUserFilter:      "(mail=%s)",
+		LDAPEmailAttr:       "mail",
+		LDAPUsernameAttr:    "uid",
+		LDAPDisplayNameAttr: "displayName",
+		LDAPGroupAttr:       "memberOf",
+		LDAPGroupRoles:      map[string]string{adminsDN: "admin", modsDN: "moderator"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.users = users
+	return l
+}
+
+func alice(groups ...string) directoryEntry {
+	return directoryEntry{
+		dn:       aliceDN,
+		password: "alice-secret",
+		attrs: map[string][]string{
+			"mail":        {"Alice@Example.com"},
+			"uid":         {"alice"},
+			"displayName": {"Alice Liddell"},
+			"memberOf":    groups,
+		},
+	}
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		groups   []string
+		wantErr  error
+		wantRole models.Role
+	}{
+		{name: "bind succeeds", login: "alice@example.com", password: "alice-secret", wantRole: models.RoleUser},
+		{name: "highest mapped group wins", login: "alice@example.com", password: "alice-secret", groups: []string{"cn=staff,dc=example,dc=com", modsDN, adminsDN}, wantRole: models.RoleAdmin},
+		{name: "mapped group", login: "alice@example.com", password: "alice-secret", groups: []string{modsDN}, wantRole: models.RoleModerator},
+		{name: "wrong password", login: "alice@example.com", password: "guess", wantErr: ErrInvalidCredentials},
+		{name: "unknown login", login: "bob@example.com", password: "alice-secret", wantErr: ErrInvalidCredentials},
+		{name: "empty password", login: "alice@example.com", password: "", wantErr: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &fakeUsers{}
+			l := newTestLDAP(t, newFakeDirectory(t, alice(tt.groups...)), users)
+			user, err := l.Authenticate(context.Background(), tt.login, tt.password)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				if len(users.users) != 0 {
+					t.Error("a rejected login provisioned an account")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Email != "alice@example.com" || user.Username != "alice" || user.DisplayName != "Alice Liddell" || !user.EmailVerified || !user.DirectoryManaged {
+				t.Errorf("provisioned user = %+v", user)
+			}
+			if user.EffectiveRole() != tt.wantRole {
+				t.Errorf("role = %s, want %s", user.EffectiveRole(), tt.wantRole)
+			}
+			want := models.Identity{Issuer: l.url, Subject: aliceDN}
+			if len(user.Identities) != 1 || user.Identities[0] != want {
+				t.Errorf("identities = %v, want %v", user.Identities, want)
+			}
+		})
+	}
+}
+
+func TestLDAPRoleFollowsGroups(t *testing.T) {
+	users := &fakeUsers{}
+	d := newFakeDirectory(t, alice(adminsDN))
+	l := newTestLDAP(t, d, users)
+	ctx := context.Background()
+
+	if _, err := l.Authenticate(ctx, "alice@example.com", "alice-secret"); err != nil {
+		t.Fatal(err)
+	}
+	d.setGroups(aliceDN)
+	user, err := l.Authenticate(ctx, "alice@example.com", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users.users) != 1 {
+		t.Fatalf("%d accounts, want the first login's account reused", len(users.users))
+	}
+	if user.EffectiveRole() != models.RoleUser || users.users[0].Role != models.RoleUser {
+		t.Errorf("role = %s after leaving the admins group", user.EffectiveRole())
+	}
+}
+
+func TestLDAPRegistrationMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		wantErr error
+	}{
+		{config.RegistrationOpen, nil},
+		{config.RegistrationInviteOnly, nil},
+		{config.RegistrationClosed, ErrRegistrationClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			users := &fakeUsers{}
+			l := newTestLDAP(t, newFakeDirectory(t, alice()), users)
+			l.registrationMode = tt.mode
+			_, err := l.Authenticate(context.Background(), "alice@example.com", "alice-secret")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			wantAccounts := 1
+			if tt.wantErr != nil {
+				wantAccounts = 0
+			}
+			if len(users.users) != wantAccounts {
+				t.Errorf("%d accounts, want %d", len(users.users), wantAccounts)
+			}
+			if _, err := (Chain{l, rejectAll{}}).Authenticate(context.Background(), "alice@example.com", "alice-secret"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("chain err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("existing accounts still sign in when closed", func(t *testing.T) {
+		users := &fakeUsers{}
+		l := newTestLDAP(t, newFakeDirectory(t, alice()), users)
+		if _, err := l.Authenticate(context.Background(), "alice@example.com", "alice-secret"); err != nil {
+			t.Fatal(err)
+		}
+		l.registrationMode = config.RegistrationClosed
+		if _, err := l.Authenticate(context.Background(), "alice@example.com", "alice-secret"); err != nil {
+			t.Fatalf("err = %v for a provisioned account", err)
+		}
+	})
+}
+
+func TestLDAPLinkedAccounts(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("account with credentials needs confirmation", func(t *testing.T) {
+		for name, local := range map[string]*models.User{
+			"password": {Password: "hash"},
+			"totp":     {TOTPEnabled: true},
+			"oidc":     {Identities: []models.Identity{{Issuer: "https://idp.example.com", Subject: "s"}}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				local.ID, local.Email = primitive.NewObjectID(), "alice@example.com"
+				users := &fakeUsers{users: []*models.User{local}}
+				l := newTestLDAP(t, newFakeDirectory(t, alice(adminsDN)), users)
+				identities := len(local.Identities)
+
+				user, err := l.Authenticate(ctx, "alice@example.com", "alice-secret")
+				var link *LinkRequiredError
+				if !errors.As(err, &link) || user != nil {
+					t.Fatalf("Authenticate() = %v, %v, want a %T", user, err, link)
+				}
+				if link.User.ID != local.ID || link.Identity != (models.Identity{Issuer: l.url, Subject: aliceDN}) {
+					t.Errorf("link = %+v", link)
+				}
+				if len(local.Identities) != identities || local.EmailVerified || local.DirectoryManaged || local.Role != "" {
+					t.Errorf("account changed without confirmation: %+v", local)
+				}
+				// the chain stops at the link rather than trying on
+				if _, err := (Chain{l, rejectAll{}}).Authenticate(ctx, "alice@example.com", "alice-secret"); !errors.Is(err, ErrLinkRequired) {
+					t.Errorf("chain err = %v, want %v", err, ErrLinkRequired)
+				}
+			})
+		}
+	})
+
+	t.Run("account without credentials is linked", func(t *testing.T) {
+		local := &models.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Role: models.RoleModerator}
+		users := &fakeUsers{users: []*models.User{local}}
+		l := newTestLDAP(t, newFakeDirectory(t, alice(adminsDN)), users)
+		user, err := l.Authenticate(ctx, "alice@example.com", "alice-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != local.ID || len(local.Identities) != 1 || !user.EmailVerified {
+			t.Errorf("user = %+v, want the local account linked", user)
+		}
+	})
+
+	t.Run("confirmed link keeps the local role", func(t *testing.T) {
+		d := newFakeDirectory(t, alice(adminsDN))
+		local := &models.User{
+			ID: primitive.NewObjectID(), Email: "alice@example.com", Password: "hash", Role: models.RoleModerator,
+			Identities: []models.Identity{{Issuer: d.url(), Subject: aliceDN}},
+		}
+		users := &fakeUsers{users: []*models.User{local}}
+		l := newTestLDAP(t, d, users)
+		user, err := l.Authenticate(ctx, "alice@example.com", "alice-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != local.ID || user.DirectoryManaged || user.EffectiveRole() != models.RoleModerator {
+			t.Errorf("user = %+v, want the local account unchanged", user)
+		}
+	})
+
+	t.Run("account provisioned before flagging", func(t *testing.T) {
+		d := newFakeDirectory(t, alice(adminsDN))
+		legacy := &models.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Identities: []models.Identity{{Issuer: d.url(), Subject: aliceDN}}}
+		users := &fakeUsers{users: []*models.User{legacy}}
+		l := newTestLDAP(t, d, users)
+		user, err := l.Authenticate(ctx, "alice@example.com", "alice-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !legacy.DirectoryManaged || user.EffectiveRole() != models.RoleAdmin {
+			t.Errorf("user = %+v, want it flagged and its role synced", user)
+		}
+	})
+}
+
+// rejectAll is a backend that knows nobody.
+type rejectAll struct{}
+
+func (rejectAll) Authenticate(ctx context.Context, login, password string) (*models.User, error) {
+	return nil, ErrInvalidCredentials
+}
+
+func TestLDAPDirectoryErrors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unreachable server", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := &fakeDirectory{ln: ln}
+		ln.Close()
+		l := newTestLDAP(t, d, &fakeUsers{})
+		_, err = l.Authenticate(ctx, "alice@example.com", "alice-secret")
+		if err == nil || errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("err = %v, want a connection error", err)
+		}
+		// the chain reports it rather than a wrong password
+		_, err = Chain{l}.Authenticate(ctx, "alice@example.com", "alice-secret")
+		if err == nil || errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("chain err = %v, want a connection error", err)
+		}
+	})
+
+	t.Run("service bind rejected", func(t *testing.T) {
+		d := newFakeDirectory(t, alice())
+		l := newTestLDAP(t, d, &fakeUsers{})
+		l.bindPassword = "stale"
+		_, err := l.Authenticate(ctx, "alice@example.com", "alice-secret")
+		if err == nil || errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("err = %v, want a service bind error", err)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package authn
+
+import (
+	"context"
+	"log"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/password"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+)
+
+// Local checks passwords against the hashes in the users collection.
+type Local struct {
+	users     *repo.UserRepo
+	passwords *password.Hasher
+}
+
+func NewLocal(users *repo.UserRepo, passwords *password.Hasher) *Local {
+	return &Local{users: users, passwords: passwords}
+}
+
+// Authenticate looks the user up by email. Unknown emails cost as much as a
+// wrong password, and outdated hashes are upgraded on success. Accounts an
+// LDAP directory manages are left to that backend.
+func (l *Local) Authenticate(ctx context.Context, email, plain string) (*models.User, error) {
+	user, err := l.users.FindByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	hash := l.passwords.Dummy()
+	if user != nil {
+		hash = user.Password
+	}
+	ok, rehash := l.passwords.Verify(plain, hash)
+	if !ok || user == nil || user.DirectoryManaged {
+		return nil, ErrInvalidCredentials
+	}
+	if rehash {
+		l.upgradeHash(ctx, user, plain)
+	}
+	return user, nil
+}
+
+// upgradeHash replaces a bcrypt or outdated argon2id hash while the plaintext
+// is at hand. Failing to do so does not fail the login.
+func (l *Local) upgradeHash(ctx context.Context, user *models.User, plain string) {
+	hash, err := l.passwords.Hash(plain)
+	if err == nil {
+		err = l.users.UpdatePassword(ctx, user.ID, hash)
+	}
+	if err != nil {
+		log.Printf("failed to upgrade password hash of %s: %v", user.ID.Hex(), err)
+	}
+}
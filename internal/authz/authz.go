@@ -0,0 +1,86 @@
+// Package authz is the single place that decides whether a subject may perform
+// an action on a resource. Handlers ask the Policy instead of comparing user
+// ids themselves.
+package authz
+
+import (
+	"slices"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Action string
+
+const (
+	NoteRead   Action = "note:read"
+	NoteUpdate Action = "note:update"
+	NoteDelete Action = "note:delete"
+	// NoteHistory covers reading past revisions, which may hold content the
+	// note no longer shows.
+	NoteHistory Action = "note:history"
+	// TrashManage covers restoring a note from the trash and deleting it for
+	// good. Moderators can move notes to the trash but not past it.
+	TrashManage Action = "trash:manage"
+
+	UserManage    Action = "user:manage"
+	LockoutManage Action = "lockout:manage"
+
+	// InviteManage covers revoking an invite and, without a resource,
+	// creating invites beyond the per-user limits and seeing everyone's.
+	InviteManage Action = "invite:manage"
+
+	AuditRead Action = "audit:read"
+)
+
+// Subject is who is asking. The zero value is an anonymous caller.
+type Subject struct {
+	UserID primitive.ObjectID
+	Role   models.Role
+}
+
+// Authenticated reports whether the subject is a logged-in user.
+func (s Subject) Authenticated() bool {
+	return !s.UserID.IsZero()
+}
+
+// Policy evaluates permissions from two sources: actions a role may perform on
+// any resource, and rules tied to the resource itself such as ownership.
+type Policy struct {
+	roleGrants map[models.Role][]Action
+}
+
+func NewPolicy() *Policy {
+	return &Policy{
+		roleGrants: map[models.Role][]Action{
+			models.RoleModerator: {NoteRead, NoteDelete, NoteHistory},
+			models.RoleAdmin:     {NoteRead, NoteUpdate, NoteDelete, NoteHistory, TrashManage, UserManage, LockoutManage, InviteManage, AuditRead},
+		},
+	}
+}
+
+// Can reports whether sub may perform action on resource. resource may be nil
+// for actions that are not about a particular object.
+func (p *Policy) Can(sub Subject, action Action, resource interface{}) bool {
+	if sub.Authenticated() && slices.Contains(p.roleGrants[sub.Role], action) {
+		return true
+	}
+	switch r := resource.(type) {
+	case *models.Note:
+		return canNote(sub, action, r)
+	case *models.Invite:
+		return action == InviteManage && sub.Authenticated() && sub.UserID == r.CreatedBy
+	}
+	return false
+}
+
+func canNote(sub Subject, action Action, n *models.Note) bool {
+	owner := sub.Authenticated() && sub.UserID == n.UserID
+	switch action {
+	case NoteRead:
+		return n.IsPublic || owner
+	case NoteUpdate, NoteDelete, NoteHistory, TrashManage:
+		return owner
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPolicyNotes(t *testing.T) {
+	owner := Subject{UserID: primitive.NewObjectID(), Role: models.RoleUser}
+	other := Subject{UserID: primitive.NewObjectID(), Role: models.RoleUser}
+	moderator := Subject{UserID: primitive.NewObjectID(), Role: models.RoleModerator}
+	admin := Subject{UserID: primitive.NewObjectID(), Role: models.RoleAdmin}
+	anonymous := Subject{}
+
+	private := &models.Note{UserID: owner.UserID}
+	public := &models.Note{UserID: owner.UserID, IsPublic: true}
+
+	tests := []struct {
+		name   string
+		sub    Subject
+		action Action
+		note   *models.Note
+		want   bool
+	}{
+		{"owner reads", owner, NoteRead, private, true},
+		{"other reads private", other, NoteRead, private, false},
+		{"other reads public", other, NoteRead, public, true},
+		{"anonymous reads public", anonymous, NoteRead, public, true},
+		{"anonymous reads private", anonymous, NoteRead, private, false},
+		{"owner updates", owner, NoteUpdate, private, true},
+		{"other updates public", other, NoteUpdate, public, false},
+		{"moderator updates", moderator, NoteUpdate, private, false},
+		{"admin updates", admin, NoteUpdate, private, true},
+		{"moderator deletes", moderator, NoteDelete, private, true},
+		{"other deletes", other, NoteDelete, public, false},
+		{"moderator reads history", moderator, NoteHistory, private, true},
+		{"other reads history of public note", other, NoteHistory, public, false},
+		{"owner manages trash", owner, TrashManage, private, true},
+		{"moderator manages trash", moderator, TrashManage, private, false},
+		{"admin manages trash", admin, TrashManage, private, true},
+		{"other manages trash", other, TrashManage, private, false},
+	}
+	p := NewPolicy()
+	for _, tt := range tests {
+		if got := p.Can(tt.sub, tt.action, tt.note); got != tt.want {
+			t.Errorf("%s: Can() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyWithoutResource(t *testing.T) {
+	p := NewPolicy()
+	admin := Subject{UserID: primitive.NewObjectID(), Role: models.RoleAdmin}
+	moderator := Subject{UserID: primitive.NewObjectID(), Role: models.RoleModerator}
+	forged := Subject{Role: models.RoleAdmin}
+
+	for _, action := range []Action{UserManage, LockoutManage, InviteManage, AuditRead} {
+		if !p.Can(admin, action, nil) {
+			t.Errorf("admin cannot %s", action)
+		}
+		if p.Can(moderator, action, nil) {
+			t.Errorf("moderator can %s", action)
+		}
+		if p.Can(forged, action, nil) {
+			t.Errorf("unauthenticated admin role can %s", action)
+		}
+	}
+}
+
+func TestPolicyInvites(t *testing.T) {
+	p := NewPolicy()
+	creator := Subject{UserID: primitive.NewObjectID(), Role: models.RoleUser}
+	other := Subject{UserID: primitive.NewObjectID(), Role: models.RoleUser}
+	inv := &models.Invite{CreatedBy: creator.UserID}
+
+	if !p.Can(creator, InviteManage, inv) {
+		t.Error("creator cannot manage their invite")
+	}
+	if p.Can(other, InviteManage, inv) {
+		t.Error("other user can manage the invite")
+	}
+	if p.Can(creator, NoteDelete, inv) {
+		t.Error("invite grants unrelated actions")
+	}
+}
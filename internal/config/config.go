@@ -3,6 +3,9 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,6 +15,121 @@ type Config struct {
 	DBName    string
 	Port      string
 	JWTSecret string
+	// JWTSigningKeyFile is a PEM RSA or Ed25519 private key. When set, tokens
+	// are signed with RS256/EdDSA under JWTSigningKeyID (default: the key's
+	// thumbprint) instead of HS256 with JWTSecret.
+	JWTSigningKeyFile string
+	JWTSigningKeyID   string
+	// JWTVerificationKeys lists extra "kid=path" PEM keys still accepted, e.g.
+	// the previous signing key during a rotation.
+	JWTVerificationKeys []string
+	AccessTokenTTL      time.Duration
+	RefreshTokenTTL     time.Duration
+	// RevocationStore selects where revoked tokens are kept: "mongo" or "memory".
+	RevocationStore string
+
+	// AppBaseURL is the public URL links in emails point to.
+	AppBaseURL       string
+	PasswordResetTTL time.Duration
+	MagicLinkTTL     time.Duration
+
+	// EmailVerificationPolicy decides what unverified accounts may not do:
+	// "none", "public-notes" (no public notes) or "login" (no login at all).
+	EmailVerificationPolicy string
+	EmailVerificationTTL    time.Duration
+
+	// TOTPIssuer is the account issuer shown in authenticator apps.
+	TOTPIssuer string
+
+	// WebAuthn relying party for passkeys. The id and origins default to the
+	// host and origin of AppBaseURL.
+	WebAuthnRPID    string
+	WebAuthnRPName  string
+	WebAuthnOrigins []string
+
+	// OpenID Connect login is enabled when OIDCIssuer is set.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       string
+
+	// MailDriver is "smtp", "log" or "memory"; the log driver writes mail to
+	// MailLogFile (or stdout) instead of sending it, and the memory driver keeps
+	// it for in-process tests.
+	MailDriver   string
+	MailFrom     string
+	MailLogFile  string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// Failed logins lock an account after LoginMaxAttempts and a client address
+	// after LoginMaxAttemptsPerIP, for LoginLockoutBase doubling up to
+	// LoginLockoutMax. Counters reset after LoginFailureWindow without failures.
+	LoginMaxAttempts      int
+	LoginMaxAttemptsPerIP int
+	LoginLockoutBase      time.Duration
+	LoginLockoutMax       time.Duration
+	LoginFailureWindow    time.Duration
+
+	// BootstrapAdminEmail names an existing account that is given the admin
+	// role at startup, so a fresh install has someone to manage roles.
+	BootstrapAdminEmail string
+
+	// AccountDeletionGrace is how long a deletion request can be cancelled;
+	// due deletions are carried out every AccountPurgeInterval.
+	AccountDeletionGrace time.Duration
+	AccountPurgeInterval time.Duration
+
+	// TrashRetention is how long deleted notes stay restorable; older ones
+	// are removed every TrashPurgeInterval.
+	TrashRetention     time.Duration
+	TrashPurgeInterval time.Duration
+
+	// RequireIfMatch makes note writes without an If-Match header fail with
+	// 428, so clients cannot overwrite changes they have not seen.
+	RequireIfMatch bool
+
+	// ProxyHeader (e.g. X-Forwarded-For) is trusted for the client address
+	// only on requests from TrustedProxies.
+	ProxyHeader    string
+	TrustedProxies []string
+
+	// AuthBackends lists the password backends Login tries, in order:
+	// "local" (password hashes in the users collection) and "ldap".
+	AuthBackends []string
+
+	// LDAP backend. Users are found under LDAPBaseDN with LDAPUserFilter, in
+	// which %s stands for the escaped login, then bound as with their password.
+	// LDAPGroupRoles maps group DNs to roles, e.g.
+	// "cn=admins,ou=groups,dc=example,dc=com=admin;cn=mods,...=moderator".
+	// Directory users without an account get one unless RegistrationMode is
+	// "closed"; for "invite-only", membership in the directory is the invite.
+	LDAPURL             string
+	LDAPStartTLS        bool
+	LDAPBindDN          string
+	LDAPBindPassword    string
+	LDAPBaseDN          string
+	LDAPUserFilter      string
+	LDAPEmailAttr       string
+	LDAPUsernameAttr    string
+	LDAPDisplayNameAttr string
+	LDAPGroupAttr       string
+	LDAPGroupRoles      map[string]string
+
+	// RegistrationMode is "open", "invite-only" (an invite code is required)
+	// or "closed". It applies to OpenID Connect and LDAP sign-ups as well.
+	RegistrationMode string
+	// InviteTTL is how long invites are valid unless created with an expiry.
+	InviteTTL time.Duration
+
+	// Argon2id cost for new password hashes; Argon2Memory is in KiB. Stored
+	// hashes with other parameters are upgraded at the next login.
+	Argon2Memory      int
+	Argon2Iterations  int
+	Argon2Parallelism int
 }
 
 func Load() *Config {
@@ -20,10 +138,97 @@ func Load() *Config {
 		MongoURI:  mustEnv("MONGO_URI"),
 		DBName:    getEnv("DB_Name", "dbNotes"),
 		Port:      getEnv("PORT", "8080"),
-		JWTSecret: mustEnv("JWT_SECRET"),
+		JWTSecret: os.Getenv("JWT_SECRET"),
+
+		JWTSigningKeyFile:   os.Getenv("JWT_SIGNING_KEY_FILE"),
+		JWTSigningKeyID:     os.Getenv("JWT_SIGNING_KEY_ID"),
+		JWTVerificationKeys: getList("JWT_VERIFICATION_KEYS"),
+
+		AccessTokenTTL:  getDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		RevocationStore: getEnum("REVOCATION_STORE", "mongo", "memory"),
+
+		AppBaseURL:       getEnv("APP_BASE_URL", "http://localhost:8080"),
+		PasswordResetTTL: getDuration("PASSWORD_RESET_TTL", time.Hour),
+		MagicLinkTTL:     getDuration("MAGIC_LINK_TTL", 15*time.Minute),
+
+		EmailVerificationPolicy: getEnum("EMAIL_VERIFICATION_POLICY", VerifyNone, VerifyPublicNotes, VerifyLogin),
+		EmailVerificationTTL:    getDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+
+		TOTPIssuer: getEnv("TOTP_ISSUER", "Notes Sharing API"),
+
+		WebAuthnRPID:    os.Getenv("WEBAUTHN_RP_ID"),
+		WebAuthnRPName:  getEnv("WEBAUTHN_RP_NAME", "Notes Sharing API"),
+		WebAuthnOrigins: getList("WEBAUTHN_ORIGINS"),
+
+		OIDCIssuer:       os.Getenv("OIDC_ISSUER"),
+		OIDCClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:  getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/api/oidc/callback"),
+		OIDCScopes:       getEnv("OIDC_SCOPES", "openid email profile"),
+
+		MailDriver:   getEnv("MAIL_DRIVER", "log"),
+		MailFrom:     getEnv("MAIL_FROM", "no-reply@localhost"),
+		MailLogFile:  os.Getenv("MAIL_LOG_FILE"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+
+		LoginMaxAttempts:      getInt("LOGIN_MAX_ATTEMPTS", 5),
+		LoginMaxAttemptsPerIP: getInt("LOGIN_MAX_ATTEMPTS_PER_IP", 50),
+		LoginLockoutBase:      getDuration("LOGIN_LOCKOUT_BASE", time.Minute),
+		LoginLockoutMax:       getDuration("LOGIN_LOCKOUT_MAX", time.Hour),
+		LoginFailureWindow:    getDuration("LOGIN_FAILURE_WINDOW", time.Hour),
+
+		BootstrapAdminEmail: os.Getenv("BOOTSTRAP_ADMIN_EMAIL"),
+
+		AccountDeletionGrace: getDuration("ACCOUNT_DELETION_GRACE", 7*24*time.Hour),
+		AccountPurgeInterval: getDuration("ACCOUNT_PURGE_INTERVAL", time.Hour),
+
+		TrashRetention:     getDuration("TRASH_RETENTION", 30*24*time.Hour),
+		TrashPurgeInterval: getDuration("TRASH_PURGE_INTERVAL", time.Hour),
+
+		RequireIfMatch: getBool("REQUIRE_IF_MATCH", false),
+
+		ProxyHeader:    os.Getenv("PROXY_HEADER"),
+		TrustedProxies: getList("TRUSTED_PROXIES"),
+
+		AuthBackends: getListDefault("AUTH_BACKENDS", "local"),
+
+		LDAPURL:             os.Getenv("LDAP_URL"),
+		LDAPStartTLS:        getBool("LDAP_START_TLS", false),
+		LDAPBindDN:          os.Getenv("LDAP_BIND_DN"),
+		LDAPBindPassword:    os.Getenv("LDAP_BIND_PASSWORD"),
+		LDAPBaseDN:          os.Getenv("LDAP_BASE_DN"),
+		LDAPUserFilter:      getEnv("LDAP_USER_FILTER", "(mail=%s)"),
+		LDAPEmailAttr:       getEnv("LDAP_EMAIL_ATTR", "mail"),
+		LDAPUsernameAttr:    getEnv("LDAP_USERNAME_ATTR", "uid"),
+		LDAPDisplayNameAttr: getEnv("LDAP_DISPLAY_NAME_ATTR", "displayName"),
+		LDAPGroupAttr:       getEnv("LDAP_GROUP_ATTR", "memberOf"),
+		LDAPGroupRoles:      getMap("LDAP_GROUP_ROLES"),
+
+		RegistrationMode: getEnum("REGISTRATION_MODE", RegistrationOpen, RegistrationInviteOnly, RegistrationClosed),
+		InviteTTL:        getDuration("INVITE_TTL", 7*24*time.Hour),
+
+		Argon2Memory:      getInt("ARGON2_MEMORY", 64*1024),
+		Argon2Iterations:  getInt("ARGON2_ITERATIONS", 3),
+		Argon2Parallelism: getInt("ARGON2_PARALLELISM", 2),
 	}
 }
 
+const (
+	VerifyNone        = "none"
+	VerifyPublicNotes = "public-notes"
+	VerifyLogin       = "login"
+)
+
+const (
+	RegistrationOpen       = "open"
+	RegistrationInviteOnly = "invite-only"
+	RegistrationClosed     = "closed"
+)
+
 func getEnv(k, d string) string {
 	v := os.Getenv(k)
 	if v != "" {
@@ -33,6 +238,91 @@ func getEnv(k, d string) string {
 
 }
 
+// getEnum reads k, which must be one of allowed; the first allowed value is the default.
+func getEnum(k string, allowed ...string) string {
+	v := getEnv(k, allowed[0])
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	log.Fatalf("invalid value in env %s: %q (want one of %v)", k, v, allowed)
+	return ""
+}
+
+func getInt(k string, d int) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("invalid number in env %s: %q", k, v)
+	}
+	return n
+}
+
+// getList reads a comma separated list, skipping empty items.
+func getList(k string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(k), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// getListDefault is getList with a default for when k is unset.
+func getListDefault(k string, d ...string) []string {
+	if l := getList(k); len(l) > 0 {
+		return l
+	}
+	return d
+}
+
+// getMap reads "key=value;key=value". Keys may contain "=" themselves (like
+// LDAP DNs), so each pair is split at its last "=". Keys are lowercased.
+func getMap(k string) map[string]string {
+	out := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv(k), ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 || i == len(pair)-1 {
+			log.Fatalf("invalid entry in env %s: %q", k, pair)
+		}
+		out[strings.ToLower(strings.TrimSpace(pair[:i]))] = strings.TrimSpace(pair[i+1:])
+	}
+	return out
+}
+
+func getBool(k string, d bool) bool {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("invalid boolean in env %s: %q", k, v)
+	}
+	return b
+}
+
+func getDuration(k string, d time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+	dur, err := time.ParseDuration(v)
+	if err != nil || dur <= 0 {
+		log.Fatalf("invalid duration in env %s: %q", k, v)
+	}
+	return dur
+}
+
 func mustEnv(k string) string {
 	v := os.Getenv(k)
 	if v == "" {
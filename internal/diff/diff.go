@@ -0,0 +1,180 @@
+// Package diff computes line-level differences between two texts using the
+// longest common subsequence of their lines.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+)
+
+// Line is one line of an edit script turning a into b.
+type Line struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxCells bounds the LCS table. Past it the differing middle of the texts is
+// reported as deleted and re-inserted wholesale, which is still a correct,
+// if not minimal, script.
+const maxCells = 4 << 20
+
+// Lines returns the edit script from a to b.
+func Lines(a, b string) []Line {
+	x, y := split(a), split(b)
+
+	// common prefix and suffix need no table
+	pre := 0
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		suf++
+	}
+
+	out := make([]Line, 0, len(x)+len(y))
+	for _, s := range x[:pre] {
+		out = append(out, Line{Equal, s})
+	}
+	out = append(out, middle(x[pre:len(x)-suf], y[pre:len(y)-suf])...)
+	for _, s := range x[len(x)-suf:] {
+		out = append(out, Line{Equal, s})
+	}
+	return out
+}
+
+func middle(x, y []string) []Line {
+	n, m := len(x), len(y)
+	out := make([]Line, 0, n+m)
+	if (n+1)*(m+1) > maxCells {
+		for _, s := range x {
+			out = append(out, Line{Delete, s})
+		}
+		for _, s := range y {
+			out = append(out, Line{Insert, s})
+		}
+		return out
+	}
+
+	// lcs[i*(m+1)+j] is the LCS length of x[i:] and y[j:]
+	w := m + 1
+	lcs := make([]int32, (n+1)*w)
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else {
+				lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case x[i] == y[j]:
+			out = append(out, Line{Equal, x[i]})
+			i++
+			j++
+		case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+			out = append(out, Line{Delete, x[i]})
+			i++
+		default:
+			out = append(out, Line{Insert, y[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		out = append(out, Line{Delete, x[i]})
+	}
+	for ; j < m; j++ {
+		out = append(out, Line{Insert, y[j]})
+	}
+	return out
+}
+
+// split breaks s into lines. A trailing newline does not start another line.
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// Unified renders an edit script in unified diff format with the given
+// number of context lines around each change. It is empty when nothing
+// changed.
+func Unified(lines []Line, fromName, toName string, context int) string {
+	var changed []int
+	for i, l := range lines {
+		if l.Op != Equal {
+			changed = append(changed, i)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+
+	// line numbers in a and b at the start of each script entry
+	oldAt := make([]int, len(lines)+1)
+	newAt := make([]int, len(lines)+1)
+	for i, l := range lines {
+		oldAt[i+1], newAt[i+1] = oldAt[i], newAt[i]
+		if l.Op != Insert {
+			oldAt[i+1]++
+		}
+		if l.Op != Delete {
+			newAt[i+1]++
+		}
+	}
+
+	for k := 0; k < len(changed); {
+		start := max(changed[k]-context, 0)
+		end := changed[k]
+		// merge changes whose context would touch or overlap
+		for k < len(changed) && changed[k]-context <= end+context+1 {
+			end = changed[k]
+			k++
+		}
+		end = min(end+context+1, len(lines))
+
+		oldN, newN := oldAt[end]-oldAt[start], newAt[end]-newAt[start]
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldAt[start], oldN), hunkRange(newAt[start], newN))
+		for _, l := range lines[start:end] {
+			switch l.Op {
+			case Equal:
+				b.WriteString(" ")
+			case Delete:
+				b.WriteString("-")
+			case Insert:
+				b.WriteString("+")
+			}
+			b.WriteString(l.Text)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// hunkRange formats a hunk's line range; from is zero-based.
+func hunkRange(from, n int) string {
+	if n == 0 {
+		// an empty range names the line before it
+		return fmt.Sprintf("%d,0", from)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", from+1)
+	}
+	return fmt.Sprintf("%d,%d", from+1, n)
+}
@@ -0,0 +1,156 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Line
+	}{
+		{"both empty", "", "", []Line{}},
+		{"from empty", "", "a\nb", []Line{{Insert, "a"}, {Insert, "b"}}},
+		{"to empty", "a\nb\n", "", []Line{{Delete, "a"}, {Delete, "b"}}},
+		{"unchanged", "a\nb", "a\nb", []Line{{Equal, "a"}, {Equal, "b"}}},
+		{"trailing newline is not a line", "a\n", "a", []Line{{Equal, "a"}}},
+		{"blank line is a line", "a\n\n", "a\n", []Line{{Equal, "a"}, {Delete, ""}}},
+		{"insert in middle", "a\nc", "a\nb\nc", []Line{{Equal, "a"}, {Insert, "b"}, {Equal, "c"}}},
+		{"delete in middle", "a\nb\nc", "a\nc", []Line{{Equal, "a"}, {Delete, "b"}, {Equal, "c"}}},
+		{"replace", "a\nb\nc", "a\nx\nc", []Line{{Equal, "a"}, {Delete, "b"}, {Insert, "x"}, {Equal, "c"}}},
+		{"append", "a", "a\nb", []Line{{Equal, "a"}, {Insert, "b"}}},
+		{"prepend", "b", "a\nb", []Line{{Insert, "a"}, {Equal, "b"}}},
+		{
+			"keeps common lines between changes",
+			"a\nb\nc\nd\ne",
+			"x\nb\nc\ny\ne",
+			[]Line{{Delete, "a"}, {Insert, "x"}, {Equal, "b"}, {Equal, "c"}, {Delete, "d"}, {Insert, "y"}, {Equal, "e"}},
+		},
+		{
+			"moved line",
+			"a\nb\nc",
+			"b\nc\na",
+			[]Line{{Delete, "a"}, {Equal, "b"}, {Equal, "c"}, {Insert, "a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Lines(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Lines(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinesPastMaxCells(t *testing.T) {
+	// a middle too big for the table is replaced wholesale, keeping the
+	// common prefix and suffix
+	n := 2100
+	var a, b []string
+	for i := range n {
+		a = append(a, "a"+strings.Repeat("x", i%7))
+		b = append(b, "b"+strings.Repeat("x", i%7))
+	}
+	a = append([]string{"head"}, append(a, "tail")...)
+	b = append([]string{"head"}, append(b, "tail")...)
+
+	got := Lines(strings.Join(a, "\n"), strings.Join(b, "\n"))
+	if len(got) != 2*n+2 {
+		t.Fatalf("got %d lines, want %d", len(got), 2*n+2)
+	}
+	if got[0] != (Line{Equal, "head"}) || got[len(got)-1] != (Line{Equal, "tail"}) {
+		t.Fatalf("prefix or suffix not kept: %v ... %v", got[0], got[len(got)-1])
+	}
+	for i, l := range got[1 : n+1] {
+		if l.Op != Delete || l.Text != a[i+1] {
+			t.Fatalf("line %d = %v, want delete %q", i+1, l, a[i+1])
+		}
+	}
+	for i, l := range got[n+1 : 2*n+1] {
+		if l.Op != Insert || l.Text != b[i+1] {
+			t.Fatalf("line %d = %v, want insert %q", n+i+1, l, b[i+1])
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    string
+	}{
+		{"no changes", "a\nb", "a\nb", 3, ""},
+		{
+			"from empty",
+			"", "a\nb", 3,
+			"--- v0\n+++ v1\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			"to empty",
+			"a", "", 3,
+			"--- v0\n+++ v1\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			"single change with context",
+			"1\n2\n3\n4\n5\n6\n7", "1\n2\n3\nx\n5\n6\n7", 1,
+			"--- v0\n+++ v1\n@@ -3,3 +3,3 @@\n 3\n-4\n+x\n 5\n",
+		},
+		{
+			"context clipped at the edges",
+			"1\n2\n3", "x\n2\ny", 5,
+			"--- v0\n+++ v1\n@@ -1,3 +1,3 @@\n-1\n+x\n 2\n-3\n+y\n",
+		},
+		{
+			"zero context",
+			"1\n2\n3", "1\nx\n3", 0,
+			"--- v0\n+++ v1\n@@ -2 +2 @@\n-2\n+x\n",
+		},
+		{
+			"pure insert names the line before it",
+			"1\n2\n3", "1\n2\nx\n3", 0,
+			"--- v0\n+++ v1\n@@ -2,0 +3 @@\n+x\n",
+		},
+		{
+			"changes within twice the context share a hunk",
+			"1\n2\n3\n4\n5\n6", "x\n2\n3\n4\n5\ny", 2,
+			"--- v0\n+++ v1\n@@ -1,6 +1,6 @@\n-1\n+x\n 2\n 3\n 4\n 5\n-6\n+y\n",
+		},
+		{
+			"changes further apart get their own hunks",
+			"1\n2\n3\n4\n5\n6\n7", "x\n2\n3\n4\n5\n6\ny", 2,
+			"--- v0\n+++ v1\n@@ -1,3 +1,3 @@\n-1\n+x\n 2\n 3\n@@ -5,3 +5,3 @@\n 5\n 6\n-7\n+y\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Unified(Lines(tt.a, tt.b), "v0", "v1", tt.context)
+			if got != tt.want {
+				t.Fatalf("Unified() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHunkRange(t *testing.T) {
+	tests := []struct {
+		from, n int
+		want    string
+	}{
+		{0, 0, "0,0"},
+		{4, 0, "4,0"},
+		{0, 1, "1"},
+		{9, 1, "10"},
+		{0, 2, "1,2"},
+		{9, 5, "10,5"},
+	}
+	for _, tt := range tests {
+		if got := hunkRange(tt.from, tt.n); got != tt.want {
+			t.Errorf("hunkRange(%d, %d) = %q, want %q", tt.from, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/audit"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type AccessTokenHandler struct {
+	AccessTokenRepo *repo.AccessTokenRepo
+	Audit           *audit.Recorder
+}
+
+func NewAccessTokenHandler(accessTokenRepo *repo.AccessTokenRepo, rec *audit.Recorder) *AccessTokenHandler {
+	return &AccessTokenHandler{
+		AccessTokenRepo: accessTokenRepo,
+		Audit:           rec,
+	}
+}
+
+// CreateToken issues a personal access token. The raw token is returned only
+// in this response.
+func (h *AccessTokenHandler) CreateToken(c *fiber.Ctx) error {
+	var req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "name required"})
+	}
+	if len(req.Scopes) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "at least one scope required"})
+	}
+	for _, s := range req.Scopes {
+		if !slices.Contains(tokens.GrantableScopes, s) {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown scope: " + s, "allowed": tokens.GrantableScopes})
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return c.Status(400).JSON(fiber.Map{"error": "expires_at must be in the future"})
+	}
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	raw, _, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to create token"})
+	}
+	raw = tokens.PATPrefix + raw
+
+	slices.Sort(req.Scopes)
+	t := &models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      req.Name,
+		Scopes:    slices.Compact(req.Scopes),
+		TokenHash: tokens.HashToken(raw),
+		Prefix:    raw[:len(tokens.PATPrefix)+4],
+		ExpiresAt: req.ExpiresAt,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.AccessTokenRepo.Create(ctx, t); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to create token"})
+	}
+	ev := auditEvent(c, models.AuditTokenCreate, models.AuditSuccess)
+	ev.TargetType = "access_token"
+	ev.TargetID = t.ID.Hex()
+	ev.Details = map[string]string{"name": t.Name, "scopes": strings.Join(t.Scopes, " ")}
+	h.Audit.Record(ctx, ev)
+	return c.Status(201).JSON(fiber.Map{"token": raw, "access_token": t})
+}
+
+func (h *AccessTokenHandler) ListTokens(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	items, err := h.AccessTokenRepo.ListByUser(ctx, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch tokens"})
+	}
+	return c.JSON(fiber.Map{"tokens": items})
+}
+
+func (h *AccessTokenHandler) RevokeToken(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = h.AccessTokenRepo.Delete(ctx, oid, userID)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	ev := auditEvent(c, models.AuditTokenRevoke, models.AuditSuccess)
+	ev.TargetType = "access_token"
+	ev.TargetID = oid.Hex()
+	h.Audit.Record(ctx, ev)
+	return c.JSON(fiber.Map{"message": "token revoked"})
+}
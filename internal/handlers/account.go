@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/mailer"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AccountHandler serves the logged-in user's own account under /api/me.
+type AccountHandler struct {
+	Auth            *AuthHandler
+	NoteRepo        *repo.NoteRepo
+	Revisions       *repo.NoteRevisionRepo
+	AccessTokenRepo *repo.AccessTokenRepo
+	Sessions        *repo.SessionRepo
+	Passkeys        *repo.PasskeyRepo
+	AuditRepo       *repo.AuditRepo
+}
+
+func NewAccountHandler(auth *AuthHandler, noteRepo *repo.NoteRepo, revisions *repo.NoteRevisionRepo, accessTokenRepo *repo.AccessTokenRepo, sessions *repo.SessionRepo, passkeys *repo.PasskeyRepo, auditRepo *repo.AuditRepo) *AccountHandler {
+	return &AccountHandler{
+		Auth:            auth,
+		NoteRepo:        noteRepo,
+		Revisions:       revisions,
+		AccessTokenRepo: accessTokenRepo,
+		Sessions:        sessions,
+		Passkeys:        passkeys,
+		AuditRepo:       auditRepo,
+	}
+}
+
+func (h *AccountHandler) GetMe(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.Auth.users.FindByID(ctx, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch profile"})
+	}
+	if user == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(user)
+}
+
+// UpdateMe changes profile fields. Absent fields are left alone and an empty
+// string clears a field.
+func (h *AccountHandler) UpdateMe(c *fiber.Ctx) error {
+	var req struct {
+		Username    *string `json:"username"`
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+		AvatarURL   *string `json:"avatar_url"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+
+	set := bson.M{}
+	if req.Username != nil {
+		v := strings.TrimSpace(*req.Username)
+		if !models.ValidUsername(v) {
+			return c.Status(400).JSON(fiber.Map{"error": usernameRule})
+		}
+		set["username"] = v
+	}
+	if req.DisplayName != nil {
+		v := strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(v) > 100 {
+			return c.Status(400).JSON(fiber.Map{"error": "display_name too long (max 100)"})
+		}
+		set["display_name"] = v
+	}
+	if req.Bio != nil {
+		if utf8.RuneCountInString(*req.Bio) > 500 {
+			return c.Status(400).JSON(fiber.Map{"error": "bio too long (max 500)"})
+		}
+		set["bio"] = *req.Bio
+	}
+	if req.AvatarURL != nil {
+		v := strings.TrimSpace(*req.AvatarURL)
+		if v != "" {
+			u, err := url.Parse(v)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return c.Status(400).JSON(fiber.Map{"error": "avatar_url must be an http(s) URL"})
+			}
+		}
+		set["avatar_url"] = v
+	}
+	if len(set) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "nothing to update"})
+	}
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if username, ok := set["username"].(string); ok {
+		taken, err := h.Auth.users.FindByUsername(ctx, username)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to update profile"})
+		}
+		if taken != nil && taken.ID != userID {
+			return c.Status(409).JSON(fiber.Map{"error": "username already taken"})
+		}
+	}
+
+	user, err := h.Auth.users.Update(ctx, userID, set)
+	if errors.Is(err, repo.ErrDuplicate) {
+		return c.Status(409).JSON(fiber.Map{"error": "username already taken"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to update profile"})
+	}
+	if user == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(user)
+}
+
+// ChangePassword replaces the password after checking the current one. All
+// other sessions are signed out; the caller gets a fresh token pair.
+func (h *AccountHandler) ChangePassword(c *fiber.Ctx) error {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	if req.NewPassword == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "new_password required"})
+	}
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.Auth.users.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to change password"})
+	}
+	if user.DirectoryManaged {
+		return c.Status(400).JSON(fiber.Map{"error": "password is managed by your directory"})
+	}
+	if user.Password == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "account has no password, use password reset to set one"})
+	}
+	if ok, _ := h.Auth.Passwords.Verify(req.CurrentPassword, user.Password); !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid credentials"})
+	}
+
+	hash, err := h.Auth.Passwords.Hash(req.NewPassword)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to change password"})
+	}
+	if err := h.Auth.users.UpdatePassword(ctx, userID, hash); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to change password"})
+	}
+	if err := h.Auth.Tokens.RevokeAll(ctx, userID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to change password"})
+	}
+	h.Auth.Audit.Record(ctx, revocationEvent(c, "password_changed", userID))
+	return h.Auth.issueTokens(c, ctx, user)
+}
+
+// RequestEmailChange mails a confirmation link to the new address. Nothing
+// changes until the link is opened.
+func (h *AccountHandler) RequestEmailChange(c *fiber.Ctx) error {
+	var req struct {
+		NewEmail string `json:"new_email"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	req.NewEmail = models.NormalizeEmail(req.NewEmail)
+	if addr, err := mail.ParseAddress(req.NewEmail); err != nil || addr.Address != req.NewEmail {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid email"})
+	}
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.Auth.users.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to change email"})
+	}
+	if user.Password != "" {
+		if ok, _ := h.Auth.Passwords.Verify(req.Password, user.Password); !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "invalid credentials"})
+		}
+	}
+	if strings.EqualFold(req.NewEmail, user.Email) {
+		return c.Status(400).JSON(fiber.Map{"error": "that is already your email"})
+	}
+	existing, err := h.Auth.users.FindByEmail(ctx, req.NewEmail)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to change email"})
+	}
+	if existing != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "email already registered"})
+	}
+
+	token, err := h.Auth.Tokens.SignPurpose(models.PurposeEmailChange, jwt.MapClaims{
+		"user_id":   user.ID,
+		"email":     user.Email,
+		"new_email": req.NewEmail,
+	}, h.Auth.Config.EmailVerificationTTL)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to change email"})
+	}
+
+	link := h.Auth.Config.AppBaseURL + "/api/me/email/confirm?token=" + url.QueryEscape(token)
+	go h.Auth.sendMail(mailer.Message{
+		To:      req.NewEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Open this link within %s to use this address for your account:\n%s\n\n"+
+			"If you did not ask for this, you can ignore this email.", h.Auth.Config.EmailVerificationTTL, link),
+	})
+	go h.Auth.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf("Someone asked to change your account's email to %s. It only takes effect once confirmed from that inbox.\n\n"+
+			"If it wasn't you, change your password now.", req.NewEmail),
+	})
+	return c.Status(202).JSON(fiber.Map{"message": "confirmation link sent to the new address"})
+}
+
+// ConfirmEmailChange applies a change from the link sent by RequestEmailChange.
+func (h *AccountHandler) ConfirmEmailChange(c *fiber.Ctx) error {
+	claims, err := h.Auth.Tokens.ParsePurpose(models.PurposeEmailChange, c.Query("token"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+	uidStr, _ := claims["user_id"].(string)
+	oldEmail, _ := claims["email"].(string)
+	newEmail, _ := claims["new_email"].(string)
+	uid, err := primitive.ObjectIDFromHex(uidStr)
+	if err != nil || oldEmail == "" || newEmail == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	existing, err := h.Auth.users.FindByEmail(ctx, newEmail)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to change email"})
+	}
+	if existing != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "email already registered"})
+	}
+	ok, err := h.Auth.users.ChangeEmail(ctx, uid, oldEmail, newEmail)
+	if errors.Is(err, repo.ErrDuplicate) {
+		return c.Status(409).JSON(fiber.Map{"error": "email already registered"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to change email"})
+	}
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+	return c.JSON(fiber.Map{"message": "email changed"})
+}
+
+// Export sends a zip archive of everything stored about the user.
+func (h *AccountHandler) Export(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user, err := h.Auth.users.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to export"})
+	}
+	notes, err := h.NoteRepo.ListAllByUser(ctx, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to export"})
+	}
+	revisions, err := h.Revisions.ListByUser(ctx, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to export"})
+	}
+	accessTokens, err := h.AccessTokenRepo.ListByUser(ctx, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to export"})
+	}
+	sessions, err := h.Sessions.ListByUser(ctx, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to export"})
+	}
+	passkeys, err := h.Passkeys.ListByUser(ctx, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to export"})
+	}
+	events, err := h.AuditRepo.ListByUser(ctx, userID, user.Email)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to export"})
+	}
+	// identities are left out of the profile's JSON, so they get a file
+	identities := user.Identities
+	if identities == nil {
+		identities = []models.Identity{}
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", user},
+		{"identities.json", identities},
+		{"notes.json", notes},
+		{"note_revisions.json", revisions},
+		{"access_tokens.json", accessTokens},
+		{"sessions.json", sessions},
+		{"passkeys.json", passkeys},
+		{"audit_events.json", events},
+	}
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to export"})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to export"})
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to export"})
+	}
+
+	c.Attachment(fmt.Sprintf("notes-export-%s.zip", time.Now().UTC().Format("20060102")))
+	return c.Send(buf.Bytes())
+}
+
+// RequestDeletion schedules the account for deletion after the grace period.
+// The caller confirms with their password (if the account has one) and by
+// repeating their email address.
+func (h *AccountHandler) RequestDeletion(c *fiber.Ctx) error {
+	var req struct {
+		Password string `json:"password"`
+		Confirm  string `json:"confirm"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.Auth.users.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to schedule deletion"})
+	}
+	if user.Password != "" {
+		if ok, _ := h.Auth.Passwords.Verify(req.Password, user.Password); !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "invalid credentials"})
+		}
+	}
+	if !strings.EqualFold(strings.TrimSpace(req.Confirm), user.Email) {
+		return c.Status(400).JSON(fiber.Map{"error": "confirm must be your email address"})
+	}
+	if user.DeletionScheduledFor != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "deletion already scheduled", "deletion_scheduled_for": user.DeletionScheduledFor})
+	}
+
+	when := time.Now().UTC().Add(h.Auth.Config.AccountDeletionGrace)
+	if err := h.Auth.users.ScheduleDeletion(ctx, userID, when); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to schedule deletion"})
+	}
+	go h.Auth.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your account is scheduled for deletion",
+		Body: fmt.Sprintf("Your account and all its notes will be permanently deleted on %s.\n\n"+
+			"Changed your mind? Log in and cancel the deletion before then.", when.Format(time.RFC1123)),
+	})
+	return c.Status(202).JSON(fiber.Map{"message": "account scheduled for deletion", "deletion_scheduled_for": when})
+}
+
+// CancelDeletion withdraws a pending deletion request.
+func (h *AccountHandler) CancelDeletion(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ok, err := h.Auth.users.CancelDeletion(ctx, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to cancel deletion"})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "no pending deletion"})
+	}
+	return c.JSON(fiber.Map{"message": "deletion cancelled"})
+}
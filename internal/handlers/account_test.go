@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type accountTest struct {
+	*testAuth
+	app *fiber.App
+}
+
+// newAccountTest serves the /me endpoints for the given users, signed in as
+// described at signedIn.
+func newAccountTest(t *testing.T, users ...*models.User) *accountTest {
+	t.Helper()
+	ta := newTestAuth(t, &config.Config{AppBaseURL: "https://notes.example.com", EmailVerificationTTL: time.Hour})
+	for _, u := range users {
+		u.UsernameKey = models.FoldUsername(u.Username)
+	}
+	ta.users.users = users
+	h := &AccountHandler{Auth: ta.AuthHandler}
+
+	at := &accountTest{testAuth: ta, app: fiber.New()}
+	at.app.Patch("/me", signedIn, h.UpdateMe)
+	at.app.Put("/me/password", signedIn, h.ChangePassword)
+	at.app.Post("/me/email", signedIn, h.RequestEmailChange)
+	at.app.Get("/me/email/confirm", h.ConfirmEmailChange)
+	return at
+}
+
+func TestUpdateMe(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       map[string]any
+		wantStatus int
+		want       models.User // the profile fields after the request
+	}{
+		{"sets fields", map[string]any{"display_name": "  Ada L.  ", "bio": "hi", "avatar_url": "https://img.example.com/a.png"}, 200,
+			models.User{DisplayName: "Ada L.", Bio: "hi", AvatarURL: "https://img.example.com/a.png"}},
+		{"leaves absent fields alone", map[string]any{"bio": "new"}, 200,
+			models.User{DisplayName: "Ada", Bio: "new", AvatarURL: "https://img.example.com/old.png"}},
+		{"empty string clears", map[string]any{"display_name": "", "avatar_url": ""}, 200,
+			models.User{Bio: "old"}},
+		{"display name too long", map[string]any{"display_name": strings.Repeat("x", 101)}, 400,
+			models.User{DisplayName: "Ada", Bio: "old", AvatarURL: "https://img.example.com/old.png"}},
+		{"bio too long", map[string]any{"bio": strings.Repeat("é", 501)}, 400,
+			models.User{DisplayName: "Ada", Bio: "old", AvatarURL: "https://img.example.com/old.png"}},
+		{"avatar must be http(s)", map[string]any{"avatar_url": "javascript:alert(1)"}, 400,
+			models.User{DisplayName: "Ada", Bio: "old", AvatarURL: "https://img.example.com/old.png"}},
+		{"nothing to update", map[string]any{}, 400,
+			models.User{DisplayName: "Ada", Bio: "old", AvatarURL: "https://img.example.com/old.png"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{ID: primitive.NewObjectID(), Email: "ada@example.com", Username: "ada",
+				DisplayName: "Ada", Bio: "old", AvatarURL: "https://img.example.com/old.png"}
+			at := newAccountTest(t, user)
+
+			status, body := sendJSON(t, at.app, "PATCH", "/me", user, tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if status == 200 && body["display_name"] != nilIfEmpty(tt.want.DisplayName) {
+				t.Errorf("response display_name = %v, want %q", body["display_name"], tt.want.DisplayName)
+			}
+			got := at.users.byID(user.ID)
+			if got.DisplayName != tt.want.DisplayName || got.Bio != tt.want.Bio || got.AvatarURL != tt.want.AvatarURL {
+				t.Errorf("profile = %q/%q/%q, want %q/%q/%q", got.DisplayName, got.Bio, got.AvatarURL,
+					tt.want.DisplayName, tt.want.Bio, tt.want.AvatarURL)
+			}
+		})
+	}
+}
+
+// nilIfEmpty is how an omitempty string field decodes from a response.
+func nilIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func TestChangePassword(t *testing.T) {
+	hasher := newTestHasher(t)
+	hash, err := hasher.Hash("old password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		user       models.User
+		body       map[string]any
+		wantStatus int
+	}{
+		{"changed", models.User{Password: hash}, map[string]any{"current_password": "old password", "new_password": "new password"}, 200},
+		{"wrong current password", models.User{Password: hash}, map[string]any{"current_password": "guess", "new_password": "new password"}, 401},
+		{"no new password", models.User{Password: hash}, map[string]any{"current_password": "old password"}, 400},
+		{"passwordless account", models.User{}, map[string]any{"current_password": "", "new_password": "new password"}, 400},
+		{"directory account", models.User{Password: hash, DirectoryManaged: true}, map[string]any{"current_password": "old password", "new_password": "new password"}, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := tt.user
+			user.ID, user.Email = primitive.NewObjectID(), "ada@example.com"
+			at := newAccountTest(t, &user)
+			at.Passwords = hasher
+			if _, err := at.Tokens.Issue(t.Context(), &user, tokens.Client{}); err != nil {
+				t.Fatal(err)
+			}
+
+			status, body := sendJSON(t, at.app, "PUT", "/me/password", &user, tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			changed := status == 200
+			if ok, _ := hasher.Verify("new password", at.users.byID(user.ID).Password); ok != changed {
+				t.Errorf("new password accepted = %v after status %d", ok, status)
+			}
+			// the old session is gone and only the pair just handed out is live
+			if n := at.sessions.live(user.ID); n != 1 {
+				t.Errorf("%d live sessions, want 1", n)
+			}
+			if !changed {
+				if ev := at.audit.last(); ev != nil {
+					t.Errorf("recorded %s for a refused change", ev.Action)
+				}
+				return
+			}
+			access, _ := body["token"].(string)
+			if _, err := at.Tokens.Parse(t.Context(), access, ""); err != nil {
+				t.Errorf("new access token: %v", err)
+			}
+			ev := at.audit.last()
+			if ev == nil || ev.Action != models.AuditRevocation || ev.Details["reason"] != "password_changed" || ev.TargetID != user.ID.Hex() {
+				t.Errorf("audit event = %+v, want a password_changed revocation of the user", ev)
+			}
+		})
+	}
+}
+
+func TestRequestEmailChange(t *testing.T) {
+	hasher := newTestHasher(t)
+	hash, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		password   string // the account's hash, if any
+		body       map[string]any
+		wantStatus int
+	}{
+		{"link sent", hash, map[string]any{"new_email": "Ada.New@Example.com", "password": "secret"}, 202},
+		{"passwordless account", "", map[string]any{"new_email": "ada.new@example.com"}, 202},
+		{"wrong password", hash, map[string]any{"new_email": "ada.new@example.com", "password": "guess"}, 401},
+		{"invalid email", hash, map[string]any{"new_email": "Ada <ada.new@example.com>", "password": "secret"}, 400},
+		{"same email in another case", hash, map[string]any{"new_email": "ADA@example.com", "password": "secret"}, 400},
+		{"taken by another account", hash, map[string]any{"new_email": "bob@example.com", "password": "secret"}, 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{ID: primitive.NewObjectID(), Email: "ada@example.com", Password: tt.password}
+			other := &models.User{ID: primitive.NewObjectID(), Email: "bob@example.com"}
+			at := newAccountTest(t, user, other)
+			at.Passwords = hasher
+
+			status, body := sendJSON(t, at.app, "POST", "/me/email", user, tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			// nothing changes until the link is opened
+			if got := at.users.byID(user.ID).Email; got != "ada@example.com" {
+				t.Errorf("email = %q before confirming", got)
+			}
+		})
+	}
+}
+
+func TestConfirmEmailChange(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      func(at *accountTest, user *models.User) string
+		wantStatus int
+		wantEmail  string
+	}{
+		{
+			"changed",
+			func(at *accountTest, user *models.User) string {
+				return emailChangeToken(t, at, user.ID.Hex(), "ada@example.com", "ada.new@example.com")
+			},
+			200, "ada.new@example.com",
+		},
+		{
+			"address taken since the link was sent",
+			func(at *accountTest, user *models.User) string {
+				return emailChangeToken(t, at, user.ID.Hex(), "ada@example.com", "bob@example.com")
+			},
+			409, "ada@example.com",
+		},
+		{
+			"email changed since the link was sent",
+			func(at *accountTest, user *models.User) string {
+				return emailChangeToken(t, at, user.ID.Hex(), "ada.old@example.com", "ada.new@example.com")
+			},
+			400, "ada@example.com",
+		},
+		{
+			"verification token",
+			func(at *accountTest, user *models.User) string {
+				token, err := at.Tokens.SignPurpose(models.PurposeEmailVerification, jwt.MapClaims{
+					"user_id": user.ID.Hex(), "email": "ada@example.com", "new_email": "ada.new@example.com",
+				}, time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return token
+			},
+			400, "ada@example.com",
+		},
+		{"garbage", func(*accountTest, *models.User) string { return "not-a-token" }, 400, "ada@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{ID: primitive.NewObjectID(), Email: "ada@example.com"}
+			other := &models.User{ID: primitive.NewObjectID(), Email: "bob@example.com"}
+			at := newAccountTest(t, user, other)
+
+			status, body := sendJSON(t, at.app, "GET", "/me/email/confirm?token="+tt.token(at, user), nil, nil)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if got := at.users.byID(user.ID).Email; got != tt.wantEmail {
+				t.Errorf("email = %q, want %q", got, tt.wantEmail)
+			}
+		})
+	}
+}
+
+func emailChangeToken(t *testing.T, at *accountTest, userID, email, newEmail string) string {
+	t.Helper()
+	token, err := at.Tokens.SignPurpose(models.PurposeEmailChange, jwt.MapClaims{
+		"user_id": userID, "email": email, "new_email": newEmail,
+	}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestUpdateMeUsername(t *testing.T) {
+	tests := []struct {
+		name       string
+		username   string
+		stale      bool // the lookup misses a name taken concurrently
+		wantStatus int
+		want       string
+	}{
+		{"free", "ada_lovelace", false, 200, "ada_lovelace"},
+		{"own name in another case", "Ada", false, 200, "Ada"},
+		{"own name", "ada", false, 200, "ada"},
+		{"trimmed", "  grace  ", false, 200, "grace"},
+		{"taken", "Bob", false, 409, "ada"},
+		{"taken in another case", "BOB", false, 409, "ada"},
+		{"taken during the request", "bob", true, 409, "ada"},
+		{"invalid", "ada!", false, 400, "ada"},
+		{"empty", "", false, 400, "ada"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{ID: primitive.NewObjectID(), Email: "ada@example.com", Username: "ada"}
+			other := &models.User{ID: primitive.NewObjectID(), Email: "bob@example.com", Username: "Bob"}
+			at := newAccountTest(t, user, other)
+			if tt.stale {
+				at.AuthHandler.users = staleUsers{at.users}
+			}
+
+			status, body := sendJSON(t, at.app, "PATCH", "/me", user, map[string]any{"username": tt.username})
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			if got := at.users.byID(user.ID); got.Username != tt.want || got.UsernameKey != models.FoldUsername(tt.want) {
+				t.Errorf("username = %q (key %q), want %q", got.Username, got.UsernameKey, tt.want)
+			}
+			if got := at.users.byID(other.ID).Username; got != "Bob" {
+				t.Errorf("other account's username = %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SetUserRole is an admin action that changes a user's role. The user's
+// tokens are revoked so the new role applies from their next login.
+func (h *AuthHandler) SetUserRole(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	var req struct {
+		Role models.Role `json:"role"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	if !req.Role.Valid() {
+		return c.Status(400).JSON(fiber.Map{"error": "role must be one of user, moderator, admin"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ok, err := h.users.SetRole(ctx, oid, req.Role)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to set role"})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err := h.Tokens.RevokeAll(ctx, oid); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to set role"})
+	}
+	ev := auditEvent(c, models.AuditRoleChange, models.AuditSuccess)
+	ev.TargetType = "user"
+	ev.TargetID = oid.Hex()
+	ev.Details = map[string]string{"role": string(req.Role)}
+	h.Audit.Record(ctx, ev)
+	return c.JSON(fiber.Map{"message": "role updated", "role": req.Role})
+}
+
+// UnlockLogin is an admin action that lifts a lockout for an email and/or address.
+func (h *AuthHandler) UnlockLogin(c *fiber.Ctx) error {
+	var req struct {
+		Email string `json:"email"`
+		IP    string `json:"ip"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	if req.Email == "" && req.IP == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "email or ip required"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := h.Lockout.Unlock(ctx, req.Email, req.IP)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to unlock"})
+	}
+	return c.JSON(fiber.Map{"message": "unlocked", "cleared": n})
+}
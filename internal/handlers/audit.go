@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/audit"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/middleware"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	syslogAppName   = "notes_sharing_api"
+	maxAuditResults = 1000
+)
+
+type AuditHandler struct {
+	AuditRepo *repo.AuditRepo
+	hostname  string
+}
+
+func NewAuditHandler(auditRepo *repo.AuditRepo) *AuditHandler {
+	hostname, _ := os.Hostname()
+	return &AuditHandler{
+		AuditRepo: auditRepo,
+		hostname:  hostname,
+	}
+}
+
+// ListEvents is an admin action that searches the audit log, newest first.
+// format=jsonl and format=syslog return the same page as an export; the
+// cursor for the next page is then in the X-Next-Before header.
+func (h *AuditHandler) ListEvents(c *fiber.Ctx) error {
+	f := repo.AuditFilter{
+		Action:   c.Query("action"),
+		Outcome:  c.Query("outcome"),
+		TargetID: c.Query("target_id"),
+		IP:       c.Query("ip"),
+	}
+	if v := c.Query("actor"); v != "" {
+		oid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid actor"})
+		}
+		f.ActorID = &oid
+	}
+	if v := c.Query("before"); v != "" {
+		oid, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid before"})
+		}
+		f.Before = &oid
+	}
+	for name, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": name + " must be an RFC 3339 time"})
+			}
+			*dst = t
+		}
+	}
+	limit, _ := strconv.Atoi(c.Query("limit", "100"))
+	if limit < 1 || limit > maxAuditResults {
+		limit = 100
+	}
+	format := c.Query("format", "json")
+	if format != "json" && format != "jsonl" && format != "syslog" {
+		return c.Status(400).JSON(fiber.Map{"error": "format must be one of json, jsonl, syslog"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events, err := h.AuditRepo.List(ctx, f, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch audit events"})
+	}
+	var next string
+	if len(events) == limit {
+		next = events[len(events)-1].ID.Hex()
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jsonl":
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+		err = audit.WriteJSONLines(&buf, events)
+	case "syslog":
+		c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+		err = audit.WriteSyslog(&buf, events, h.hostname, syslogAppName)
+	default:
+		return c.JSON(fiber.Map{"events": events, "next_before": next})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to export audit events"})
+	}
+	if next != "" {
+		c.Set("X-Next-Before", next)
+	}
+	return c.Send(buf.Bytes())
+}
+
+// auditEvent starts an event for the current request, filled in with the
+// caller (if authenticated) and the client's address and user agent.
+func auditEvent(c *fiber.Ctx, action, outcome string) *models.AuditEvent {
+	ev := &models.AuditEvent{
+		Action:    action,
+		Outcome:   outcome,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+	if sub := middleware.Subject(c); sub.Authenticated() {
+		ev.ActorID = &sub.UserID
+	}
+	return ev
+}
+
+// revocationEvent is auditEvent for tokens the server revoked on its own,
+// rather than at the user's request; reason says why.
+func revocationEvent(c *fiber.Ctx, reason string, userID primitive.ObjectID) *models.AuditEvent {
+	ev := auditEvent(c, models.AuditRevocation, models.AuditSuccess)
+	ev.TargetType = "user"
+	ev.TargetID = userID.Hex()
+	ev.Details = map[string]string{"reason": reason}
+	return ev
+}
+
+// noteEvent is auditEvent for an action on a note.
+func noteEvent(c *fiber.Ctx, action string, n *models.Note) *models.AuditEvent {
+	ev := auditEvent(c, action, models.AuditSuccess)
+	ev.TargetType = "note"
+	ev.TargetID = n.ID.Hex()
+	ev.Details = map[string]string{}
+	// moderators and admins act on other people's notes
+	if ev.ActorID == nil || *ev.ActorID != n.UserID {
+		ev.Details["owner"] = n.UserID.Hex()
+	}
+	return ev
+}
@@ -2,63 +2,179 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"log"
+	"net/mail"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/audit"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/authn"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/lockout"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/mailer"
 	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/password"
 	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// authUsers is the part of repo.UserRepo the account and login handlers need.
+type authUsers interface {
+	Create(ctx context.Context, user *models.User) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	Update(ctx context.Context, id primitive.ObjectID, set bson.M) (*models.User, error)
+	UpdatePassword(ctx context.Context, id primitive.ObjectID, hash string) error
+	ChangeEmail(ctx context.Context, id primitive.ObjectID, oldEmail, newEmail string) (bool, error)
+	MarkEmailVerified(ctx context.Context, id primitive.ObjectID, email string) (bool, error)
+	SetRole(ctx context.Context, id primitive.ObjectID, role models.Role) (bool, error)
+	SetPendingTOTP(ctx context.Context, id primitive.ObjectID, secret string) (bool, error)
+	EnableTOTP(ctx context.Context, id primitive.ObjectID, step int64, recoveryCodes []string) error
+	DisableTOTP(ctx context.Context, id primitive.ObjectID) error
+	AdvanceTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error)
+	ConsumeRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) (bool, error)
+	ScheduleDeletion(ctx context.Context, id primitive.ObjectID, when time.Time) error
+	CancelDeletion(ctx context.Context, id primitive.ObjectID) (bool, error)
+}
+
+// inviteUses is the part of repo.InviteRepo registration needs.
+type inviteUses interface {
+	Use(ctx context.Context, hash string) (*models.Invite, error)
+	Release(ctx context.Context, id primitive.ObjectID) error
+}
+
 type AuthHandler struct {
-	UserRepo  *repo.UserRepo
-	JWTSecret string
+	UserRepo      *repo.UserRepo
+	OneTimeTokens *repo.OneTimeTokenRepo
+	Invites       *repo.InviteRepo
+	Tokens        *tokens.Service
+	Mailer        mailer.Mailer
+	Lockout       *lockout.Guard
+	Passwords     *password.Hasher
+	Authenticator authn.Authenticator
+	Audit         *audit.Recorder
+	Config        *config.Config
+
+	users   authUsers
+	invites inviteUses
 }
 
-func NewAuthHandler(userRepo *repo.UserRepo, jwtSecret string) *AuthHandler {
+func NewAuthHandler(userRepo *repo.UserRepo, oneTimeTokens *repo.OneTimeTokenRepo, invites *repo.InviteRepo, tokenSvc *tokens.Service, m mailer.Mailer, guard *lockout.Guard, passwords *password.Hasher, authenticator authn.Authenticator, rec *audit.Recorder, cfg *config.Config) *AuthHandler {
 	return &AuthHandler{
-		UserRepo:  userRepo,
-		JWTSecret: jwtSecret,
+		UserRepo:      userRepo,
+		OneTimeTokens: oneTimeTokens,
+		Invites:       invites,
+		Tokens:        tokenSvc,
+		Mailer:        m,
+		Lockout:       guard,
+		Passwords:     passwords,
+		Authenticator: authenticator,
+		Audit:         rec,
+		Config:        cfg,
+		users:         userRepo,
+		invites:       invites,
 	}
 }
 
+// Register creates a password account, subject to the registration mode:
+// invite-only instances take one use of invite_code per account.
 func (h *AuthHandler) Register(c *fiber.Ctx) error {
 	var req struct {
-		Username string `json:"username"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Username   string `json:"username"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		InviteCode string `json:"invite_code"`
+	}
+	if h.Config.RegistrationMode == config.RegistrationClosed {
+		return c.Status(403).JSON(fiber.Map{"error": "registration is closed"})
 	}
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
 	}
+	req.Email = models.NormalizeEmail(req.Email)
+	inviteOnly := h.Config.RegistrationMode == config.RegistrationInviteOnly
+	if inviteOnly && req.InviteCode == "" {
+		return c.Status(403).JSON(fiber.Map{"error": "an invite code is required"})
+	}
 
 	if req.Email == "" || req.Password == "" {
 		return c.Status(400).JSON(fiber.Map{"error": "email and password required"})
 	}
+	if req.Username != "" && !models.ValidUsername(req.Username) {
+		return c.Status(400).JSON(fiber.Map{"error": usernameRule})
+	}
+	if addr, err := mail.ParseAddress(req.Email); err != nil || addr.Address != req.Email {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid email"})
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	existing, _ := h.UserRepo.FindByEmail(ctx, req.Email)
+	existing, _ := h.users.FindByEmail(ctx, req.Email)
 	if existing != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "email already registered"})
 	}
-	hash, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if req.Username != "" {
+		taken, err := h.users.FindByUsername(ctx, req.Username)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to create user"})
+		}
+		if taken != nil {
+			return c.Status(409).JSON(fiber.Map{"error": "username already taken"})
+		}
+	}
+	hash, err := h.Passwords.Hash(req.Password)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to create user"})
+	}
 	u := &models.User{
 		Username: req.Username,
 		Email:    req.Email,
-		Password: string(hash),
+		Password: hash,
 	}
 
-	if err := h.UserRepo.Create(ctx, u); err != nil {
+	// take the invite last so failed validation does not burn a use
+	var invite *models.Invite
+	if inviteOnly {
+		invite, err = h.invites.Use(ctx, tokens.HashToken(req.InviteCode))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to create user"})
+		}
+		if invite == nil {
+			return c.Status(403).JSON(fiber.Map{"error": "invalid or expired invite code"})
+		}
+	}
+	if err := h.users.Create(ctx, u); err != nil {
+		if invite != nil {
+			if err := h.invites.Release(ctx, invite.ID); err != nil {
+				log.Printf("failed to release invite %s: %v", invite.ID.Hex(), err)
+			}
+		}
+		if errors.Is(err, repo.ErrDuplicate) {
+			return c.Status(409).JSON(fiber.Map{"error": "email or username already taken"})
+		}
 		return c.Status(500).JSON(fiber.Map{"error": "failed to create user"})
 	}
+	if err := h.sendVerification(u); err != nil {
+		log.Printf("failed to send verification email: %v", err)
+	}
 	// return basic user info
-	return c.Status(201).JSON(fiber.Map{"message": "user created"})
+	return c.Status(201).JSON(fiber.Map{"message": "user created, check your email to verify your address"})
 
 }
 
+const usernameRule = "username must be 3-30 letters, digits or underscores"
+
+// Login checks email and password against the configured authentication
+// backends. Unknown emails and wrong passwords get the same response, and
+// repeated failures lock the account and the client address out for a
+// growing period.
 func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	var req struct {
 		Email    string `json:"email"`
@@ -68,23 +184,159 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
 	}
+	req.Email = models.NormalizeEmail(req.Email)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	user, err := h.UserRepo.FindByEmail(ctx, req.Email)
-	if err != nil || user == nil {
-		return c.Status(401).JSON(fiber.Map{"error": "invalid credentials"})
+	ip := c.IP()
+	wait, err := h.Lockout.Check(ctx, req.Email, ip)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+	}
+	if wait > 0 {
+		ev := loginEvent(c, models.AuditFailure, "password", nil)
+		ev.Email = req.Email
+		ev.Details["reason"] = "locked_out"
+		h.Audit.Record(ctx, ev)
+		return tooManyAttempts(c, wait)
+	}
+
+	user, err := h.Authenticator.Authenticate(ctx, req.Email, req.Password)
+	var link *authn.LinkRequiredError
+	if errors.As(err, &link) {
+		// the password was right, so this is not a failed attempt
+		ev := loginEvent(c, models.AuditFailure, "password", link.User)
+		ev.Details["reason"] = "link_required"
+		h.Audit.Record(ctx, ev)
+		return h.linkRequired(c, link.User, link.Identity)
+	}
+	if errors.Is(err, authn.ErrRegistrationClosed) {
+		ev := loginEvent(c, models.AuditFailure, "password", nil)
+		ev.Email = req.Email
+		ev.Details["reason"] = "registration_closed"
+		h.Audit.Record(ctx, ev)
+		return c.Status(403).JSON(fiber.Map{"error": "no account for this login and registration is closed"})
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "invalid credentials"})
+	if err != nil {
+		// a backend failure counts as a failed attempt as well, so that
+		// logins which make the directory error out are still rate limited
+		ev := loginEvent(c, models.AuditFailure, "password", nil)
+		ev.Email = req.Email
+		if !errors.Is(err, authn.ErrInvalidCredentials) {
+			ev.Details["reason"] = "backend_error"
+		}
+		h.Audit.Record(ctx, ev)
+		if err := h.Lockout.Fail(ctx, req.Email, ip); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+		}
+		if errors.Is(err, authn.ErrInvalidCredentials) {
+			return c.Status(401).JSON(fiber.Map{"error": "invalid credentials"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+	}
+	if h.Config.EmailVerificationPolicy == config.VerifyLogin && !user.EmailVerified {
+		return c.Status(403).JSON(fiber.Map{"error": "email not verified"})
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+	return h.finishLogin(c, ctx, user, "password")
+}
+
+func tooManyAttempts(c *fiber.Ctx, wait time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(wait.Seconds())+1))
+	return c.Status(429).JSON(fiber.Map{"error": "too many failed attempts, try again later"})
+}
+
+// linkRequired answers a login whose identity matches an existing account
+// that has credentials of its own. The returned link_token lets the owner,
+// once logged in to that account, confirm the link with Link.
+func (h *AuthHandler) linkRequired(c *fiber.Ctx, user *models.User, id models.Identity) error {
+	link, err := h.Tokens.SignPurpose(models.PurposeIdentityLink, jwt.MapClaims{
+		"user_id": user.ID.Hex(),
+		"idp_iss": id.Issuer,
+		"idp_sub": id.Subject,
+	}, identityLinkTTL)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to sign in"})
+	}
+	return c.Status(409).JSON(fiber.Map{
+		"error":      "an account with this email already exists; log in to it and confirm the link",
+		"link_token": link,
 	})
+}
 
-	tokenStr, _ := token.SignedString([]byte(h.JWTSecret))
-	return c.JSON(fiber.Map{"token": tokenStr})
+// Refresh rotates a refresh token into a new access/refresh pair.
+func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pair, err := h.Tokens.Refresh(ctx, req.RefreshToken)
+	var reuse *tokens.ReuseError
+	if errors.As(err, &reuse) {
+		ev := revocationEvent(c, "reuse_detected", reuse.UserID)
+		ev.TargetType = "session"
+		ev.TargetID = reuse.SessionID.Hex()
+		ev.Details["user_id"] = reuse.UserID.Hex()
+		h.Audit.Record(ctx, ev)
+	}
+	switch {
+	case errors.Is(err, tokens.ErrInvalidRefreshToken), errors.Is(err, tokens.ErrRefreshTokenReused):
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(500).JSON(fiber.Map{"error": "failed to refresh token"})
+	}
+	return c.JSON(pair)
+}
+
+// Logout revokes the presented access token and ends its session, which also
+// invalidates the refresh token issued alongside it.
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// the body is optional
+	_ = c.BodyParser(&req)
+
+	claims, ok := c.Locals("claims").(*tokens.Claims)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Tokens.Revoke(ctx, claims, req.RefreshToken); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to logout"})
+	}
+	ev := auditEvent(c, models.AuditLogout, models.AuditSuccess)
+	if !claims.SessionID.IsZero() {
+		ev.TargetType = "session"
+		ev.TargetID = claims.SessionID.Hex()
+	}
+	h.Audit.Record(ctx, ev)
+	return c.JSON(fiber.Map{"message": "logged out"})
+}
+
+// LogoutAll revokes every token the user holds on any device.
+func (h *AuthHandler) LogoutAll(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*tokens.Claims)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Tokens.RevokeAll(ctx, claims.UserID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to logout"})
+	}
+	h.Audit.Record(ctx, auditEvent(c, models.AuditLogoutAll, models.AuditSuccess))
+	return c.JSON(fiber.Map{"message": "logged out from all devices"})
 }
@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/authn"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/lockout"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeThrottles is an in-memory lockout.Store.
+type fakeThrottles struct {
+	mu        sync.Mutex
+	throttles map[string]*models.LoginThrottle
+}
+
+func (f *fakeThrottles) Find(ctx context.Context, keys ...string) ([]models.LoginThrottle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []models.LoginThrottle
+	for _, k := range keys {
+		if t, ok := f.throttles[k]; ok {
+			out = append(out, *t)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeThrottles) RecordFailure(ctx context.Context, key string, window time.Duration) (*models.LoginThrottle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.throttles == nil {
+		f.throttles = map[string]*models.LoginThrottle{}
+	}
+	t, ok := f.throttles[key]
+	if !ok {
+		t = &models.LoginThrottle{Key: key}
+		f.throttles[key] = t
+	}
+	t.Failures++
+	return t, nil
+}
+
+func (f *fakeThrottles) Lock(ctx context.Context, key string, until, expiresAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.throttles[key].LockedUntil = until
+	return nil
+}
+
+func (f *fakeThrottles) Clear(ctx context.Context, keys ...string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int64
+	for _, k := range keys {
+		if _, ok := f.throttles[k]; ok {
+			delete(f.throttles, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// authenticatorFunc adapts a function to authn.Authenticator.
+type authenticatorFunc func(ctx context.Context, login, password string) (*models.User, error)
+
+func (f authenticatorFunc) Authenticate(ctx context.Context, login, password string) (*models.User, error) {
+	return f(ctx, login, password)
+}
+
+func TestLoginCountsFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantReason string
+	}{
+		{"wrong password", authn.ErrInvalidCredentials, 401, ""},
+		{"backend error", errors.New("directory unreachable"), 500, "backend_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				LoginMaxAttempts:      3,
+				LoginMaxAttemptsPerIP: 100,
+				LoginLockoutBase:      time.Minute,
+				LoginLockoutMax:       time.Hour,
+				LoginFailureWindow:    time.Hour,
+			}
+			h, store := newTestAuthHandler(t, cfg)
+			h.Lockout = lockout.NewGuard(&fakeThrottles{}, cfg)
+			h.Authenticator = authenticatorFunc(func(context.Context, string, string) (*models.User, error) {
+				return nil, tt.err
+			})
+			app := fiber.New()
+			app.Post("/login", h.Login)
+
+			login := func() int {
+				req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"a@example.com","password":"x"}`))
+				req.Header.Set("Content-Type", "application/json")
+				resp, err := app.Test(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return resp.StatusCode
+			}
+			for i := 0; i < cfg.LoginMaxAttempts; i++ {
+				if status := login(); status != tt.wantStatus {
+					t.Fatalf("attempt %d: status = %d, want %d", i+1, status, tt.wantStatus)
+				}
+				ev := store.last()
+				if ev == nil || ev.Outcome != models.AuditFailure || ev.Details["reason"] != tt.wantReason {
+					t.Errorf("attempt %d: audit event = %+v", i+1, ev)
+				}
+			}
+			if status := login(); status != 429 {
+				t.Errorf("status after %d failures = %d, want 429", cfg.LoginMaxAttempts, status)
+			}
+		})
+	}
+}
+
+func TestLoginAsksToConfirmLink(t *testing.T) {
+	cfg := &config.Config{
+		LoginMaxAttempts:      3,
+		LoginMaxAttemptsPerIP: 100,
+		LoginLockoutBase:      time.Minute,
+		LoginLockoutMax:       time.Hour,
+		LoginFailureWindow:    time.Hour,
+	}
+	h, store := newTestAuthHandler(t, cfg)
+	throttles := &fakeThrottles{}
+	h.Lockout = lockout.NewGuard(throttles, cfg)
+	owner := &models.User{ID: primitive.NewObjectID(), Email: "a@example.com", Password: "hash"}
+	identity := models.Identity{Issuer: "ldap://dir.example.com", Subject: "uid=a,dc=example,dc=com"}
+	h.Authenticator = authenticatorFunc(func(context.Context, string, string) (*models.User, error) {
+		return nil, &authn.LinkRequiredError{User: owner, Identity: identity}
+	})
+	app := fiber.New()
+	app.Post("/login", h.Login)
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"a@example.com","password":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 409 {
+		t.Fatalf("status = %d, want 409", resp.StatusCode)
+	}
+	var body struct {
+		LinkToken string `json:"link_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := h.Tokens.ParsePurpose(models.PurposeIdentityLink, body.LinkToken)
+	if err != nil {
+		t.Fatalf("link_token: %v", err)
+	}
+	if claims["user_id"] != owner.ID.Hex() || claims["idp_iss"] != identity.Issuer || claims["idp_sub"] != identity.Subject {
+		t.Errorf("link_token claims = %v", claims)
+	}
+	if len(throttles.throttles) != 0 {
+		t.Errorf("valid directory credentials counted as a failed login: %v", throttles.throttles)
+	}
+	if ev := store.last(); ev == nil || ev.Details["reason"] != "link_required" || ev.ActorID == nil || *ev.ActorID != owner.ID {
+		t.Errorf("audit event = %+v", ev)
+	}
+}
+
+func TestLoginRegistrationClosed(t *testing.T) {
+	cfg := &config.Config{
+		LoginMaxAttempts:      3,
+		LoginMaxAttemptsPerIP: 100,
+		LoginLockoutBase:      time.Minute,
+		LoginLockoutMax:       time.Hour,
+		LoginFailureWindow:    time.Hour,
+	}
+	h, store := newTestAuthHandler(t, cfg)
+	throttles := &fakeThrottles{}
+	h.Lockout = lockout.NewGuard(throttles, cfg)
+	h.Authenticator = authenticatorFunc(func(context.Context, string, string) (*models.User, error) {
+		return nil, authn.ErrRegistrationClosed
+	})
+	app := fiber.New()
+	app.Post("/login", h.Login)
+
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"a@example.com","password":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 403 {
+		t.Fatalf("status = %d, want 403", resp.StatusCode)
+	}
+	if len(throttles.throttles) != 0 {
+		t.Errorf("valid directory credentials counted as a failed login: %v", throttles.throttles)
+	}
+	if ev := store.last(); ev == nil || ev.Details["reason"] != "registration_closed" {
+		t.Errorf("audit event = %+v", ev)
+	}
+}
+
+// newRegisterTest serves registration with the given users already signed up.
+func newRegisterTest(t *testing.T, cfg *config.Config, users ...*models.User) (*testAuth, *fiber.App) {
+	t.Helper()
+	cfg.AppBaseURL, cfg.EmailVerificationTTL = "https://notes.example.com", time.Hour
+	ta := newTestAuth(t, cfg)
+	ta.Passwords = newTestHasher(t)
+	for _, u := range users {
+		u.UsernameKey = models.FoldUsername(u.Username)
+	}
+	ta.users.users = users
+	app := fiber.New()
+	app.Post("/register", ta.Register)
+	return ta, app
+}
+
+func TestRegisterUsername(t *testing.T) {
+	tests := []struct {
+		name       string
+		username   string
+		stale      bool // the lookup misses a name taken concurrently
+		wantStatus int
+	}{
+		{"free", "Grace_H", false, 201},
+		{"none", "", false, 201},
+		{"taken", "Ada_L", false, 409},
+		{"taken in lower case", "ada_l", false, 409},
+		{"taken in upper case", "ADA_L", false, 409},
+		{"taken during the request", "ada_L", true, 409},
+		{"invalid", "a b", false, 400},
+		{"too short", "ab", false, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ada := &models.User{ID: primitive.NewObjectID(), Email: "ada@example.com", Username: "Ada_L"}
+			ta, app := newRegisterTest(t, &config.Config{RegistrationMode: config.RegistrationOpen}, ada)
+			if tt.stale {
+				ta.AuthHandler.users = staleUsers{ta.users}
+			}
+
+			status, body := sendJSON(t, app, "POST", "/register", nil, map[string]any{
+				"username": tt.username, "email": "grace@example.com", "password": "correct horse",
+			})
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+			u := ta.users.find(func(u *models.User) bool { return u.Email == "grace@example.com" })
+			if created := u != nil; created != (status == 201) {
+				t.Fatalf("account created = %v after status %d", created, status)
+			}
+			if u != nil && (u.Username != tt.username || u.UsernameKey != strings.ToLower(tt.username)) {
+				t.Errorf("username = %q (key %q), want %q as given", u.Username, u.UsernameKey, tt.username)
+			}
+			if got := ta.users.byID(ada.ID).Username; got != "Ada_L" {
+				t.Errorf("existing username = %q", got)
+			}
+		})
+	}
+}
+
+// fakeInvites is an in-memory inviteUses.
+type fakeInvites struct {
+	invites []*models.Invite
+}
+
+func (f *fakeInvites) Use(ctx context.Context, hash string) (*models.Invite, error) {
+	for _, inv := range f.invites {
+		if inv.CodeHash == hash && inv.RevokedAt == nil && time.Now().Before(inv.ExpiresAt) && inv.Uses < inv.MaxUses {
+			inv.Uses++
+			cp := *inv
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeInvites) Release(ctx context.Context, id primitive.ObjectID) error {
+	for _, inv := range f.invites {
+		if inv.ID == id && inv.Uses > 0 {
+			inv.Uses--
+		}
+	}
+	return nil
+}
+
+func TestRegisterInvite(t *testing.T) {
+	revoked := time.Now()
+	tests := []struct {
+		name       string
+		invite     models.Invite
+		body       map[string]any
+		stale      bool // the username lookup misses a taken name, so creating the account fails
+		wantStatus int
+		wantUses   int
+	}{
+		{"valid", models.Invite{MaxUses: 2}, map[string]any{"invite_code": "abc"}, false, 201, 1},
+		{"last use", models.Invite{MaxUses: 2, Uses: 1}, map[string]any{"invite_code": "abc"}, false, 201, 2},
+		{"no code", models.Invite{MaxUses: 2}, map[string]any{}, false, 403, 0},
+		{"unknown code", models.Invite{MaxUses: 2}, map[string]any{"invite_code": "xyz"}, false, 403, 0},
+		{"used up", models.Invite{MaxUses: 2, Uses: 2}, map[string]any{"invite_code": "abc"}, false, 403, 2},
+		{"expired", models.Invite{MaxUses: 2, ExpiresAt: time.Now().Add(-time.Minute)}, map[string]any{"invite_code": "abc"}, false, 403, 0},
+		{"revoked", models.Invite{MaxUses: 2, RevokedAt: &revoked}, map[string]any{"invite_code": "abc"}, false, 403, 0},
+		{"invalid email keeps the use", models.Invite{MaxUses: 2}, map[string]any{"invite_code": "abc", "email": "not an email"}, false, 400, 0},
+		{"registered email keeps the use", models.Invite{MaxUses: 2}, map[string]any{"invite_code": "abc", "email": "ada@example.com"}, false, 400, 0},
+		{"taken username keeps the use", models.Invite{MaxUses: 2}, map[string]any{"invite_code": "abc", "username": "ADA"}, false, 409, 0},
+		{"failed create releases the use", models.Invite{MaxUses: 2}, map[string]any{"invite_code": "abc", "username": "ADA"}, true, 409, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ada := &models.User{ID: primitive.NewObjectID(), Email: "ada@example.com", Username: "ada"}
+			ta, app := newRegisterTest(t, &config.Config{RegistrationMode: config.RegistrationInviteOnly}, ada)
+			inv := tt.invite
+			inv.ID, inv.CodeHash = primitive.NewObjectID(), tokens.HashToken("abc")
+			if inv.ExpiresAt.IsZero() {
+				inv.ExpiresAt = time.Now().Add(time.Hour)
+			}
+			ta.invites = &fakeInvites{invites: []*models.Invite{&inv}}
+			if tt.stale {
+				ta.AuthHandler.users = staleUsers{ta.users}
+			}
+
+			body := map[string]any{"email": "grace@example.com", "password": "correct horse"}
+			maps.Copy(body, tt.body)
+			status, resp := sendJSON(t, app, "POST", "/register", nil, body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, resp)
+			}
+			if inv.Uses != tt.wantUses {
+				t.Errorf("invite uses = %d, want %d", inv.Uses, tt.wantUses)
+			}
+			if created := len(ta.users.users) > 1; created != (status == 201) {
+				t.Errorf("account created = %v after status %d", created, status)
+			}
+		})
+	}
+}
+
+func TestRegisterMode(t *testing.T) {
+	tests := []struct {
+		mode       string
+		code       string
+		wantStatus int
+		wantUses   int
+	}{
+		{config.RegistrationOpen, "", 201, 0},
+		{config.RegistrationOpen, "abc", 201, 0}, // the code is not needed, so not used
+		{config.RegistrationInviteOnly, "", 403, 0},
+		{config.RegistrationInviteOnly, "abc", 201, 1},
+		{config.RegistrationClosed, "", 403, 0},
+		{config.RegistrationClosed, "abc", 403, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.code, func(t *testing.T) {
+			ta, app := newRegisterTest(t, &config.Config{RegistrationMode: tt.mode})
+			inv := &models.Invite{ID: primitive.NewObjectID(), CodeHash: tokens.HashToken("abc"), MaxUses: 1, ExpiresAt: time.Now().Add(time.Hour)}
+			ta.invites = &fakeInvites{invites: []*models.Invite{inv}}
+
+			status, resp := sendJSON(t, app, "POST", "/register", nil, map[string]any{
+				"email": "grace@example.com", "password": "correct horse", "invite_code": tt.code,
+			})
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, resp)
+			}
+			if inv.Uses != tt.wantUses {
+				t.Errorf("invite uses = %d, want %d", inv.Uses, tt.wantUses)
+			}
+			if created := len(ta.users.users) == 1; created != (status == 201) {
+				t.Errorf("account created = %v after status %d", created, status)
+			}
+		})
+	}
+}
+
+func TestRefreshReuseAudited(t *testing.T) {
+	ta := newTestAuth(t, &config.Config{})
+	user := &models.User{ID: primitive.NewObjectID(), Email: "ada@example.com"}
+	ta.users.users = []*models.User{user}
+	pair, err := ta.Tokens.Issue(t.Context(), user, tokens.Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ta.Tokens.Parse(t.Context(), pair.AccessToken, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Post("/refresh", ta.Refresh)
+
+	if status, body := sendJSON(t, app, "POST", "/refresh", nil, map[string]any{"refresh_token": pair.RefreshToken}); status != 200 {
+		t.Fatalf("first refresh status = %d: %v", status, body)
+	}
+	if ev := ta.audit.last(); ev != nil {
+		t.Fatalf("recorded %s for a normal refresh", ev.Action)
+	}
+	if status, body := sendJSON(t, app, "POST", "/refresh", nil, map[string]any{"refresh_token": pair.RefreshToken}); status != 401 {
+		t.Fatalf("replayed refresh status = %d, want 401: %v", status, body)
+	}
+	ev := ta.audit.last()
+	if ev == nil || ev.Action != models.AuditRevocation || ev.Details["reason"] != "reuse_detected" ||
+		ev.TargetType != "session" || ev.TargetID != claims.SessionID.Hex() || ev.Details["user_id"] != user.ID.Hex() {
+		t.Errorf("audit event = %+v, want reuse_detected revoking session %s of user %s", ev, claims.SessionID.Hex(), user.ID.Hex())
+	}
+	if n := ta.sessions.live(user.ID); n != 0 {
+		t.Errorf("%d live sessions after reuse, want 0", n)
+	}
+
+	if status, _ := sendJSON(t, app, "POST", "/refresh", nil, map[string]any{"refresh_token": "unknown"}); status != 401 {
+		t.Errorf("unknown token status = %d, want 401", status)
+	}
+	if n := len(ta.audit.events); n != 1 {
+		t.Errorf("%d audit events, want only the reuse", n)
+	}
+}
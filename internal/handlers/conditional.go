@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// noteETag is the entity tag of a note: its version, which every write bumps.
+func noteETag(n *models.Note) string {
+	return `"` + strconv.Itoa(n.Version) + `"`
+}
+
+// etagListed reports whether a comma separated If-Match or If-None-Match
+// header lists etag or is "*". If-Match compares strongly, so weak tags only
+// count when weak is set.
+func etagListed(header, etag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return true
+		}
+		if strings.HasPrefix(t, "W/") {
+			if !weak {
+				continue
+			}
+			t = t[2:]
+		}
+		if t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// precondition checks the request's If-Match header against n and returns the
+// version the write must still find, or repo.AnyVersion when the client did
+// not ask for a check. ok is false when an error response has been written;
+// return the accompanying error.
+func (h *NoteHandler) precondition(c *fiber.Ctx, n *models.Note) (version int, ok bool, err error) {
+	header := c.Get(fiber.HeaderIfMatch)
+	if header == "" {
+		if h.Config.RequireIfMatch {
+			return 0, false, c.Status(428).JSON(fiber.Map{"error": "If-Match header required", "current_version": n.Version})
+		}
+		return repo.AnyVersion, true, nil
+	}
+	if !etagListed(header, noteETag(n), false) {
+		return 0, false, preconditionFailed(c, n)
+	}
+	return n.Version, true, nil
+}
+
+// preconditionFailed tells the client which version the note is at now.
+func preconditionFailed(c *fiber.Ctx, n *models.Note) error {
+	c.Set(fiber.HeaderETag, noteETag(n))
+	return c.Status(412).JSON(fiber.Map{"error": "note has been modified", "current_version": n.Version})
+}
+
+// writeMissed answers a write that matched no note: either the note is gone,
+// or it changed after it was read. Edits always require the version they
+// read, so a write without If-Match can miss too; it gets a 409 since it
+// had no precondition to fail.
+func (h *NoteHandler) writeMissed(c *fiber.Ctx, ctx context.Context, id primitive.ObjectID, version int) error {
+	n, err := h.notes.GetById(ctx, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch note"})
+	}
+	if n == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if version == repo.AnyVersion {
+		c.Set(fiber.HeaderETag, noteETag(n))
+		return c.Status(409).JSON(fiber.Map{"error": "note was modified by another request, try again", "current_version": n.Version})
+	}
+	return preconditionFailed(c, n)
+}
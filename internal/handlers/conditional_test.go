@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeNotes is an in-memory noteStore.
+type fakeNotes struct {
+	notes map[primitive.ObjectID]*models.Note
+	err   error
+	// updateErr fails Update, after applying it when applied is set.
+	updateErr error
+	applied   bool
+	updates   int
+}
+
+func (f *fakeNotes) GetById(ctx context.Context, id primitive.ObjectID) (*models.Note, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if n, ok := f.notes[id]; ok {
+		cp := *n
+		return &cp, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeNotes) Update(ctx context.Context, id primitive.ObjectID, version int, update bson.M) (*models.Note, error) {
+	f.updates++
+	if f.updateErr != nil && !f.applied {
+		return nil, f.updateErr
+	}
+	n, ok := f.notes[id]
+	if !ok || (version != repo.AnyVersion && n.Version != version) {
+		return nil, nil
+	}
+	n = editedNote(n, update)
+	f.notes[id] = n
+	if f.updateErr != nil {
+		return nil, f.updateErr
+	}
+	cp := *n
+	return &cp, nil
+}
+
+func TestNoteETag(t *testing.T) {
+	for version, want := range map[int]string{0: `"0"`, 1: `"1"`, 42: `"42"`} {
+		if got := noteETag(&models.Note{Version: version}); got != want {
+			t.Errorf("noteETag(version %d) = %s, want %s", version, got, want)
+		}
+	}
+}
+
+func TestETagListed(t *testing.T) {
+	tests := []struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		{`"3"`, false, true},
+		{`"4"`, false, false},
+		{`"1", "3"`, false, true},
+		{`"1","2" , "3"`, false, true},
+		{`"1", "2"`, false, false},
+		{`*`, false, true},
+		{`"1", *`, false, true},
+		{`W/"3"`, false, false},
+		{`W/"3"`, true, true},
+		{`W/"4"`, true, false},
+		{`"1", W/"3"`, true, true},
+		{`3`, false, false},
+		{``, false, false},
+	}
+	for _, tt := range tests {
+		if got := etagListed(tt.header, `"3"`, tt.weak); got != tt.want {
+			t.Errorf("etagListed(%q, weak %v) = %v, want %v", tt.header, tt.weak, got, tt.want)
+		}
+	}
+}
+
+// conditionalResult is what the test routes answer with, or the error body.
+type conditionalResult struct {
+	status  int
+	etag    string
+	version int
+	current int
+}
+
+func doConditional(t *testing.T, app *fiber.App, ifMatch string) conditionalResult {
+	t.Helper()
+	req := httptest.NewRequest("PUT", "/", nil)
+	if ifMatch != "" {
+		req.Header.Set(fiber.HeaderIfMatch, ifMatch)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Version        int `json:"version"`
+		CurrentVersion int `json:"current_version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return conditionalResult{
+		status:  resp.StatusCode,
+		etag:    resp.Header.Get(fiber.HeaderETag),
+		version: body.Version,
+		current: body.CurrentVersion,
+	}
+}
+
+func TestPrecondition(t *testing.T) {
+	tests := []struct {
+		name         string
+		ifMatch      string
+		requireMatch bool
+		want         conditionalResult
+	}{
+		{"no header writes any version", "", false, conditionalResult{status: 200, version: repo.AnyVersion}},
+		{"no header when required", "", true, conditionalResult{status: 428, current: 3}},
+		{"matching tag", `"3"`, true, conditionalResult{status: 200, version: 3}},
+		{"tag in a list", `"2", "3"`, false, conditionalResult{status: 200, version: 3}},
+		{"star", `*`, true, conditionalResult{status: 200, version: 3}},
+		{"stale tag", `"2"`, false, conditionalResult{status: 412, etag: `"3"`, current: 3}},
+		{"weak tag never matches", `W/"3"`, false, conditionalResult{status: 412, etag: `"3"`, current: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &NoteHandler{Config: &config.Config{RequireIfMatch: tt.requireMatch}}
+			app := fiber.New()
+			app.Put("/", func(c *fiber.Ctx) error {
+				version, ok, err := h.precondition(c, &models.Note{Version: 3})
+				if !ok {
+					return err
+				}
+				return c.JSON(fiber.Map{"version": version})
+			})
+			if got := doConditional(t, app, tt.ifMatch); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMissed(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name    string
+		notes   *fakeNotes
+		version int
+		want    conditionalResult
+	}{
+		{
+			"write without If-Match lost a race",
+			&fakeNotes{notes: map[primitive.ObjectID]*models.Note{id: {ID: id, Version: 5}}},
+			repo.AnyVersion,
+			conditionalResult{status: 409, etag: `"5"`, current: 5},
+		},
+		{
+			"note changed after If-Match was checked",
+			&fakeNotes{notes: map[primitive.ObjectID]*models.Note{id: {ID: id, Version: 5}}},
+			4,
+			conditionalResult{status: 412, etag: `"5"`, current: 5},
+		},
+		{"note is gone", &fakeNotes{}, 4, conditionalResult{status: 404}},
+		{"note is gone, no If-Match", &fakeNotes{}, repo.AnyVersion, conditionalResult{status: 404}},
+		{"lookup fails", &fakeNotes{err: errors.New("db down")}, 4, conditionalResult{status: 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &NoteHandler{Config: &config.Config{}, notes: tt.notes}
+			app := fiber.New()
+			app.Put("/", func(c *fiber.Ctx) error {
+				return h.writeMissed(c, context.Background(), id, tt.version)
+			})
+			if got := doConditional(t, app, ""); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
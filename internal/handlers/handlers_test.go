@@ -0,0 +1,484 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/audit"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/mailer"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeAuditStore keeps recorded audit events in memory.
+type fakeAuditStore struct {
+	mu     sync.Mutex
+	events []models.AuditEvent
+}
+
+func (s *fakeAuditStore) Insert(ctx context.Context, ev *models.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, *ev)
+	return nil
+}
+
+func (s *fakeAuditStore) last() *models.AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.events) == 0 {
+		return nil
+	}
+	return &s.events[len(s.events)-1]
+}
+
+// newTestAuthHandler returns an AuthHandler that can sign and parse purpose
+// tokens and record audit events. Its users and the token service's sessions
+// and refresh tokens are kept in memory.
+func newTestAuthHandler(t *testing.T, cfg *config.Config) (*AuthHandler, *fakeAuditStore) {
+	t.Helper()
+	ta := newTestAuth(t, cfg)
+	return ta.AuthHandler, ta.audit
+}
+
+// testAuth is an AuthHandler with its in-memory stores at hand.
+type testAuth struct {
+	*AuthHandler
+	audit    *fakeAuditStore
+	users    *fakeUsers
+	sessions *fakeSessions
+	refresh  *fakeRefreshTokens
+}
+
+func newTestAuth(t *testing.T, cfg *config.Config) *testAuth {
+	t.Helper()
+	if cfg.JWTSecret == "" {
+		cfg.JWTSecret = "test-secret"
+	}
+	if cfg.AccessTokenTTL == 0 {
+		cfg.AccessTokenTTL = time.Minute
+	}
+	if cfg.RefreshTokenTTL == 0 {
+		cfg.RefreshTokenTTL = time.Hour
+	}
+	keys, err := tokens.NewKeyRing(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := &testAuth{
+		audit:    &fakeAuditStore{},
+		users:    &fakeUsers{},
+		sessions: &fakeSessions{sessions: map[primitive.ObjectID]*models.Session{}},
+		refresh:  &fakeRefreshTokens{},
+	}
+	svc := tokens.NewService(keys, cfg, ta.users, ta.refresh, nil, ta.sessions, tokens.NewMemoryRevocationStore())
+	ta.AuthHandler = NewAuthHandler(nil, nil, nil, svc, mailer.NewMemoryMailer(), nil, nil, nil, audit.NewRecorder(ta.audit), cfg)
+	ta.AuthHandler.users = ta.users
+	return ta
+}
+
+// fakeSessions is an in-memory tokens.SessionStore and sessionLister.
+type fakeSessions struct {
+	mu       sync.Mutex
+	sessions map[primitive.ObjectID]*models.Session
+}
+
+func (f *fakeSessions) Create(ctx context.Context, s *models.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now().UTC()
+	s.ID = primitive.NewObjectID()
+	s.CreatedAt, s.LastSeenAt = now, now
+	cp := *s
+	f.sessions[s.ID] = &cp
+	return nil
+}
+
+func (f *fakeSessions) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	cp := *s
+	return &cp, nil
+}
+
+func (f *fakeSessions) Touch(ctx context.Context, id primitive.ObjectID, ip string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.sessions[id]; ok {
+		s.LastSeenAt, s.IP = time.Now().UTC(), ip
+	}
+	return nil
+}
+
+func (f *fakeSessions) Extend(ctx context.Context, id primitive.ObjectID, expiresAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.sessions[id]; ok && s.RevokedAt == nil {
+		s.ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+func (f *fakeSessions) Revoke(ctx context.Context, id, userID primitive.ObjectID) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.sessions[id]
+	if !ok || s.UserID != userID || s.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now().UTC()
+	s.RevokedAt = &now
+	return true, nil
+}
+
+func (f *fakeSessions) RevokeUser(ctx context.Context, userID primitive.ObjectID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now().UTC()
+	for _, s := range f.sessions {
+		if s.UserID == userID && s.RevokedAt == nil {
+			s.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (f *fakeSessions) ListActive(ctx context.Context, userID primitive.ObjectID) ([]models.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := []models.Session{}
+	for _, s := range f.sessions {
+		if s.UserID == userID && s.RevokedAt == nil && time.Now().Before(s.ExpiresAt) {
+			out = append(out, *s)
+		}
+	}
+	slices.SortFunc(out, func(a, b models.Session) int { return b.LastSeenAt.Compare(a.LastSeenAt) })
+	return out, nil
+}
+
+// live counts the user's sessions that are still signed in.
+func (f *fakeSessions) live(userID primitive.ObjectID) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, s := range f.sessions {
+		if s.UserID == userID && s.RevokedAt == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// fakeRefreshTokens is an in-memory tokens.RefreshTokenStore.
+type fakeRefreshTokens struct {
+	mu     sync.Mutex
+	tokens []*models.RefreshToken
+}
+
+func (f *fakeRefreshTokens) Create(ctx context.Context, t *models.RefreshToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t.ID = primitive.NewObjectID()
+	t.CreatedAt = time.Now().UTC()
+	cp := *t
+	f.tokens = append(f.tokens, &cp)
+	return nil
+}
+
+func (f *fakeRefreshTokens) FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.tokens {
+		if t.TokenHash == hash {
+			cp := *t
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeRefreshTokens) MarkRotated(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.tokens {
+		if t.ID == id && t.RotatedAt == nil && t.RevokedAt == nil {
+			now := time.Now().UTC()
+			t.RotatedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeRefreshTokens) RevokeFamily(ctx context.Context, familyID primitive.ObjectID) error {
+	return f.revoke(func(t *models.RefreshToken) bool { return t.FamilyID == familyID })
+}
+
+func (f *fakeRefreshTokens) RevokeUser(ctx context.Context, userID primitive.ObjectID) error {
+	return f.revoke(func(t *models.RefreshToken) bool { return t.UserID == userID })
+}
+
+func (f *fakeRefreshTokens) revoke(match func(*models.RefreshToken) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now().UTC()
+	for _, t := range f.tokens {
+		if match(t) && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// fakeUsers is an in-memory authUsers, oidcUsers and passkeyUsers. Like the
+// unique indexes, it refuses a second account with the same email or folded
+// username.
+type fakeUsers struct {
+	users []*models.User
+}
+
+// find returns the stored user, which the lookups only hand out copies of.
+func (f *fakeUsers) find(match func(u *models.User) bool) *models.User {
+	for _, u := range f.users {
+		if match(u) {
+			return u
+		}
+	}
+	return nil
+}
+
+func userCopy(u *models.User) (*models.User, error) {
+	if u == nil {
+		return nil, nil
+	}
+	cp := *u
+	return &cp, nil
+}
+
+func (f *fakeUsers) byID(id primitive.ObjectID) *models.User {
+	return f.find(func(u *models.User) bool { return u.ID == id })
+}
+
+func (f *fakeUsers) taken(except primitive.ObjectID, email, username string) bool {
+	return f.find(func(u *models.User) bool {
+		return u.ID != except && ((email != "" && u.Email == email) || (username != "" && u.UsernameKey == models.FoldUsername(username)))
+	}) != nil
+}
+
+func (f *fakeUsers) Create(ctx context.Context, user *models.User) error {
+	if f.taken(primitive.NilObjectID, user.Email, user.Username) {
+		return repo.ErrDuplicate
+	}
+	user.ID = primitive.NewObjectID()
+	user.UsernameKey = models.FoldUsername(user.Username)
+	f.users = append(f.users, user)
+	return nil
+}
+
+func (f *fakeUsers) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	return userCopy(f.byID(id))
+}
+
+func (f *fakeUsers) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return userCopy(f.find(func(u *models.User) bool { return u.Email == email }))
+}
+
+func (f *fakeUsers) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return userCopy(f.find(func(u *models.User) bool { return u.UsernameKey == models.FoldUsername(username) }))
+}
+
+func (f *fakeUsers) FindByIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	return userCopy(f.find(func(u *models.User) bool {
+		return slices.Contains(u.Identities, models.Identity{Issuer: issuer, Subject: subject})
+	}))
+}
+
+func (f *fakeUsers) Update(ctx context.Context, id primitive.ObjectID, set bson.M) (*models.User, error) {
+	u := f.byID(id)
+	if u == nil {
+		return nil, nil
+	}
+	if username, ok := set["username"].(string); ok {
+		if f.taken(id, "", username) {
+			return nil, repo.ErrDuplicate
+		}
+		u.Username = username
+		u.UsernameKey = models.FoldUsername(username)
+	}
+	for k, dst := range map[string]*string{"display_name": &u.DisplayName, "bio": &u.Bio, "avatar_url": &u.AvatarURL} {
+		if v, ok := set[k].(string); ok {
+			*dst = v
+		}
+	}
+	return userCopy(u)
+}
+
+func (f *fakeUsers) UpdatePassword(ctx context.Context, id primitive.ObjectID, hash string) error {
+	if u := f.byID(id); u != nil {
+		u.Password = hash
+	}
+	return nil
+}
+
+func (f *fakeUsers) ChangeEmail(ctx context.Context, id primitive.ObjectID, oldEmail, newEmail string) (bool, error) {
+	u := f.byID(id)
+	if u == nil || u.Email != oldEmail {
+		return false, nil
+	}
+	if f.taken(id, newEmail, "") {
+		return false, repo.ErrDuplicate
+	}
+	u.Email = newEmail
+	u.EmailVerified = true
+	return true, nil
+}
+
+func (f *fakeUsers) MarkEmailVerified(ctx context.Context, id primitive.ObjectID, email string) (bool, error) {
+	u := f.byID(id)
+	if u == nil || u.Email != email {
+		return false, nil
+	}
+	u.EmailVerified = true
+	return true, nil
+}
+
+func (f *fakeUsers) SetRole(ctx context.Context, id primitive.ObjectID, role models.Role) (bool, error) {
+	u := f.byID(id)
+	if u == nil {
+		return false, nil
+	}
+	u.Role = role
+	return true, nil
+}
+
+func (f *fakeUsers) LinkIdentity(ctx context.Context, id primitive.ObjectID, identity models.Identity) error {
+	if u := f.byID(id); u != nil {
+		u.Identities = append(u.Identities, identity)
+		u.EmailVerified = true
+	}
+	return nil
+}
+
+func (f *fakeUsers) SetPendingTOTP(ctx context.Context, id primitive.ObjectID, secret string) (bool, error) {
+	u := f.byID(id)
+	if u == nil || u.TOTPEnabled {
+		return false, nil
+	}
+	u.TOTPSecret = secret
+	return true, nil
+}
+
+func (f *fakeUsers) EnableTOTP(ctx context.Context, id primitive.ObjectID, step int64, recoveryCodes []string) error {
+	if u := f.byID(id); u != nil {
+		u.TOTPEnabled = true
+		u.TOTPLastStep = step
+		u.RecoveryCodes = recoveryCodes
+	}
+	return nil
+}
+
+func (f *fakeUsers) DisableTOTP(ctx context.Context, id primitive.ObjectID) error {
+	if u := f.byID(id); u != nil {
+		u.TOTPEnabled = false
+		u.TOTPSecret = ""
+		u.TOTPLastStep = 0
+		u.RecoveryCodes = nil
+	}
+	return nil
+}
+
+func (f *fakeUsers) AdvanceTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	u := f.byID(id)
+	if u == nil || u.TOTPLastStep >= step {
+		return false, nil
+	}
+	u.TOTPLastStep = step
+	return true, nil
+}
+
+func (f *fakeUsers) ConsumeRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) (bool, error) {
+	u := f.byID(id)
+	if u == nil || !slices.Contains(u.RecoveryCodes, hash) {
+		return false, nil
+	}
+	u.RecoveryCodes = slices.DeleteFunc(u.RecoveryCodes, func(h string) bool { return h == hash })
+	return true, nil
+}
+
+func (f *fakeUsers) ScheduleDeletion(ctx context.Context, id primitive.ObjectID, when time.Time) error {
+	if u := f.byID(id); u != nil {
+		u.DeletionScheduledFor = &when
+	}
+	return nil
+}
+
+func (f *fakeUsers) CancelDeletion(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	u := f.byID(id)
+	if u == nil || u.DeletionScheduledFor == nil {
+		return false, nil
+	}
+	u.DeletionScheduledFor = nil
+	return true, nil
+}
+
+// sendJSON makes a request with a JSON body, as the user whose id is given in
+// the X-User header when user is set, and decodes the JSON response.
+func sendJSON(t *testing.T, app *fiber.App, method, path string, user *models.User, body any) (int, map[string]any) {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, r)
+	req.Header.Set("Content-Type", "application/json")
+	if user != nil {
+		req.Header.Set("X-User", user.ID.Hex())
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]any
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+// signedIn authenticates test requests as the user in the X-User header.
+func signedIn(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Get("X-User"))
+	if err != nil {
+		return c.SendStatus(401)
+	}
+	c.Locals("user_id", id)
+	return c.Next()
+}
+
+// staleUsers finds no account by username, as when another request takes the
+// name between the handler's lookup and its write.
+type staleUsers struct {
+	*fakeUsers
+}
+
+func (staleUsers) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return nil, nil
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/authz"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/middleware"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// userInviteMaxUses caps how many people one invite from a regular user can
+// bring in; admins are not limited.
+const userInviteMaxUses = 5
+
+type InviteHandler struct {
+	InviteRepo *repo.InviteRepo
+	Policy     *authz.Policy
+	Config     *config.Config
+}
+
+func NewInviteHandler(inviteRepo *repo.InviteRepo, policy *authz.Policy, cfg *config.Config) *InviteHandler {
+	return &InviteHandler{
+		InviteRepo: inviteRepo,
+		Policy:     policy,
+		Config:     cfg,
+	}
+}
+
+// CreateInvite issues an invite code. The code is returned only in this response.
+func (h *InviteHandler) CreateInvite(c *fiber.Ctx) error {
+	if h.Config.RegistrationMode == config.RegistrationClosed {
+		return c.Status(403).JSON(fiber.Map{"error": "registration is closed"})
+	}
+	var req struct {
+		MaxUses   int        `json:"max_uses"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	// the body is optional
+	_ = c.BodyParser(&req)
+
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.MaxUses < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "max_uses must be positive"})
+	}
+	sub := middleware.Subject(c)
+	if req.MaxUses > userInviteMaxUses && !h.Policy.Can(sub, authz.InviteManage, nil) {
+		return c.Status(403).JSON(fiber.Map{"error": "max_uses above the limit for your role", "limit": userInviteMaxUses})
+	}
+	expiresAt := time.Now().UTC().Add(h.Config.InviteTTL)
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			return c.Status(400).JSON(fiber.Map{"error": "expires_at must be in the future"})
+		}
+		expiresAt = req.ExpiresAt.UTC()
+	}
+
+	raw, hash, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to create invite"})
+	}
+	inv := &models.Invite{
+		CreatedBy: sub.UserID,
+		CodeHash:  hash,
+		Prefix:    raw[:6],
+		MaxUses:   req.MaxUses,
+		ExpiresAt: expiresAt,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.InviteRepo.Create(ctx, inv); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to create invite"})
+	}
+	return c.Status(201).JSON(fiber.Map{"code": raw, "invite": inv})
+}
+
+// ListInvites returns the caller's invites; admins may pass all=true to see
+// everyone's.
+func (h *InviteHandler) ListInvites(c *fiber.Ctx) error {
+	sub := middleware.Subject(c)
+	createdBy := &sub.UserID
+	if c.QueryBool("all") {
+		if !h.Policy.Can(sub, authz.InviteManage, nil) {
+			return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+		}
+		createdBy = nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	items, err := h.InviteRepo.List(ctx, createdBy)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch invites"})
+	}
+	return c.JSON(fiber.Map{"invites": items})
+}
+
+func (h *InviteHandler) RevokeInvite(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	inv, err := h.InviteRepo.FindByID(ctx, oid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to revoke invite"})
+	}
+	if inv == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if !h.Policy.Can(middleware.Subject(c), authz.InviteManage, inv) {
+		return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+	}
+	if err := h.InviteRepo.Revoke(ctx, oid); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to revoke invite"})
+	}
+	return c.JSON(fiber.Map{"message": "invite revoked"})
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/mailer"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RequestMagicLink emails a one-time login link. Like ForgotPassword it
+// answers the same way whether or not the email is registered, and sends
+// nothing for accounts an LDAP directory manages.
+func (h *AuthHandler) RequestMagicLink(c *fiber.Ctx) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	req.Email = models.NormalizeEmail(req.Email)
+	if req.Email == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "email required"})
+	}
+
+	accepted := fiber.Map{"message": "if the email is registered, a login link has been sent"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.users.FindByEmail(ctx, req.Email)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to process request"})
+	}
+	if user == nil || user.DirectoryManaged {
+		return c.Status(202).JSON(accepted)
+	}
+
+	// the link is a signed token whose jti is also stored as a one-time
+	// token, so it is tamper-proof, short-lived and can be used only once
+	raw, hash, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to process request"})
+	}
+	t := &models.OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   models.PurposeMagicLink,
+		TokenHash: hash,
+		ExpiresAt: time.Now().UTC().Add(h.Config.MagicLinkTTL),
+	}
+	if err := h.OneTimeTokens.Create(ctx, t); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to process request"})
+	}
+	signed, err := h.Tokens.SignPurpose(models.PurposeMagicLink, jwt.MapClaims{
+		"jti":     raw,
+		"user_id": user.ID.Hex(),
+	}, h.Config.MagicLinkTTL)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to process request"})
+	}
+
+	link := h.Config.AppBaseURL + "/magic-login?token=" + url.QueryEscape(signed)
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Open this link within %s to log in:\n%s\n\n"+
+			"The link works once. If you did not ask for it, you can ignore this email.", h.Config.MagicLinkTTL, link),
+	}
+	go h.sendMail(msg)
+
+	return c.Status(202).JSON(accepted)
+}
+
+// VerifyMagicLink exchanges a login link token for the tokens Login returns.
+// It is a POST so that mail scanners following the link cannot burn it.
+// Opening the link proves control of the inbox, so the email counts as verified.
+func (h *AuthHandler) VerifyMagicLink(c *fiber.Ctx) error {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	invalid := fiber.Map{"error": "invalid or expired link"}
+
+	claims, err := h.Tokens.ParsePurpose(models.PurposeMagicLink, req.Token)
+	if err != nil {
+		return c.Status(400).JSON(invalid)
+	}
+	jti, _ := claims["jti"].(string)
+	uidStr, _ := claims["user_id"].(string)
+	uid, err := primitive.ObjectIDFromHex(uidStr)
+	if jti == "" || err != nil {
+		return c.Status(400).JSON(invalid)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t, err := h.OneTimeTokens.Consume(ctx, models.PurposeMagicLink, tokens.HashToken(jti))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+	}
+	if t == nil || t.UserID != uid {
+		return c.Status(400).JSON(invalid)
+	}
+	user, err := h.users.FindByID(ctx, uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+	}
+	if user == nil || user.DirectoryManaged {
+		return c.Status(400).JSON(invalid)
+	}
+	if err := h.OneTimeTokens.InvalidateUser(ctx, uid, models.PurposeMagicLink); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+	}
+	if !user.EmailVerified {
+		if _, err := h.users.MarkEmailVerified(ctx, uid, user.Email); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+		}
+		user.EmailVerified = true
+	}
+	return h.finishLogin(c, ctx, user, "magic_link")
+}
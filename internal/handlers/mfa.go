@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/totp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// EnrollTOTP creates a pending TOTP secret. It has no effect on login until
+// confirmed with ConfirmTOTP.
+func (h *AuthHandler) EnrollTOTP(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.users.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to enroll"})
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to enroll"})
+	}
+	ok, err := h.users.SetPendingTOTP(ctx, userID, secret)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to enroll"})
+	}
+	if !ok {
+		return c.Status(409).JSON(fiber.Map{"error": "two-factor authentication already enabled"})
+	}
+	return c.JSON(fiber.Map{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(secret, h.Config.TOTPIssuer, user.Email),
+	})
+}
+
+// ConfirmTOTP enables TOTP once the user proves their app produces valid codes,
+// and returns single-use recovery codes. They are shown only this once.
+func (h *AuthHandler) ConfirmTOTP(c *fiber.Ctx) error {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.users.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to confirm"})
+	}
+	if user.TOTPEnabled {
+		return c.Status(409).JSON(fiber.Map{"error": "two-factor authentication already enabled"})
+	}
+	if user.TOTPSecret == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "no pending enrollment"})
+	}
+	step, ok := totp.Validate(user.TOTPSecret, req.Code, time.Now(), 0)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid code"})
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to confirm"})
+	}
+	if err := h.users.EnableTOTP(ctx, userID, step, hashes); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to confirm"})
+	}
+	return c.JSON(fiber.Map{"message": "two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// DisableTOTP turns TOTP off. It asks for the password and a current code so
+// a stolen access token alone cannot strip the second factor; accounts
+// without a password, signed in through a provider or the directory, only
+// give the code.
+func (h *AuthHandler) DisableTOTP(c *fiber.Ctx) error {
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.users.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to disable"})
+	}
+	if !user.TOTPEnabled {
+		return c.Status(400).JSON(fiber.Map{"error": "two-factor authentication not enabled"})
+	}
+	if user.Password != "" {
+		if ok, _ := h.Passwords.Verify(req.Password, user.Password); !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "invalid credentials"})
+		}
+	}
+	ok, err := h.checkSecondFactor(ctx, user, req.Code, "")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to disable"})
+	}
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid code"})
+	}
+	if err := h.users.DisableTOTP(ctx, userID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to disable"})
+	}
+	return c.JSON(fiber.Map{"message": "two-factor authentication disabled"})
+}
+
+// LoginMFA completes a login started by Login for an account with TOTP, taking
+// the challenge token plus either a TOTP code or a recovery code.
+func (h *AuthHandler) LoginMFA(c *fiber.Ctx) error {
+	var req struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+
+	invalid := fiber.Map{"error": "invalid or expired mfa token"}
+	claims, err := h.Tokens.ParsePurpose(models.PurposeMFAChallenge, req.MFAToken)
+	if err != nil {
+		return c.Status(401).JSON(invalid)
+	}
+	jti, _ := claims["jti"].(string)
+	uidStr, _ := claims["user_id"].(string)
+	uid, err := primitive.ObjectIDFromHex(uidStr)
+	if jti == "" || err != nil {
+		return c.Status(401).JSON(invalid)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.users.FindByID(ctx, uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+	}
+	if user == nil || !user.TOTPEnabled {
+		return c.Status(401).JSON(invalid)
+	}
+
+	method := "totp"
+	if req.Code == "" {
+		method = "recovery_code"
+	}
+
+	// codes are guessable too, so they share the password lockout
+	ip := c.IP()
+	wait, err := h.Lockout.Check(ctx, user.Email, ip)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+	}
+	if wait > 0 {
+		ev := loginEvent(c, models.AuditFailure, method, user)
+		ev.Details["reason"] = "locked_out"
+		h.Audit.Record(ctx, ev)
+		return tooManyAttempts(c, wait)
+	}
+	ok, err := h.checkSecondFactor(ctx, user, req.Code, req.RecoveryCode)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+	}
+	if !ok {
+		h.Audit.Record(ctx, loginEvent(c, models.AuditFailure, method, user))
+		if err := h.Lockout.Fail(ctx, user.Email, ip); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+		}
+		return c.Status(401).JSON(fiber.Map{"error": "invalid code"})
+	}
+	// a challenge yields one login; it is consumed only now so that a
+	// mistyped code does not send the user back to the password prompt
+	t, err := h.OneTimeTokens.Consume(ctx, models.PurposeMFAChallenge, tokens.HashToken(jti))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+	}
+	if t == nil || t.UserID != uid {
+		ev := loginEvent(c, models.AuditFailure, method, user)
+		ev.Details["reason"] = "challenge_reused"
+		h.Audit.Record(ctx, ev)
+		return c.Status(401).JSON(invalid)
+	}
+	return h.loggedIn(c, ctx, user, method)
+}
+
+// finishLogin runs after the primary factor, named by method, succeeded:
+// accounts with TOTP get an MFA challenge, everyone else gets tokens.
+func (h *AuthHandler) finishLogin(c *fiber.Ctx, ctx context.Context, user *models.User, method string) error {
+	if !user.TOTPEnabled {
+		return h.loggedIn(c, ctx, user, method)
+	}
+	// like a magic link, the challenge's jti is stored as a one-time token
+	raw, hash, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to issue token"})
+	}
+	t := &models.OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   models.PurposeMFAChallenge,
+		TokenHash: hash,
+		ExpiresAt: time.Now().UTC().Add(mfaChallengeTTL),
+	}
+	if err := h.OneTimeTokens.Create(ctx, t); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to issue token"})
+	}
+	challenge, err := h.Tokens.SignPurpose(models.PurposeMFAChallenge, jwt.MapClaims{
+		"jti":     raw,
+		"user_id": user.ID,
+	}, mfaChallengeTTL)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to issue token"})
+	}
+	return c.JSON(fiber.Map{"mfa_required": true, "mfa_token": challenge})
+}
+
+// loggedIn completes a login once every factor has passed. Only then are the
+// account's failed attempts forgiven, so a known password does not reset the
+// lockout that protects the second factor.
+func (h *AuthHandler) loggedIn(c *fiber.Ctx, ctx context.Context, user *models.User, method string) error {
+	if err := h.Lockout.Succeed(ctx, user.Email); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+	}
+	h.Audit.Record(ctx, loginEvent(c, models.AuditSuccess, method, user))
+	return h.issueTokens(c, ctx, user)
+}
+
+// loginEvent starts the audit event for a login attempt. user is nil when the
+// account is not known.
+func loginEvent(c *fiber.Ctx, outcome, method string, user *models.User) *models.AuditEvent {
+	ev := auditEvent(c, models.AuditLogin, outcome)
+	ev.Details = map[string]string{"method": method}
+	if user != nil {
+		ev.ActorID = &user.ID
+		ev.Email = user.Email
+	}
+	return ev
+}
+
+func (h *AuthHandler) issueTokens(c *fiber.Ctx, ctx context.Context, user *models.User) error {
+	pair, err := h.Tokens.Issue(ctx, user, tokens.Client{UserAgent: c.Get(fiber.HeaderUserAgent), IP: c.IP()})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to issue token"})
+	}
+	return c.JSON(pair)
+}
+
+// checkSecondFactor accepts a TOTP code that was not used before, or else an
+// unused recovery code, burning whichever one matched.
+func (h *AuthHandler) checkSecondFactor(ctx context.Context, user *models.User, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+		if !ok {
+			return false, nil
+		}
+		// the step is advanced atomically, so of two concurrent logins with
+		// the same code only one gets through
+		return h.users.AdvanceTOTPStep(ctx, user.ID, step)
+	}
+	if recoveryCode != "" {
+		return h.users.ConsumeRecoveryCode(ctx, user.ID, hashRecoveryCode(recoveryCode))
+	}
+	return false, nil
+}
+
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(enc.EncodeToString(b))
+		code := s[:4] + "-" + s[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return tokens.HashToken(code)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/password"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/totp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// totpCode is the RFC 6238 code an authenticator app shows for secret now.
+func totpCode(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[off:off+4])&0x7fffffff)%1000000)
+}
+
+func newTestHasher(t *testing.T) *password.Hasher {
+	t.Helper()
+	h, err := password.NewHasher(&config.Config{Argon2Memory: 1024, Argon2Iterations: 1, Argon2Parallelism: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestDisableTOTP(t *testing.T) {
+	hasher := newTestHasher(t)
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		password   string // the account's hash, if any
+		body       func(code string) string
+		wantStatus int
+	}{
+		{"password and code", hash, func(code string) string { return `{"password":"correct horse","code":"` + code + `"}` }, 200},
+		{"wrong password", hash, func(code string) string { return `{"password":"wrong","code":"` + code + `"}` }, 401},
+		{"code without the password", hash, func(code string) string { return `{"code":"` + code + `"}` }, 401},
+		{"wrong code", hash, func(string) string { return `{"password":"correct horse","code":"000000"}` }, 401},
+		{"passwordless account, code alone", "", func(code string) string { return `{"code":"` + code + `"}` }, 200},
+		{"passwordless account, wrong code", "", func(string) string { return `{"code":"000000"}` }, 401},
+		{"passwordless account, no code", "", func(string) string { return `{}` }, 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{ID: primitive.NewObjectID(), Email: "a@example.com", Password: tt.password, TOTPEnabled: true, TOTPSecret: secret}
+			users := &fakeUsers{users: []*models.User{user}}
+			h, _ := newTestAuthHandler(t, &config.Config{})
+			h.users = users
+			h.Passwords = hasher
+			app := fiber.New()
+			app.Post("/mfa/totp/disable", func(c *fiber.Ctx) error {
+				c.Locals("user_id", user.ID)
+				return c.Next()
+			}, h.DisableTOTP)
+
+			code := totpCode(t, secret)
+			if code == "000000" {
+				t.Skip("the current code is the one used as a wrong code")
+			}
+			req := httptest.NewRequest("POST", "/mfa/totp/disable", strings.NewReader(tt.body(code)))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if disabled := !users.users[0].TOTPEnabled; disabled != (tt.wantStatus == 200) {
+				t.Errorf("TOTP disabled = %v after status %d", disabled, resp.StatusCode)
+			}
+		})
+	}
+}
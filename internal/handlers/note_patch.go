@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/authz"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/middleware"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/patch"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// PatchNote applies a JSON Merge Patch or a JSON Patch, chosen by the
+// Content-Type, to the editable fields of a note: title, content, is_public
+// and tags. The patched note is validated as a whole and every invalid field
+// is reported. It honors If-Match like PUT.
+func (h *NoteHandler) PatchNote(c *fiber.Ctx) error {
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	apply := map[string]func(any, []byte) (any, error){
+		patch.MergePatchType: patch.Merge,
+		patch.JSONPatchType:  patch.Apply,
+	}[mediaType]
+	if apply == nil {
+		c.Set(fiber.HeaderAcceptPatch, patch.MergePatchType+", "+patch.JSONPatchType)
+		return c.Status(415).JSON(fiber.Map{"error": "content type must be " + patch.MergePatchType + " or " + patch.JSONPatchType})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := h.authorizedNote(c, ctx, authz.NoteUpdate)
+	if n == nil {
+		return err
+	}
+	version, ok, err := h.precondition(c, n)
+	if !ok {
+		return err
+	}
+
+	patched, err := apply(noteDocument(n), c.Body())
+	switch {
+	case errors.Is(err, patch.ErrMalformed):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, patch.ErrTestFailed):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(422).JSON(fiber.Map{"error": err.Error()})
+	}
+	fields, errs := validateNote(patched)
+	if len(errs) > 0 {
+		return c.Status(422).JSON(fiber.Map{"error": "invalid note", "fields": errs})
+	}
+
+	update := bson.M{}
+	if fields.Title != n.Title {
+		update["title"] = fields.Title
+	}
+	if fields.Content != n.Content {
+		update["content"] = fields.Content
+	}
+	if fields.IsPublic != n.IsPublic {
+		update["is_public"] = fields.IsPublic
+	}
+	if !slices.Equal(fields.Tags, n.Tags) {
+		update["tags"] = fields.Tags
+	}
+	if len(update) == 0 {
+		c.Set(fiber.HeaderETag, noteETag(n))
+		return c.JSON(n)
+	}
+	if fields.IsPublic && !n.IsPublic {
+		ok, err := h.canPublish(ctx, n.UserID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to update note"})
+		}
+		if !ok {
+			return c.Status(403).JSON(fiber.Map{"error": "verify your email to publish notes"})
+		}
+	}
+	changed := make([]string, 0, len(update))
+	for k := range update {
+		changed = append(changed, k)
+	}
+	sort.Strings(changed)
+
+	updated, err := h.saveEdit(ctx, n, update, middleware.Subject(c).UserID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to update note"})
+	}
+	if updated == nil {
+		return h.writeMissed(c, ctx, n.ID, version)
+	}
+	h.auditUpdate(c, ctx, n, updated, map[string]string{"fields": strings.Join(changed, ",")})
+	c.Set(fiber.HeaderETag, noteETag(updated))
+	return c.JSON(updated)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/authz"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/diff"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/middleware"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const diffContextLines = 3
+
+// ListRevisions lists a note's versions newest first, without their content.
+func (h *NoteHandler) ListRevisions(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := h.authorizedNote(c, ctx, authz.NoteHistory)
+	if n == nil {
+		return err
+	}
+	items, err := h.Revisions.List(ctx, n.ID, page, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch revisions"})
+	}
+	return c.JSON(fiber.Map{"revisions": items, "current_version": n.Version})
+}
+
+// GetRevision returns a note as it was at one version.
+func (h *NoteHandler) GetRevision(c *fiber.Ctx) error {
+	version, err := strconv.Atoi(c.Params("version"))
+	if err != nil || version < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid version"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := h.authorizedNote(c, ctx, authz.NoteHistory)
+	if n == nil {
+		return err
+	}
+	rev, err := h.Revisions.Get(ctx, n.ID, version)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch revision"})
+	}
+	if rev == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(rev)
+}
+
+// DiffRevisions compares two versions of a note: ?from= defaults to the one
+// before ?to=, which defaults to the current version. The content is compared
+// line by line and also rendered as a unified diff.
+func (h *NoteHandler) DiffRevisions(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := h.authorizedNote(c, ctx, authz.NoteHistory)
+	if n == nil {
+		return err
+	}
+	to, err := strconv.Atoi(c.Query("to", strconv.Itoa(n.Version)))
+	if err != nil || to < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid to version"})
+	}
+	from, err := strconv.Atoi(c.Query("from", strconv.Itoa(to-1)))
+	if err != nil || (from < 0 && c.Query("from") != "") {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid from version"})
+	}
+
+	b, err := h.revisionAt(ctx, n, to)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch revision"})
+	}
+	if b == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	var a *models.NoteRevision
+	if from >= 0 {
+		a, err = h.revisionAt(ctx, n, from)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to fetch revision"})
+		}
+	}
+	var fromVersion any = from
+	fromLabel := "v" + strconv.Itoa(from)
+	if a == nil && c.Query("from") == "" && to <= 1 {
+		// the first version has nothing before it, so it is all added
+		a, fromVersion, fromLabel = &models.NoteRevision{}, nil, "empty"
+	}
+	if a == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+
+	lines := diff.Lines(a.Content, b.Content)
+	out := fiber.Map{
+		"from":    fromVersion,
+		"to":      to,
+		"content": lines,
+		"unified": diff.Unified(lines, fromLabel, "v"+strconv.Itoa(to), diffContextLines),
+	}
+	if a.Title != b.Title {
+		out["title"] = fiber.Map{"from": a.Title, "to": b.Title}
+	}
+	if a.IsPublic != b.IsPublic {
+		out["is_public"] = fiber.Map{"from": a.IsPublic, "to": b.IsPublic}
+	}
+	added, removed := []string{}, []string{}
+	for _, t := range b.Tags {
+		if !slices.Contains(a.Tags, t) {
+			added = append(added, t)
+		}
+	}
+	for _, t := range a.Tags {
+		if !slices.Contains(b.Tags, t) {
+			removed = append(removed, t)
+		}
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		out["tags"] = fiber.Map{"added": added, "removed": removed}
+	}
+	return c.JSON(out)
+}
+
+// RestoreRevision makes an old version current again. The restore is itself a
+// new version, so it can be undone the same way. It honors If-Match like PUT.
+func (h *NoteHandler) RestoreRevision(c *fiber.Ctx) error {
+	version, err := strconv.Atoi(c.Params("version"))
+	if err != nil || version < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid version"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := h.authorizedNote(c, ctx, authz.NoteUpdate)
+	if n == nil {
+		return err
+	}
+	rev, err := h.Revisions.Get(ctx, n.ID, version)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to restore revision"})
+	}
+	if rev == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	current, ok, err := h.precondition(c, n)
+	if !ok {
+		return err
+	}
+	if rev.IsPublic && !n.IsPublic {
+		ok, err := h.canPublish(ctx, n.UserID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to restore revision"})
+		}
+		if !ok {
+			return c.Status(403).JSON(fiber.Map{"error": "verify your email to publish notes"})
+		}
+	}
+
+	updated, err := h.saveEdit(ctx, n, bson.M{
+		"title":     rev.Title,
+		"content":   rev.Content,
+		"is_public": rev.IsPublic,
+		"tags":      rev.Tags,
+	}, middleware.Subject(c).UserID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to restore revision"})
+	}
+	if updated == nil {
+		return h.writeMissed(c, ctx, n.ID, current)
+	}
+	// restoring what the note already says saves nothing
+	if updated.Version != n.Version {
+		h.auditUpdate(c, ctx, n, updated, map[string]string{"restored_from": strconv.Itoa(version)})
+	}
+	c.Set(fiber.HeaderETag, noteETag(updated))
+	return c.JSON(updated)
+}
+
+// authorizedNote loads the note named by the id param and checks that the
+// caller may perform action on it. A nil note means the error response has
+// been written; return the accompanying error.
+func (h *NoteHandler) authorizedNote(c *fiber.Ctx, ctx context.Context, action authz.Action) (*models.Note, error) {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	n, err := h.NoteRepo.GetById(ctx, oid)
+	if err != nil {
+		return nil, c.Status(500).JSON(fiber.Map{"error": "failed to fetch note"})
+	}
+	if n == nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if !h.Policy.Can(middleware.Subject(c), action, n) {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+	}
+	return n, nil
+}
+
+// revisionAt returns version of n, using the note itself for the current one.
+func (h *NoteHandler) revisionAt(ctx context.Context, n *models.Note, version int) (*models.NoteRevision, error) {
+	if version == n.Version {
+		return &models.NoteRevision{
+			NoteID:   n.ID,
+			UserID:   n.UserID,
+			Version:  n.Version,
+			Title:    n.Title,
+			Content:  n.Content,
+			IsPublic: n.IsPublic,
+			Tags:     n.Tags,
+		}, nil
+	}
+	return h.Revisions.Get(ctx, n.ID, version)
+}
@@ -2,39 +2,282 @@ package handlers
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/audit"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/authz"
 	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/middleware"
 	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
 	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// noteStore is the part of repo.NoteRepo edits need.
+type noteStore interface {
+	GetById(ctx context.Context, id primitive.ObjectID) (*models.Note, error)
+	Update(ctx context.Context, id primitive.ObjectID, version int, update bson.M) (*models.Note, error)
+}
+
+// revisionStore is the part of repo.NoteRevisionRepo edits need.
+type revisionStore interface {
+	Create(ctx context.Context, n *models.Note, editedBy primitive.ObjectID) error
+	Claim(ctx context.Context, n *models.Note, editedBy primitive.ObjectID) (primitive.ObjectID, error)
+	Release(ctx context.Context, id primitive.ObjectID) error
+	ReleaseAbandoned(ctx context.Context, noteID primitive.ObjectID, version int, cutoff time.Time) (bool, error)
+}
+
 type NoteHandler struct {
-	NoteRepo *repo.NoteRepo
-	Config   *config.Config
+	NoteRepo  *repo.NoteRepo
+	Revisions *repo.NoteRevisionRepo
+	UserRepo  *repo.UserRepo
+	Policy    *authz.Policy
+	Audit     *audit.Recorder
+	Config    *config.Config
+
+	notes     noteStore
+	revisions revisionStore
 }
 
-func NewNoteHandler(noteRepo *repo.NoteRepo, cfg *config.Config) *NoteHandler {
+func NewNoteHandler(noteRepo *repo.NoteRepo, revisions *repo.NoteRevisionRepo, userRepo *repo.UserRepo, policy *authz.Policy, rec *audit.Recorder, cfg *config.Config) *NoteHandler {
 	return &NoteHandler{
-		NoteRepo: noteRepo,
-		Config:   cfg,
+		NoteRepo:  noteRepo,
+		Revisions: revisions,
+		UserRepo:  userRepo,
+		Policy:    policy,
+		Audit:     rec,
+		Config:    cfg,
+		notes:     noteRepo,
+		revisions: revisions,
 	}
 }
 
-func (h *NoteHandler) CreateNote(c *fiber.Ctx) error {
-	var req struct {
-		Title    string   `json:"title"`
-		Content  string   `json:"content"`
-		IsPublic bool     `json:"is_public"`
-		Tags     []string `json:"tags"`
+// abandonedClaimAge is how long a claimed revision may wait for its edit
+// before another edit may take the version over. It is well past the request
+// timeout, so the edit that claimed it has failed.
+const abandonedClaimAge = time.Minute
+
+// saveEdit applies update to n and records the result in n's history, or does
+// neither. The new revision is written first to claim the next version; the
+// unique index on versions makes that claim the lock that stops two edits of
+// the same version from both going through, and the claim is released unless
+// the note was updated. A nil note means n is no longer at the version it was
+// read at. An update that changes nothing saves nothing and returns n.
+func (h *NoteHandler) saveEdit(ctx context.Context, n *models.Note, update bson.M, editor primitive.ObjectID) (*models.Note, error) {
+	next := editedNote(n, update)
+	if sameNote(n, next) {
+		return n, nil
+	}
+	if n.Version == 0 {
+		// keep what notes from before revisions existed looked like
+		if err := h.revisions.Create(ctx, n, n.UserID); err != nil {
+			return nil, err
+		}
+	}
+	claim, err := h.claimVersion(ctx, next, editor)
+	if err != nil || claim.IsZero() {
+		return nil, err
+	}
+	updated, err := h.notes.Update(ctx, n.ID, n.Version, update)
+	if err != nil {
+		h.releaseFailedClaim(n.ID, claim, next.Version)
+		return nil, err
+	}
+	if updated == nil {
+		h.releaseClaim(ctx, n.ID, claim, next.Version)
+		return nil, nil
+	}
+	return updated, nil
+}
+
+// releaseFailedClaim releases the claim of an edit whose update failed, unless
+// the note shows the update was applied after all. It does not use the
+// request's context, which may be what made the update fail.
+func (h *NoteHandler) releaseFailedClaim(noteID, claim primitive.ObjectID, version int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	current, err := h.notes.GetById(ctx, noteID)
+	if err == nil && current != nil && current.Version >= version {
+		return
+	}
+	h.releaseClaim(ctx, noteID, claim, version)
+}
+
+func (h *NoteHandler) releaseClaim(ctx context.Context, noteID, claim primitive.ObjectID, version int) {
+	if err := h.revisions.Release(ctx, claim); err != nil {
+		// the next edit takes the version over once it is abandoned
+		log.Printf("failed to release revision %d of note %s: %v", version, noteID.Hex(), err)
+	}
+}
+
+// claimVersion records next as a revision and returns its id. The id is zero
+// when another edit holds next's version, unless that edit was abandoned
+// without updating the note, in which case the version is taken over.
+func (h *NoteHandler) claimVersion(ctx context.Context, next *models.Note, editor primitive.ObjectID) (primitive.ObjectID, error) {
+	claim, err := h.revisions.Claim(ctx, next, editor)
+	if err != nil || !claim.IsZero() {
+		return claim, err
+	}
+	current, err := h.notes.GetById(ctx, next.ID)
+	if err != nil || current == nil || current.Version != next.Version-1 {
+		return primitive.NilObjectID, err
+	}
+	released, err := h.revisions.ReleaseAbandoned(ctx, next.ID, next.Version, time.Now().UTC().Add(-abandonedClaimAge))
+	if err != nil || !released {
+		return primitive.NilObjectID, err
+	}
+	return h.revisions.Claim(ctx, next, editor)
+}
+
+// editedNote is n as update will leave it.
+func editedNote(n *models.Note, update bson.M) *models.Note {
+	next := *n
+	if v, ok := update["title"].(string); ok {
+		next.Title = v
+	}
+	if v, ok := update["content"].(string); ok {
+		next.Content = v
+	}
+	if v, ok := update["is_public"].(bool); ok {
+		next.IsPublic = v
+	}
+	if v, ok := update["tags"].([]string); ok {
+		next.Tags = v
+	}
+	next.Version = n.Version + 1
+	return &next
+}
+
+// sameNote reports whether a and b have the same editable fields.
+func sameNote(a, b *models.Note) bool {
+	return a.Title == b.Title && a.Content == b.Content && a.IsPublic == b.IsPublic && slices.Equal(a.Tags, b.Tags)
+}
+
+// editableNoteFields are the fields of a note its editors set.
+var editableNoteFields = []string{"title", "content", "is_public", "tags"}
+
+// readOnlyNoteFields are part of a note but cannot be written.
+var readOnlyNoteFields = []string{"id", "user_id", "version", "created_at", "updated_at", "deleted_at"}
+
+type noteFields struct {
+	Title    string
+	Content  string
+	IsPublic bool
+	Tags     []string
+}
+
+// noteDocument is the editable part of n as decoded JSON, the form notes are
+// patched and validated in.
+func noteDocument(n *models.Note) map[string]any {
+	tags := make([]any, len(n.Tags))
+	for i, t := range n.Tags {
+		tags[i] = t
+	}
+	return map[string]any{
+		"title":     n.Title,
+		"content":   n.Content,
+		"is_public": n.IsPublic,
+		"tags":      tags,
+	}
+}
+
+// withNoteFields sets every field of a POST or PUT body on doc, so that
+// validateNote reports the ones a note does not have, as it does for PATCH.
+func withNoteFields(doc, req map[string]any) map[string]any {
+	maps.Copy(doc, req)
+	return doc
+}
+
+// validateNote checks a note document, as written by POST, PUT or PATCH,
+// strictly: title, content and is_public must be present with the right
+// type, tags must be strings, and nothing else may be set. Missing or null
+// tags mean none. It returns a message per offending field.
+func validateNote(doc any) (noteFields, map[string]string) {
+	var f noteFields
+	errs := map[string]string{}
+	m, ok := doc.(map[string]any)
+	if !ok {
+		errs[""] = "note must be an object"
+		return f, errs
+	}
+
+	for k := range m {
+		if slices.Contains(editableNoteFields, k) {
+			continue
+		}
+		if slices.Contains(readOnlyNoteFields, k) {
+			errs[k] = "is read-only"
+		} else {
+			errs[k] = "unknown field"
+		}
+	}
+	for k, dst := range map[string]*string{"title": &f.Title, "content": &f.Content} {
+		switch v := m[k].(type) {
+		case string:
+			*dst = v
+		case nil:
+			errs[k] = "is required"
+		default:
+			errs[k] = "must be a string"
+		}
+	}
+	switch v := m["is_public"].(type) {
+	case bool:
+		f.IsPublic = v
+	case nil:
+		errs["is_public"] = "is required"
+	default:
+		errs["is_public"] = "must be a boolean"
+	}
+	f.Tags = []string{}
+	switch v := m["tags"].(type) {
+	case []any:
+		for i, e := range v {
+			s, ok := e.(string)
+			if !ok || strings.TrimSpace(s) == "" {
+				errs[fmt.Sprintf("tags[%d]", i)] = "must be a non-empty string"
+				continue
+			}
+			f.Tags = append(f.Tags, s)
+		}
+	case nil:
+	default:
+		errs["tags"] = "must be an array of strings"
+	}
+	return f, errs
+}
+
+// canPublish reports whether the user may make notes public under the
+// configured email verification policy.
+func (h *NoteHandler) canPublish(ctx context.Context, userID primitive.ObjectID) (bool, error) {
+	if h.Config.EmailVerificationPolicy == config.VerifyNone {
+		return true, nil
+	}
+	u, err := h.UserRepo.FindByID(ctx, userID)
+	if err != nil || u == nil {
+		return false, err
 	}
+	return u.EmailVerified, nil
+}
+
+func (h *NoteHandler) CreateNote(c *fiber.Ctx) error {
+	var req map[string]any
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
 	}
+	fields, errs := validateNote(withNoteFields(noteDocument(&models.Note{}), req))
+	if len(errs) > 0 {
+		return c.Status(422).JSON(fiber.Map{"error": "invalid note", "fields": errs})
+	}
 	// get user id from locals
 	uid := c.Locals("user_id")
 	if uid == nil {
@@ -44,18 +287,39 @@ func (h *NoteHandler) CreateNote(c *fiber.Ctx) error {
 
 	n := &models.Note{
 		UserID:   userId,
-		Title:    req.Title,
-		Content:  req.Content,
-		IsPublic: req.IsPublic,
-		Tags:     req.Tags,
+		Title:    fields.Title,
+		Content:  fields.Content,
+		IsPublic: fields.IsPublic,
+		Tags:     fields.Tags,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if n.IsPublic {
+		ok, err := h.canPublish(ctx, userId)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to create note"})
+		}
+		if !ok {
+			return c.Status(403).JSON(fiber.Map{"error": "verify your email to publish notes"})
+		}
+	}
+
 	if err := h.NoteRepo.Create(ctx, n); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "failed to create note"})
 	}
+	if err := h.Revisions.Create(ctx, n, userId); err != nil {
+		// a note without its first version would have a gap in its history
+		if err := h.NoteRepo.Delete(ctx, n.ID); err != nil {
+			log.Printf("failed to remove note %s after its revision failed: %v", n.ID.Hex(), err)
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "failed to create note"})
+	}
+	ev := noteEvent(c, models.AuditNoteCreate, n)
+	ev.Details["is_public"] = strconv.FormatBool(n.IsPublic)
+	h.Audit.Record(ctx, ev)
+	c.Set(fiber.HeaderETag, noteETag(n))
 	return c.Status(201).JSON(n)
 
 }
@@ -108,12 +372,13 @@ func (h *NoteHandler) GetNoteByID(c *fiber.Ctx) error {
 	if n == nil {
 		return c.Status(404).JSON(fiber.Map{"error": "not found"})
 	}
-	// if note is private, ensure owner or authenticated user
-	if !n.IsPublic {
-		userIDIface := c.Locals("user_id")
-		if userIDIface == nil || userIDIface.(primitive.ObjectID) != n.UserID {
-			return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
-		}
+	if !h.Policy.Can(middleware.Subject(c), authz.NoteRead, n) {
+		return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+	}
+	etag := noteETag(n)
+	c.Set(fiber.HeaderETag, etag)
+	if etagListed(c.Get(fiber.HeaderIfNoneMatch), etag, true) {
+		return c.SendStatus(304)
 	}
 	return c.JSON(n)
 }
@@ -129,7 +394,6 @@ func (h *NoteHandler) UpdateNote(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid body"})
 	}
 
-	// ensure owner
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -141,41 +405,82 @@ func (h *NoteHandler) UpdateNote(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "not found"})
 	}
 
-	userIDIface := c.Locals("user_id")
-	if userIDIface == nil || userIDIface.(primitive.ObjectID) != n.UserID {
+	if !h.Policy.Can(middleware.Subject(c), authz.NoteUpdate, n) {
 		return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
 	}
+	version, ok, err := h.precondition(c, n)
+	if !ok {
+		return err
+	}
 
+	fields, errs := validateNote(withNoteFields(noteDocument(n), req))
+	if len(errs) > 0 {
+		return c.Status(422).JSON(fiber.Map{"error": "invalid note", "fields": errs})
+	}
+	// only the fields that change are written
 	update := bson.M{}
-	if v, ok := req["title"].(string); ok {
-		update["title"] = v
+	if fields.Title != n.Title {
+		update["title"] = fields.Title
 	}
-	if v, ok := req["content"].(string); ok {
-		update["content"] = v
+	if fields.Content != n.Content {
+		update["content"] = fields.Content
 	}
-	if v, ok := req["is_public"].(bool); ok {
-		update["is_public"] = v
+	if fields.IsPublic != n.IsPublic {
+		update["is_public"] = fields.IsPublic
 	}
-	if v, ok := req["tags"].([]interface{}); ok {
-		var tags []string
-		for _, iv := range v {
-			if s, ok := iv.(string); ok {
-				tags = append(tags, s)
-			}
+	if !slices.Equal(fields.Tags, n.Tags) {
+		update["tags"] = fields.Tags
+	}
+	if len(update) == 0 {
+		c.Set(fiber.HeaderETag, noteETag(n))
+		return c.JSON(n)
+	}
+	if fields.IsPublic && !n.IsPublic {
+		ok, err := h.canPublish(ctx, n.UserID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if !ok {
+			return c.Status(403).JSON(fiber.Map{"error": "verify your email to publish notes"})
 		}
-		update["tags"] = tags
 	}
 
-	updated, err := h.NoteRepo.Update(ctx, oid, update)
+	updated, err := h.saveEdit(ctx, n, update, middleware.Subject(c).UserID)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 	if updated == nil {
-		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		return h.writeMissed(c, ctx, oid, version)
+	}
+	changed := make([]string, 0, len(update))
+	for k := range update {
+		if k != "updated_at" {
+			changed = append(changed, k)
+		}
 	}
+	sort.Strings(changed)
+	h.auditUpdate(c, ctx, n, updated, map[string]string{"fields": strings.Join(changed, ",")})
+	c.Set(fiber.HeaderETag, noteETag(updated))
 	return c.JSON(updated)
 }
 
+// auditUpdate records that before was changed into after, adding a visibility
+// event when the note was published or unpublished.
+func (h *NoteHandler) auditUpdate(c *fiber.Ctx, ctx context.Context, before, after *models.Note, details map[string]string) {
+	ev := noteEvent(c, models.AuditNoteUpdate, after)
+	for k, v := range details {
+		ev.Details[k] = v
+	}
+	h.Audit.Record(ctx, ev)
+	if after.IsPublic != before.IsPublic {
+		ev := noteEvent(c, models.AuditNoteVisibility, after)
+		ev.Details["is_public"] = strconv.FormatBool(after.IsPublic)
+		h.Audit.Record(ctx, ev)
+	}
+}
+
+// DeleteNote moves a note to its owner's trash, from where it can be restored
+// until the retention period ends.
 func (h *NoteHandler) DeleteNote(c *fiber.Ctx) error {
 	idHex := c.Params("id")
 	oid, err := primitive.ObjectIDFromHex(idHex)
@@ -183,7 +488,6 @@ func (h *NoteHandler) DeleteNote(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
 	}
 
-	// ensure owner
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -195,13 +499,21 @@ func (h *NoteHandler) DeleteNote(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "not found"})
 	}
 
-	userIDIface := c.Locals("user_id")
-	if userIDIface == nil || userIDIface.(primitive.ObjectID) != n.UserID {
+	if !h.Policy.Can(middleware.Subject(c), authz.NoteDelete, n) {
 		return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
 	}
+	version, ok, err := h.precondition(c, n)
+	if !ok {
+		return err
+	}
 
-	if err := h.NoteRepo.Delete(ctx, oid); err != nil {
+	ok, err = h.NoteRepo.Trash(ctx, oid, version)
+	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
-	return c.JSON(fiber.Map{"message": "note deleted"})
+	if !ok {
+		return h.writeMissed(c, ctx, oid, version)
+	}
+	h.Audit.Record(ctx, noteEvent(c, models.AuditNoteDelete, n))
+	return c.JSON(fiber.Map{"message": "note moved to trash"})
 }
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/patch"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestValidateNote checks that POST, PUT and PATCH agree on what a valid note
+// is: each body is written the way its route writes it and then validated.
+func TestValidateNote(t *testing.T) {
+	current := &models.Note{Title: "t", Content: "c", Tags: []string{"go"}}
+	write := map[string]func(body string) any{
+		"POST": func(body string) any {
+			var req map[string]any
+			json.Unmarshal([]byte(body), &req)
+			return withNoteFields(noteDocument(&models.Note{}), req)
+		},
+		"PUT": func(body string) any {
+			var req map[string]any
+			json.Unmarshal([]byte(body), &req)
+			return withNoteFields(noteDocument(current), req)
+		},
+		"PATCH": func(body string) any {
+			doc, err := patch.Merge(noteDocument(current), []byte(body))
+			if err != nil {
+				t.Fatalf("Merge(%s): %v", body, err)
+			}
+			return doc
+		},
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantTags []string
+		wantErrs []string
+	}{
+		{name: "tags", body: `{"tags":["a","b"]}`, wantTags: []string{"a", "b"}},
+		{name: "no tags", body: `{"tags":[]}`, wantTags: []string{}},
+		{name: "null tags mean none", body: `{"tags":null}`, wantTags: []string{}},
+		{name: "empty tag", body: `{"tags":["a",""]}`, wantErrs: []string{"tags[1]"}},
+		{name: "blank tag", body: `{"tags":["  "]}`, wantErrs: []string{"tags[0]"}},
+		{name: "tag not a string", body: `{"tags":[1]}`, wantErrs: []string{"tags[0]"}},
+		{name: "tags not an array", body: `{"tags":"a"}`, wantErrs: []string{"tags"}},
+		{name: "title not a string", body: `{"title":1}`, wantErrs: []string{"title"}},
+		{name: "is_public not a boolean", body: `{"is_public":"yes"}`, wantErrs: []string{"is_public"}},
+		{name: "unknown field", body: `{"title":"x","color":"red"}`, wantErrs: []string{"color"}},
+		{name: "read-only field", body: `{"version":7,"id":"x"}`, wantErrs: []string{"id", "version"}},
+	}
+	for _, tt := range tests {
+		for _, method := range []string{"POST", "PUT", "PATCH"} {
+			t.Run(method+" "+tt.name, func(t *testing.T) {
+				fields, errs := validateNote(write[method](tt.body))
+				if got := slices.Sorted(maps.Keys(errs)); !slices.Equal(got, tt.wantErrs) {
+					t.Fatalf("errors = %v, want %v", errs, tt.wantErrs)
+				}
+				if tt.wantErrs == nil && !reflect.DeepEqual(fields.Tags, tt.wantTags) {
+					t.Fatalf("tags = %#v, want %#v", fields.Tags, tt.wantTags)
+				}
+			})
+		}
+	}
+}
+
+func TestValidateNoteStrict(t *testing.T) {
+	doc := map[string]any{"title": nil, "content": "c", "is_public": false, "version": 2.0, "color": "red"}
+	_, errs := validateNote(doc)
+	want := map[string]string{"title": "is required", "version": "is read-only", "color": "unknown field"}
+	if !maps.Equal(errs, want) {
+		t.Fatalf("errors = %v, want %v", errs, want)
+	}
+}
+
+// fakeRevisions is an in-memory revisionStore; like the unique index, it
+// refuses a second revision of the same version.
+type fakeRevisions struct {
+	revisions map[primitive.ObjectID]*models.Note
+}
+
+func (f *fakeRevisions) Create(ctx context.Context, n *models.Note, editedBy primitive.ObjectID) error {
+	_, err := f.Claim(ctx, n, editedBy)
+	return err
+}
+
+func (f *fakeRevisions) Claim(ctx context.Context, n *models.Note, editedBy primitive.ObjectID) (primitive.ObjectID, error) {
+	if f.revisions == nil {
+		f.revisions = map[primitive.ObjectID]*models.Note{}
+	}
+	if f.version(n.Version) != nil {
+		return primitive.NilObjectID, nil
+	}
+	id := primitive.NewObjectID()
+	cp := *n
+	f.revisions[id] = &cp
+	return id, nil
+}
+
+func (f *fakeRevisions) Release(ctx context.Context, id primitive.ObjectID) error {
+	delete(f.revisions, id)
+	return nil
+}
+
+func (f *fakeRevisions) ReleaseAbandoned(ctx context.Context, noteID primitive.ObjectID, version int, cutoff time.Time) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeRevisions) version(v int) *models.Note {
+	for _, r := range f.revisions {
+		if r.Version == v {
+			return r
+		}
+	}
+	return nil
+}
+
+func TestSaveEdit(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name string
+		// stored is the note in the store, read is the note the edit read
+		stored, read  int
+		update        bson.M
+		notes         *fakeNotes
+		claimed       []int
+		wantVersion   int
+		wantErr       bool
+		wantRevisions []int
+		wantUpdates   int
+	}{
+		{
+			name: "saves and records", stored: 1, read: 1, update: bson.M{"title": "new"},
+			notes: &fakeNotes{}, wantVersion: 2, wantRevisions: []int{2}, wantUpdates: 1,
+		},
+		{
+			name: "keeps a note from before revisions", stored: 0, read: 0, update: bson.M{"title": "new"},
+			notes: &fakeNotes{}, wantVersion: 1, wantRevisions: []int{0, 1}, wantUpdates: 1,
+		},
+		{
+			name: "unchanged saves nothing", stored: 1, read: 1, update: bson.M{"title": "old", "tags": []string{}},
+			notes: &fakeNotes{}, wantVersion: 1,
+		},
+		{
+			name: "note moved on: claim released", stored: 2, read: 1, update: bson.M{"title": "new"},
+			notes: &fakeNotes{}, wantUpdates: 1,
+		},
+		{
+			name: "version held by another edit", stored: 1, read: 1, update: bson.M{"title": "new"},
+			notes: &fakeNotes{}, claimed: []int{2}, wantRevisions: []int{2},
+		},
+		{
+			name: "update fails: claim released", stored: 1, read: 1, update: bson.M{"title": "new"},
+			notes: &fakeNotes{updateErr: errors.New("timeout")}, wantErr: true, wantUpdates: 1,
+		},
+		{
+			name: "update fails after applying: claim kept", stored: 1, read: 1, update: bson.M{"title": "new"},
+			notes: &fakeNotes{updateErr: errors.New("timeout"), applied: true}, wantErr: true, wantRevisions: []int{2}, wantUpdates: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.notes.notes = map[primitive.ObjectID]*models.Note{id: {ID: id, Title: "old", Version: tt.stored}}
+			revisions := &fakeRevisions{}
+			for _, v := range tt.claimed {
+				revisions.Claim(context.Background(), &models.Note{ID: id, Version: v}, primitive.NewObjectID())
+			}
+			h := &NoteHandler{notes: tt.notes, revisions: revisions}
+
+			read := &models.Note{ID: id, Title: "old", Version: tt.read}
+			updated, err := h.saveEdit(context.Background(), read, tt.update, primitive.NewObjectID())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			switch {
+			case tt.wantVersion == 0 && updated != nil:
+				t.Errorf("saveEdit() = version %d, want nil", updated.Version)
+			case tt.wantVersion != 0 && (updated == nil || updated.Version != tt.wantVersion):
+				t.Errorf("saveEdit() = %+v, want version %d", updated, tt.wantVersion)
+			}
+			var got []int
+			for _, r := range revisions.revisions {
+				got = append(got, r.Version)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.wantRevisions) {
+				t.Errorf("revisions = %v, want %v", got, tt.wantRevisions)
+			}
+			if tt.notes.updates != tt.wantUpdates {
+				t.Errorf("%d updates, want %d", tt.notes.updates, tt.wantUpdates)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcFlowCookie  = "oidc_flow"
+	oidcFlowTTL     = 10 * time.Minute
+	identityLinkTTL = 10 * time.Minute
+)
+
+// oidcUsers is the part of the user repository OIDC sign-in needs.
+type oidcUsers interface {
+	FindByIdentity(ctx context.Context, issuer, subject string) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	LinkIdentity(ctx context.Context, id primitive.ObjectID, identity models.Identity) error
+	Create(ctx context.Context, user *models.User) error
+}
+
+// OIDCHandler signs users in through an external OpenID Connect provider
+// using the authorization code flow with PKCE, then issues the same tokens
+// as AuthHandler.Login.
+type OIDCHandler struct {
+	Auth   *AuthHandler
+	Config *config.Config
+
+	users    oidcUsers
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func NewOIDCHandler(auth *AuthHandler, cfg *config.Config) *OIDCHandler {
+	return &OIDCHandler{
+		Auth:   auth,
+		Config: cfg,
+		users:  auth.UserRepo,
+	}
+}
+
+// oidcIdentity is what a verified ID token says about the user.
+type oidcIdentity struct {
+	models.Identity
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+// discover fetches the provider metadata on first use, so the API still starts
+// while the identity provider is unreachable.
+func (h *OIDCHandler) discover(ctx context.Context) (*oidc.Provider, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.provider != nil {
+		return h.provider, nil
+	}
+	p, err := oidc.NewProvider(ctx, h.Config.OIDCIssuer)
+	if err != nil {
+		return nil, err
+	}
+	h.provider = p
+	return p, nil
+}
+
+func (h *OIDCHandler) oauth2Config(p *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     h.Config.OIDCClientID,
+		ClientSecret: h.Config.OIDCClientSecret,
+		RedirectURL:  h.Config.OIDCRedirectURL,
+		Endpoint:     p.Endpoint(),
+		Scopes:       strings.Fields(h.Config.OIDCScopes),
+	}
+}
+
+// Login redirects to the provider. State, nonce and the PKCE verifier travel
+// in a short-lived signed cookie instead of server-side storage.
+func (h *OIDCHandler) Login(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p, err := h.discover(ctx)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": "identity provider unavailable"})
+	}
+
+	state, _, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to start login"})
+	}
+	nonce, _, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to start login"})
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	flow, err := h.Auth.Tokens.SignPurpose(models.PurposeOIDCFlow, jwt.MapClaims{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+	}, oidcFlowTTL)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to start login"})
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    flow,
+		Path:     "/api/oidc",
+		MaxAge:   int(oidcFlowTTL / time.Second),
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	url := h.oauth2Config(p).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	return c.Redirect(url, fiber.StatusFound)
+}
+
+// Callback finishes the code exchange, verifies the ID token and signs in the
+// user owning the identity, linking or creating an account by verified email.
+// An existing account that has its own credentials is only linked once its
+// owner confirms with Link, so a provider cannot take it over by asserting
+// the same email.
+func (h *OIDCHandler) Callback(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id, err := h.verifyCallback(c, ctx)
+	if id == nil {
+		return err
+	}
+	user, err := h.resolveUser(ctx, id)
+	if errors.Is(err, errRegistrationClosed) {
+		return c.Status(403).JSON(fiber.Map{"error": "no account for this identity and registration is closed"})
+	}
+	if errors.Is(err, errLinkRequired) {
+		return h.Auth.linkRequired(c, user, id.Identity)
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to sign in"})
+	}
+	if user == nil {
+		return c.Status(403).JSON(fiber.Map{"error": "identity provider did not return a verified email"})
+	}
+	return h.Auth.finishLogin(c, ctx, user, "oidc")
+}
+
+// verifyCallback checks the callback against the flow cookie, redeems the
+// code with the PKCE verifier and verifies the ID token and its nonce. A nil
+// identity means the error response has been written; return the accompanying
+// error.
+func (h *OIDCHandler) verifyCallback(c *fiber.Ctx, ctx context.Context) (*oidcIdentity, error) {
+	if e := c.Query("error"); e != "" {
+		return nil, c.Status(401).JSON(fiber.Map{"error": "login failed at identity provider: " + e})
+	}
+	flow, err := h.Auth.Tokens.ParsePurpose(models.PurposeOIDCFlow, c.Cookies(oidcFlowCookie))
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "login session expired, start again"})
+	}
+	c.ClearCookie(oidcFlowCookie)
+
+	state, _ := flow["state"].(string)
+	nonce, _ := flow["nonce"].(string)
+	verifier, _ := flow["verifier"].(string)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "state mismatch"})
+	}
+
+	p, err := h.discover(ctx)
+	if err != nil {
+		return nil, c.Status(502).JSON(fiber.Map{"error": "identity provider unavailable"})
+	}
+	tok, err := h.oauth2Config(p).Exchange(ctx, c.Query("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, c.Status(401).JSON(fiber.Map{"error": "failed to exchange code"})
+	}
+	rawID, ok := tok.Extra("id_token").(string)
+	if !ok {
+		return nil, c.Status(401).JSON(fiber.Map{"error": "no id_token in response"})
+	}
+	idToken, err := p.Verifier(&oidc.Config{ClientID: h.Config.OIDCClientID}).Verify(ctx, rawID)
+	if err != nil {
+		return nil, c.Status(401).JSON(fiber.Map{"error": "invalid id_token"})
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, c.Status(401).JSON(fiber.Map{"error": "nonce mismatch"})
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, c.Status(401).JSON(fiber.Map{"error": "invalid id_token claims"})
+	}
+	return &oidcIdentity{
+		Identity:      models.Identity{Issuer: idToken.Issuer, Subject: idToken.Subject},
+		Email:         models.NormalizeEmail(claims.Email),
+		EmailVerified: claims.EmailVerified,
+		Username:      claims.PreferredUsername,
+	}, nil
+}
+
+// Link attaches the identity named by a link_token, from Callback or a
+// directory login, to the caller's account. Being logged in proves the caller
+// owns the account.
+func (h *OIDCHandler) Link(c *fiber.Ctx) error {
+	var req struct {
+		LinkToken string `json:"link_token"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	claims, err := h.Auth.Tokens.ParsePurpose(models.PurposeIdentityLink, req.LinkToken)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired link token"})
+	}
+	uid, _ := claims["user_id"].(string)
+	issuer, _ := claims["idp_iss"].(string)
+	subject, _ := claims["idp_sub"].(string)
+	if issuer == "" || subject == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired link token"})
+	}
+	if uid != userID.Hex() {
+		return c.Status(403).JSON(fiber.Map{"error": "link token belongs to another account"})
+	}
+	identity := models.Identity{Issuer: issuer, Subject: subject}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	owner, err := h.users.FindByIdentity(ctx, issuer, subject)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to link identity"})
+	}
+	if owner != nil && owner.ID != userID {
+		return c.Status(409).JSON(fiber.Map{"error": "identity is linked to another account"})
+	}
+	if owner == nil {
+		if err := h.users.LinkIdentity(ctx, userID, identity); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to link identity"})
+		}
+		ev := auditEvent(c, models.AuditIdentityLink, models.AuditSuccess)
+		ev.Details = map[string]string{"issuer": issuer, "subject": subject}
+		h.Auth.Audit.Record(ctx, ev)
+	}
+	return c.JSON(fiber.Map{"message": "identity linked", "identity": identity})
+}
+
+var (
+	// errRegistrationClosed is returned by resolveUser when a new account
+	// would be needed but the registration mode does not allow sign-ups
+	// without an invite.
+	errRegistrationClosed = errors.New("registration is closed")
+	// errLinkRequired is returned by resolveUser, together with the account,
+	// when the identity matches an account its owner must confirm linking.
+	errLinkRequired = errors.New("account link requires confirmation")
+)
+
+// resolveUser returns the account linked to id. Unknown identities are linked
+// to the account with the same email if it has no credentials of its own, or
+// a new account is created if registration is open; both require the provider
+// to have verified the email, otherwise nil is returned.
+func (h *OIDCHandler) resolveUser(ctx context.Context, id *oidcIdentity) (*models.User, error) {
+	user, err := h.users.FindByIdentity(ctx, id.Issuer, id.Subject)
+	if err != nil || user != nil {
+		return user, err
+	}
+	if id.Email == "" || !id.EmailVerified {
+		return nil, nil
+	}
+
+	user, err = h.users.FindByEmail(ctx, id.Email)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		if user.HasCredentials() {
+			return user, errLinkRequired
+		}
+		if err := h.users.LinkIdentity(ctx, user.ID, id.Identity); err != nil {
+			return nil, err
+		}
+		user.EmailVerified = true
+		return user, nil
+	}
+
+	if h.Config.RegistrationMode != config.RegistrationOpen {
+		return nil, errRegistrationClosed
+	}
+
+	// the provider's handle is only a suggestion; without a usable one the
+	// user picks a username later
+	username := id.Username
+	if !models.ValidUsername(username) {
+		username = ""
+	} else if taken, err := h.users.FindByUsername(ctx, username); err != nil {
+		return nil, err
+	} else if taken != nil {
+		username = ""
+	}
+
+	now := time.Now().UTC()
+	user = &models.User{
+		Username:        username,
+		Email:           id.Email,
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		Identities:      []models.Identity{id.Identity},
+	}
+	if err := h.users.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
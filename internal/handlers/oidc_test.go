@@ -0,0 +1,527 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mockIssuer is an OpenID provider that hands out one ID token per
+// authorization code and enforces PKCE on the token endpoint.
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, grants: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub := key.PublicKey
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// grant issues code for an authorization request with the given PKCE
+// challenge; claims are added to the ID token.
+func (m *mockIssuer) grant(code, challenge string, claims jwt.MapClaims) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.grants[code] = mockGrant{challenge: challenge, claims: claims}
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	m.mu.Lock()
+	g, ok := m.grants[r.PostForm.Get("code")]
+	m.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": m.URL,
+		"aud": "notes-api",
+		"sub": "subject-1",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Minute).Unix(),
+	}
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = "test"
+	idToken, _ := tok.SignedString(m.key)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func newTestOIDCHandler(t *testing.T, issuer string, users *fakeUsers) (*OIDCHandler, *fakeAuditStore) {
+	t.Helper()
+	cfg := &config.Config{
+		OIDCIssuer:       issuer,
+		OIDCClientID:     "notes-api",
+		OIDCClientSecret: "secret",
+		OIDCRedirectURL:  "http://localhost:8080/api/oidc/callback",
+		OIDCScopes:       "openid email profile",
+		RegistrationMode: config.RegistrationOpen,
+	}
+	auth, store := newTestAuthHandler(t, cfg)
+	h := NewOIDCHandler(auth, cfg)
+	h.users = users
+	return h, store
+}
+
+// startOIDCLogin runs the redirect to the provider and returns the flow cookie
+// and the authorization request's query.
+func startOIDCLogin(t *testing.T, app *fiber.App) (*http.Cookie, url.Values) {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/oidc/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("login status = %d, want 302", resp.StatusCode)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range resp.Cookies() {
+		if c.Name == oidcFlowCookie {
+			return c, loc.Query()
+		}
+	}
+	t.Fatal("no flow cookie")
+	return nil, nil
+}
+
+func TestOIDCCallback(t *testing.T) {
+	idp := newMockIssuer(t)
+
+	tests := []struct {
+		name       string
+		state      func(q url.Values) string
+		challenge  func(q url.Values) string
+		claims     func(q url.Values) jwt.MapClaims
+		noCookie   bool
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name:       "valid",
+			wantStatus: 200,
+		},
+		{
+			name:       "state mismatch",
+			state:      func(url.Values) string { return "forged" },
+			wantStatus: 400,
+			wantError:  "state mismatch",
+		},
+		{
+			name:       "missing flow cookie",
+			noCookie:   true,
+			wantStatus: 400,
+			wantError:  "login session expired, start again",
+		},
+		{
+			name:       "nonce mismatch",
+			claims:     func(url.Values) jwt.MapClaims { return jwt.MapClaims{"nonce": "replayed"} },
+			wantStatus: 401,
+			wantError:  "nonce mismatch",
+		},
+		{
+			name:       "missing nonce",
+			claims:     func(url.Values) jwt.MapClaims { return jwt.MapClaims{"nonce": nil} },
+			wantStatus: 401,
+			wantError:  "nonce mismatch",
+		},
+		{
+			// the code was issued for someone else's PKCE challenge
+			name:       "pkce verifier mismatch",
+			challenge:  func(url.Values) string { return "another-challenge" },
+			wantStatus: 401,
+			wantError:  "failed to exchange code",
+		},
+		{
+			name:       "wrong audience",
+			claims:     func(url.Values) jwt.MapClaims { return jwt.MapClaims{"aud": "other-client"} },
+			wantStatus: 401,
+			wantError:  "invalid id_token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestOIDCHandler(t, idp.URL, &fakeUsers{})
+			app := fiber.New()
+			app.Get("/api/oidc/login", h.Login)
+			app.Get("/api/oidc/callback", func(c *fiber.Ctx) error {
+				id, err := h.verifyCallback(c, c.Context())
+				if id == nil {
+					return err
+				}
+				return c.JSON(id)
+			})
+
+			cookie, q := startOIDCLogin(t, app)
+			if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+				t.Fatalf("authorization request lacks a S256 PKCE challenge: %v", q)
+			}
+			challenge := q.Get("code_challenge")
+			if tt.challenge != nil {
+				challenge = tt.challenge(q)
+			}
+			claims := jwt.MapClaims{"nonce": q.Get("nonce"), "email": "a@example.com", "email_verified": true}
+			if tt.claims != nil {
+				for k, v := range tt.claims(q) {
+					claims[k] = v
+				}
+			}
+			idp.grant("code-"+tt.name, challenge, claims)
+
+			state := q.Get("state")
+			if tt.state != nil {
+				state = tt.state(q)
+			}
+			req := httptest.NewRequest("GET", "/api/oidc/callback?"+url.Values{"code": {"code-" + tt.name}, "state": {state}}.Encode(), nil)
+			if !tt.noCookie {
+				req.AddCookie(cookie)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body map[string]any
+			json.NewDecoder(resp.Body).Decode(&body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%v)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantError != "" && body["error"] != tt.wantError {
+				t.Errorf("error = %v, want %q", body["error"], tt.wantError)
+			}
+			if tt.wantStatus == 200 && (body["subject"] != "subject-1" || body["Email"] != "a@example.com" || body["EmailVerified"] != true) {
+				t.Errorf("identity = %v", body)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackAsksToConfirmLink(t *testing.T) {
+	idp := newMockIssuer(t)
+	owner := &models.User{ID: primitive.NewObjectID(), Email: "a@example.com", Password: "hash"}
+	users := &fakeUsers{users: []*models.User{owner}}
+	h, store := newTestOIDCHandler(t, idp.URL, users)
+
+	app := fiber.New()
+	app.Get("/api/oidc/login", h.Login)
+	app.Get("/api/oidc/callback", h.Callback)
+	app.Post("/api/oidc/link", func(c *fiber.Ctx) error {
+		c.Locals("user_id", owner.ID)
+		return c.Next()
+	}, h.Link)
+
+	cookie, q := startOIDCLogin(t, app)
+	idp.grant("code", q.Get("code_challenge"), jwt.MapClaims{"nonce": q.Get("nonce"), "email": "A@example.com", "email_verified": true})
+	req := httptest.NewRequest("GET", "/api/oidc/callback?"+url.Values{"code": {"code"}, "state": {q.Get("state")}}.Encode(), nil)
+	req.AddCookie(cookie)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]any
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != 409 {
+		t.Fatalf("status = %d, want 409 (%v)", resp.StatusCode, body)
+	}
+	if len(owner.Identities) != 0 {
+		t.Fatal("identity was linked without confirmation")
+	}
+	linkToken, _ := body["link_token"].(string)
+	if linkToken == "" {
+		t.Fatal("no link_token")
+	}
+
+	req = httptest.NewRequest("POST", "/api/oidc/link", strings.NewReader(`{"link_token":"`+linkToken+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("link status = %d, want 200", resp.StatusCode)
+	}
+	if len(owner.Identities) != 1 || owner.Identities[0] != (models.Identity{Issuer: idp.URL, Subject: "subject-1"}) {
+		t.Errorf("identities = %v", owner.Identities)
+	}
+	if ev := store.last(); ev == nil || ev.Action != models.AuditIdentityLink {
+		t.Errorf("no %s audit event", models.AuditIdentityLink)
+	}
+}
+
+func TestOIDCLink(t *testing.T) {
+	alice := &models.User{ID: primitive.NewObjectID(), Email: "alice@example.com", Password: "hash"}
+	bob := &models.User{ID: primitive.NewObjectID(), Email: "bob@example.com", Password: "hash"}
+	identity := models.Identity{Issuer: "https://idp.example.com", Subject: "s"}
+
+	tests := []struct {
+		name       string
+		caller     *models.User
+		tokenFor   *models.User
+		linkedTo   *models.User
+		rawToken   string
+		wantStatus int
+	}{
+		{name: "owner confirms", caller: alice, tokenFor: alice, wantStatus: 200},
+		{name: "already linked to caller", caller: alice, tokenFor: alice, linkedTo: alice, wantStatus: 200},
+		{name: "token of another account", caller: bob, tokenFor: alice, wantStatus: 403},
+		{name: "identity linked elsewhere", caller: alice, tokenFor: alice, linkedTo: bob, wantStatus: 409},
+		{name: "garbage token", caller: alice, rawToken: "garbage", wantStatus: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := *alice, *bob
+			users := &fakeUsers{users: []*models.User{&a, &b}}
+			if tt.linkedTo != nil {
+				users.LinkIdentity(context.Background(), tt.linkedTo.ID, identity)
+			}
+			h, _ := newTestOIDCHandler(t, "https://idp.example.com", users)
+
+			token := tt.rawToken
+			if tt.tokenFor != nil {
+				var err error
+				token, err = h.Auth.Tokens.SignPurpose(models.PurposeIdentityLink, jwt.MapClaims{
+					"user_id": tt.tokenFor.ID.Hex(),
+					"idp_iss": identity.Issuer,
+					"idp_sub": identity.Subject,
+				}, time.Minute)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			app := fiber.New()
+			app.Post("/link", func(c *fiber.Ctx) error {
+				c.Locals("user_id", tt.caller.ID)
+				return c.Next()
+			}, h.Link)
+			req := httptest.NewRequest("POST", "/link", strings.NewReader(`{"link_token":"`+token+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			owner, _ := users.FindByIdentity(context.Background(), identity.Issuer, identity.Subject)
+			switch {
+			case tt.linkedTo != nil:
+				if owner == nil || owner.ID != tt.linkedTo.ID || len(owner.Identities) != 1 {
+					t.Errorf("identity moved or duplicated: %+v", owner)
+				}
+			case tt.wantStatus == 200:
+				if owner == nil || owner.ID != tt.caller.ID {
+					t.Errorf("identity not linked to the caller: %+v", owner)
+				}
+			default:
+				if owner != nil {
+					t.Errorf("identity linked to %s", owner.Email)
+				}
+			}
+		})
+	}
+}
+
+func TestOIDCResolveUser(t *testing.T) {
+	const issuer = "https://idp.example.com"
+	identity := models.Identity{Issuer: issuer, Subject: "s"}
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		existing    *models.User
+		email       string
+		unverified  bool
+		username    string
+		mode        string
+		wantErr     error
+		wantUser    bool
+		wantCreated bool
+		wantLinked  bool
+	}{
+		{
+			name:     "known identity",
+			existing: &models.User{Email: "old@example.com", Password: "hash", Identities: []models.Identity{identity}},
+			email:    "a@example.com",
+			wantUser: true,
+		},
+		{
+			name:     "unverified email is not trusted",
+			existing: &models.User{Email: "a@example.com"},
+			email:    "a@example.com", unverified: true,
+		},
+		{
+			name:       "links account without credentials",
+			existing:   &models.User{Email: "a@example.com", EmailVerifiedAt: &now},
+			email:      "a@example.com",
+			wantUser:   true,
+			wantLinked: true,
+		},
+		{
+			name:     "account with password needs confirmation",
+			existing: &models.User{Email: "a@example.com", Password: "hash"},
+			email:    "a@example.com",
+			wantErr:  errLinkRequired,
+			wantUser: true,
+		},
+		{
+			name:     "account with TOTP needs confirmation",
+			existing: &models.User{Email: "a@example.com", TOTPEnabled: true},
+			email:    "a@example.com",
+			wantErr:  errLinkRequired,
+			wantUser: true,
+		},
+		{
+			name:     "account with another provider needs confirmation",
+			existing: &models.User{Email: "a@example.com", Identities: []models.Identity{{Issuer: "https://other.example.com", Subject: "x"}}},
+			email:    "a@example.com",
+			wantErr:  errLinkRequired,
+			wantUser: true,
+		},
+		{
+			name:        "creates account",
+			email:       "new@example.com",
+			username:    "newbie",
+			wantUser:    true,
+			wantCreated: true,
+		},
+		{
+			name:    "registration closed",
+			email:   "new@example.com",
+			mode:    config.RegistrationInviteOnly,
+			wantErr: errRegistrationClosed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &fakeUsers{}
+			if tt.existing != nil {
+				tt.existing.ID = primitive.NewObjectID()
+				users.users = append(users.users, tt.existing)
+			}
+			h, _ := newTestOIDCHandler(t, issuer, users)
+			if tt.mode != "" {
+				h.Config.RegistrationMode = tt.mode
+			}
+
+			user, err := h.resolveUser(context.Background(), &oidcIdentity{
+				Identity:      identity,
+				Email:         tt.email,
+				EmailVerified: !tt.unverified,
+				Username:      tt.username,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if (user != nil) != tt.wantUser {
+				t.Fatalf("user = %+v, want user: %v", user, tt.wantUser)
+			}
+			if tt.existing != nil && user != nil && user.ID != tt.existing.ID {
+				t.Errorf("resolved another account")
+			}
+			linked, _ := users.FindByIdentity(context.Background(), issuer, "s")
+			if tt.wantLinked && (linked == nil || linked.ID != tt.existing.ID) {
+				t.Errorf("identity was not linked to the existing account")
+			}
+			if tt.wantErr != nil && linked != nil {
+				t.Errorf("identity was linked despite %v", tt.wantErr)
+			}
+			if tt.wantCreated {
+				if len(users.users) != 1 || user.Username != tt.username || !user.EmailVerified || user.Password != "" {
+					t.Errorf("created user = %+v", user)
+				}
+			}
+		})
+	}
+}
+
+func TestOIDCResolveUserDropsTakenUsername(t *testing.T) {
+	users := &fakeUsers{}
+	users.Create(context.Background(), &models.User{Username: "Taken", Email: "x@example.com", Password: "hash"})
+	h, _ := newTestOIDCHandler(t, "https://idp.example.com", users)
+
+	user, err := h.resolveUser(context.Background(), &oidcIdentity{
+		Identity:      models.Identity{Issuer: "https://idp.example.com", Subject: "s"},
+		Email:         "new@example.com",
+		EmailVerified: true,
+		Username:      "taken",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "" {
+		t.Errorf("username = %q, want none", user.Username)
+	}
+}
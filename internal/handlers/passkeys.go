@@ -0,0 +1,426 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const passkeyCeremonyTTL = 5 * time.Minute
+
+// passkeyStore is the part of the passkey repository the handler needs.
+type passkeyStore interface {
+	Create(ctx context.Context, p *models.Passkey) error
+	ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.Passkey, error)
+	RecordLogin(ctx context.Context, id primitive.ObjectID, signCount uint32, flags byte) error
+	Delete(ctx context.Context, id, userID primitive.ObjectID) error
+}
+
+// ceremonyStore keeps WebAuthn session data between the two requests of a
+// ceremony. Take removes what it returns.
+type ceremonyStore interface {
+	Create(ctx context.Context, c *models.PasskeyCeremony) error
+	Take(ctx context.Context, purpose, id string) (*models.PasskeyCeremony, error)
+}
+
+// passkeyUsers is the part of the user repository passkeys need.
+type passkeyUsers interface {
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error)
+}
+
+// PasskeyHandler implements WebAuthn registration and passwordless login.
+// Ceremony state (the challenge) stays on the server under a random handle
+// that the client sends back with the authenticator's response. Finishing
+// deletes it, so a response cannot be replayed.
+type PasskeyHandler struct {
+	Auth *AuthHandler
+
+	users      passkeyUsers
+	passkeys   passkeyStore
+	ceremonies ceremonyStore
+	webauthn   *webauthn.WebAuthn
+}
+
+// NewPasskeyHandler configures the relying party. The RP id and allowed
+// origins default to the host and origin of APP_BASE_URL.
+func NewPasskeyHandler(auth *AuthHandler, passkeys *repo.PasskeyRepo, ceremonies *repo.PasskeyCeremonyRepo, cfg *config.Config) (*PasskeyHandler, error) {
+	base, err := url.Parse(cfg.AppBaseURL)
+	if err != nil {
+		return nil, err
+	}
+	rpID := cfg.WebAuthnRPID
+	if rpID == "" {
+		rpID = base.Hostname()
+	}
+	origins := cfg.WebAuthnOrigins
+	if len(origins) == 0 {
+		origins = []string{base.Scheme + "://" + base.Host}
+	}
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: cfg.WebAuthnRPName,
+		RPOrigins:     origins,
+		// passkeys are a login on their own, so the authenticator has to
+		// verify the user and keep the credential discoverable
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+		AttestationPreference: protocol.PreferNoAttestation,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyHandler{
+		Auth:       auth,
+		users:      auth.UserRepo,
+		passkeys:   passkeys,
+		ceremonies: ceremonies,
+		webauthn:   w,
+	}, nil
+}
+
+// passkeyUser adapts a user and their stored passkeys to webauthn.User. The
+// user handle is the account's ObjectID.
+type passkeyUser struct {
+	user     *models.User
+	passkeys []models.Passkey
+}
+
+func (u *passkeyUser) WebAuthnID() []byte { return u.user.ID[:] }
+
+func (u *passkeyUser) WebAuthnName() string {
+	if u.user.Username != "" {
+		return u.user.Username
+	}
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	if u.user.DisplayName != "" {
+		return u.user.DisplayName
+	}
+	return u.WebAuthnName()
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	out := make([]webauthn.Credential, len(u.passkeys))
+	for i, p := range u.passkeys {
+		transports := make([]protocol.AuthenticatorTransport, len(p.Transports))
+		for j, t := range p.Transports {
+			transports[j] = protocol.AuthenticatorTransport(t)
+		}
+		out[i] = webauthn.Credential{
+			ID:              p.CredentialID,
+			PublicKey:       p.PublicKey,
+			AttestationType: p.AttestationType,
+			Transport:       transports,
+			Flags:           webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(p.Flags)),
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    p.AAGUID,
+				SignCount: p.SignCount,
+			},
+		}
+	}
+	return out
+}
+
+// passkey returns the stored passkey with the given credential id.
+func (u *passkeyUser) passkey(credentialID []byte) *models.Passkey {
+	for i := range u.passkeys {
+		if bytes.Equal(u.passkeys[i].CredentialID, credentialID) {
+			return &u.passkeys[i]
+		}
+	}
+	return nil
+}
+
+func (h *PasskeyHandler) loadUser(ctx context.Context, userID primitive.ObjectID) (*passkeyUser, error) {
+	user, err := h.users.FindByID(ctx, userID)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	passkeys, err := h.passkeys.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &passkeyUser{user: user, passkeys: passkeys}, nil
+}
+
+// startCeremony stores the session data of a new ceremony and returns the
+// handle the client finishes it with. userID is zero for logins.
+func (h *PasskeyHandler) startCeremony(ctx context.Context, purpose string, userID primitive.ObjectID, session *webauthn.SessionData) (string, error) {
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	handle, hash, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	err = h.ceremonies.Create(ctx, &models.PasskeyCeremony{
+		ID:        hash,
+		Purpose:   purpose,
+		UserID:    userID,
+		Session:   raw,
+		ExpiresAt: time.Now().UTC().Add(passkeyCeremonyTTL),
+	})
+	return handle, err
+}
+
+// finishCeremony takes the ceremony a handle refers to and returns its session
+// data and user. The session is nil when the ceremony is unknown, expired or
+// already finished.
+func (h *PasskeyHandler) finishCeremony(ctx context.Context, purpose, handle string) (*webauthn.SessionData, primitive.ObjectID, error) {
+	if handle == "" {
+		return nil, primitive.NilObjectID, nil
+	}
+	cer, err := h.ceremonies.Take(ctx, purpose, tokens.HashToken(handle))
+	if err != nil || cer == nil {
+		return nil, primitive.NilObjectID, err
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal(cer.Session, &session); err != nil {
+		return nil, primitive.NilObjectID, err
+	}
+	return &session, cer.UserID, nil
+}
+
+// BeginRegistration returns creation options for navigator.credentials.create.
+func (h *PasskeyHandler) BeginRegistration(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	u, err := h.loadUser(ctx, userID)
+	if err != nil || u == nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to start registration"})
+	}
+	creation, session, err := h.webauthn.BeginRegistration(u,
+		webauthn.WithExclusions(webauthn.Credentials(u.WebAuthnCredentials()).CredentialDescriptors()))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to start registration"})
+	}
+	ceremony, err := h.startCeremony(ctx, models.PurposePasskeyRegister, userID, session)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to start registration"})
+	}
+	return c.JSON(fiber.Map{"options": creation, "ceremony": ceremony})
+}
+
+// FinishRegistration verifies the authenticator's attestation and stores the
+// new passkey.
+func (h *PasskeyHandler) FinishRegistration(c *fiber.Ctx) error {
+	var req struct {
+		Ceremony   string          `json:"ceremony"`
+		Name       string          `json:"name"`
+		Credential json.RawMessage `json:"credential"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, owner, err := h.finishCeremony(ctx, models.PurposePasskeyRegister, req.Ceremony)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to register passkey"})
+	}
+	if session == nil || owner != userID {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired ceremony"})
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid credential"})
+	}
+
+	u, err := h.loadUser(ctx, userID)
+	if err != nil || u == nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to register passkey"})
+	}
+	cred, err := h.webauthn.CreateCredential(u, *session, parsed)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "passkey verification failed"})
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	p := newPasskey(userID, name, cred)
+	if err := h.passkeys.Create(ctx, p); errors.Is(err, repo.ErrDuplicate) {
+		return c.Status(409).JSON(fiber.Map{"error": "passkey already registered"})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to register passkey"})
+	}
+	return c.Status(201).JSON(p)
+}
+
+// newPasskey is the stored form of a freshly created credential.
+func newPasskey(userID primitive.ObjectID, name string, cred *webauthn.Credential) *models.Passkey {
+	transports := make([]string, len(cred.Transport))
+	for i, t := range cred.Transport {
+		transports[i] = string(t)
+	}
+	return &models.Passkey{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transports:      transports,
+		AAGUID:          cred.Authenticator.AAGUID,
+		Flags:           byte(cred.Flags.ProtocolValue()),
+		SignCount:       cred.Authenticator.SignCount,
+	}
+}
+
+func (h *PasskeyHandler) ListPasskeys(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	items, err := h.passkeys.ListByUser(ctx, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch passkeys"})
+	}
+	return c.JSON(fiber.Map{"passkeys": items})
+}
+
+func (h *PasskeyHandler) DeletePasskey(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = h.passkeys.Delete(ctx, oid, userID)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to delete passkey"})
+	}
+	return c.JSON(fiber.Map{"message": "passkey deleted"})
+}
+
+// BeginLogin returns request options for navigator.credentials.get. No
+// account is named: the authenticator offers its discoverable credentials.
+func (h *PasskeyHandler) BeginLogin(c *fiber.Ctx) error {
+	assertion, session, err := h.webauthn.BeginDiscoverableLogin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to start login"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ceremony, err := h.startCeremony(ctx, models.PurposePasskeyLogin, primitive.NilObjectID, session)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to start login"})
+	}
+	return c.JSON(fiber.Map{"options": assertion, "ceremony": ceremony})
+}
+
+// FinishLogin verifies the assertion and issues the same tokens as a password
+// login. A user-verifying passkey counts as both factors, so TOTP is not asked for.
+func (h *PasskeyHandler) FinishLogin(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.verifyLogin(c, ctx)
+	if user == nil {
+		return err
+	}
+	if h.Auth.Config.EmailVerificationPolicy == config.VerifyLogin && !user.EmailVerified {
+		return c.Status(403).JSON(fiber.Map{"error": "email not verified"})
+	}
+	h.Auth.Audit.Record(ctx, loginEvent(c, models.AuditSuccess, "passkey", user))
+	return h.Auth.issueTokens(c, ctx, user)
+}
+
+// verifyLogin finishes the login ceremony, checks the assertion against the
+// stored passkey and records its new sign count. A nil user means the error
+// response has been written; return the accompanying error.
+func (h *PasskeyHandler) verifyLogin(c *fiber.Ctx, ctx context.Context) (*models.User, error) {
+	var req struct {
+		Ceremony   string          `json:"ceremony"`
+		Credential json.RawMessage `json:"credential"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	session, _, err := h.finishCeremony(ctx, models.PurposePasskeyLogin, req.Ceremony)
+	if err != nil {
+		return nil, c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+	}
+	if session == nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "invalid or expired ceremony"})
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "invalid credential"})
+	}
+
+	var found *passkeyUser
+	lookup := func(rawID, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != len(primitive.ObjectID{}) {
+			return nil, errors.New("unknown user handle")
+		}
+		var id primitive.ObjectID
+		copy(id[:], userHandle)
+		u, err := h.loadUser(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if u == nil || u.passkey(rawID) == nil {
+			return nil, errors.New("unknown credential")
+		}
+		found = u
+		return u, nil
+	}
+	cred, err := h.webauthn.ValidateDiscoverableLogin(lookup, *session, parsed)
+	if err != nil || found == nil {
+		var user *models.User
+		if found != nil {
+			user = found.user
+		}
+		h.Auth.Audit.Record(ctx, loginEvent(c, models.AuditFailure, "passkey", user))
+		return nil, c.Status(401).JSON(fiber.Map{"error": "invalid credentials"})
+	}
+	if cred.Authenticator.CloneWarning {
+		log.Printf("passkey sign count went backwards for user %s, possible cloned authenticator", found.user.ID.Hex())
+		ev := loginEvent(c, models.AuditFailure, "passkey", found.user)
+		ev.Details["reason"] = "clone_warning"
+		h.Auth.Audit.Record(ctx, ev)
+		return nil, c.Status(401).JSON(fiber.Map{"error": "invalid credentials"})
+	}
+
+	p := found.passkey(cred.ID)
+	if err := h.passkeys.RecordLogin(ctx, p.ID, cred.Authenticator.SignCount, byte(cred.Flags.ProtocolValue())); err != nil {
+		return nil, c.Status(500).JSON(fiber.Map{"error": "failed to login"})
+	}
+	return found.user, nil
+}
@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const testOrigin = "http://localhost:8080"
+
+// fakePasskeys is an in-memory passkeyStore.
+type fakePasskeys struct {
+	mu       sync.Mutex
+	passkeys []models.Passkey
+}
+
+func (f *fakePasskeys) Create(ctx context.Context, p *models.Passkey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, q := range f.passkeys {
+		if bytes.Equal(q.CredentialID, p.CredentialID) {
+			return repo.ErrDuplicate
+		}
+	}
+	p.ID = primitive.NewObjectID()
+	p.CreatedAt = time.Now().UTC()
+	f.passkeys = append(f.passkeys, *p)
+	return nil
+}
+
+func (f *fakePasskeys) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.Passkey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := []models.Passkey{}
+	for _, p := range f.passkeys {
+		if p.UserID == userID {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakePasskeys) RecordLogin(ctx context.Context, id primitive.ObjectID, signCount uint32, flags byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.passkeys {
+		if f.passkeys[i].ID == id {
+			f.passkeys[i].SignCount = signCount
+			f.passkeys[i].Flags = flags
+		}
+	}
+	return nil
+}
+
+func (f *fakePasskeys) Delete(ctx context.Context, id, userID primitive.ObjectID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, p := range f.passkeys {
+		if p.ID == id && p.UserID == userID {
+			f.passkeys = append(f.passkeys[:i], f.passkeys[i+1:]...)
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+// fakeCeremonies is an in-memory ceremonyStore.
+type fakeCeremonies struct {
+	mu         sync.Mutex
+	ceremonies map[string]models.PasskeyCeremony
+}
+
+func (f *fakeCeremonies) Create(ctx context.Context, c *models.PasskeyCeremony) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ceremonies == nil {
+		f.ceremonies = map[string]models.PasskeyCeremony{}
+	}
+	f.ceremonies[c.ID] = *c
+	return nil
+}
+
+func (f *fakeCeremonies) Take(ctx context.Context, purpose, id string) (*models.PasskeyCeremony, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.ceremonies[id]
+	if !ok || c.Purpose != purpose || !time.Now().Before(c.ExpiresAt) {
+		return nil, nil
+	}
+	delete(f.ceremonies, id)
+	return &c, nil
+}
+
+// expireAll moves every stored ceremony past its expiry.
+func (f *fakeCeremonies) expireAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, c := range f.ceremonies {
+		c.ExpiresAt = time.Now().Add(-time.Second)
+		f.ceremonies[id] = c
+	}
+}
+
+// virtualAuthenticator is a platform authenticator holding one discoverable
+// ES256 credential. It answers creation and request options the way a browser
+// serializes the resulting PublicKeyCredential.
+type virtualAuthenticator struct {
+	rpID       string
+	origin     string
+	key        *ecdsa.PrivateKey
+	credID     []byte
+	userHandle []byte
+	signCount  uint32
+}
+
+func newVirtualAuthenticator(t *testing.T) *virtualAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credID := make([]byte, 16)
+	rand.Read(credID)
+	return &virtualAuthenticator{rpID: "localhost", origin: testOrigin, key: key, credID: credID}
+}
+
+var b64 = base64.RawURLEncoding.EncodeToString
+
+// ceremonyOptions is the part of a begin response the authenticator reads.
+type ceremonyOptions struct {
+	Ceremony string `json:"ceremony"`
+	Options  struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	} `json:"options"`
+}
+
+func (a *virtualAuthenticator) clientData(typ, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": a.origin})
+	return data
+}
+
+// authData builds authenticator data with the user present and verified
+// flags, plus attested credential data when attested is set.
+func (a *virtualAuthenticator) authData(t *testing.T, attested bool) []byte {
+	t.Helper()
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	var buf bytes.Buffer
+	buf.Write(rpIDHash[:])
+	flags := byte(0x01 | 0x04)
+	if attested {
+		flags |= 0x40
+	}
+	buf.WriteByte(flags)
+	binary.Write(&buf, binary.BigEndian, a.signCount)
+	if attested {
+		pub, err := a.key.PublicKey.ECDH()
+		if err != nil {
+			t.Fatal(err)
+		}
+		point := pub.Bytes()
+		cose, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+			PublicKeyData: webauthncose.PublicKeyData{
+				KeyType:   int64(webauthncose.EllipticKey),
+				Algorithm: int64(webauthncose.AlgES256),
+			},
+			Curve:  1, // P-256
+			XCoord: point[1:33],
+			YCoord: point[33:],
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(make([]byte, 16)) // AAGUID
+		binary.Write(&buf, binary.BigEndian, uint16(len(a.credID)))
+		buf.Write(a.credID)
+		buf.Write(cose)
+	}
+	return buf.Bytes()
+}
+
+// create answers creation options with a "none" attestation.
+func (a *virtualAuthenticator) create(t *testing.T, opts ceremonyOptions) json.RawMessage {
+	t.Helper()
+	handle, err := base64.RawURLEncoding.DecodeString(opts.Options.PublicKey.User.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = handle
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(t, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(a.clientData("webauthn.create", opts.Options.PublicKey.Challenge)),
+			"attestationObject": b64(attestation),
+		},
+	})
+	return cred
+}
+
+// get answers request options with an assertion signed by key, which is the
+// authenticator's own key unless a test swaps it.
+func (a *virtualAuthenticator) get(t *testing.T, opts ceremonyOptions, key *ecdsa.PrivateKey) json.RawMessage {
+	t.Helper()
+	a.signCount++
+	authData := a.authData(t, false)
+	clientData := a.clientData("webauthn.get", opts.Options.PublicKey.Challenge)
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(sig),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+	return cred
+}
+
+type passkeyTest struct {
+	app        *fiber.App
+	handler    *PasskeyHandler
+	passkeys   *fakePasskeys
+	ceremonies *fakeCeremonies
+	audit      *fakeAuditStore
+}
+
+// newPasskeyTest serves the passkey endpoints for the given users. Requests
+// are authenticated as the user whose id is in the X-User header, and a
+// finished login answers with the user's id instead of issuing tokens.
+func newPasskeyTest(t *testing.T, users ...*models.User) *passkeyTest {
+	t.Helper()
+	cfg := &config.Config{AppBaseURL: testOrigin, WebAuthnRPName: "Notes"}
+	auth, store := newTestAuthHandler(t, cfg)
+	h, err := NewPasskeyHandler(auth, nil, nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt := &passkeyTest{handler: h, passkeys: &fakePasskeys{}, ceremonies: &fakeCeremonies{}, audit: store}
+	h.users = &fakeUsers{users: users}
+	h.passkeys = pt.passkeys
+	h.ceremonies = pt.ceremonies
+
+	pt.app = fiber.New()
+	pt.app.Post("/passkeys/register/begin", signedIn, h.BeginRegistration)
+	pt.app.Post("/passkeys/register/finish", signedIn, h.FinishRegistration)
+	pt.app.Post("/login/passkey/begin", h.BeginLogin)
+	pt.app.Post("/login/passkey/finish", func(c *fiber.Ctx) error {
+		user, err := h.verifyLogin(c, c.Context())
+		if user == nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"user_id": user.ID})
+	})
+	return pt
+}
+
+func (pt *passkeyTest) post(t *testing.T, path string, user *models.User, body any) (int, map[string]any) {
+	t.Helper()
+	return sendJSON(t, pt.app, "POST", path, user, body)
+}
+
+// begin starts a ceremony and decodes its options.
+func (pt *passkeyTest) begin(t *testing.T, path string, user *models.User) ceremonyOptions {
+	t.Helper()
+	data, _ := json.Marshal(map[string]any{})
+	req := httptest.NewRequest("POST", path, bytes.NewReader(data))
+	if user != nil {
+		req.Header.Set("X-User", user.ID.Hex())
+	}
+	resp, err := pt.app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("%s: status %d", path, resp.StatusCode)
+	}
+	var opts ceremonyOptions
+	if err := json.NewDecoder(resp.Body).Decode(&opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.Ceremony == "" || opts.Options.PublicKey.Challenge == "" {
+		t.Fatalf("%s: no ceremony or challenge in %+v", path, opts)
+	}
+	return opts
+}
+
+// register runs a whole registration for user with a.
+func (pt *passkeyTest) register(t *testing.T, a *virtualAuthenticator, user *models.User) {
+	t.Helper()
+	opts := pt.begin(t, "/passkeys/register/begin", user)
+	status, body := pt.post(t, "/passkeys/register/finish", user, fiber.Map{
+		"ceremony": opts.Ceremony, "name": "Laptop", "credential": a.create(t, opts),
+	})
+	if status != 201 {
+		t.Fatalf("register status = %d (%v)", status, body)
+	}
+}
+
+func TestPasskeyRegisterAndLogin(t *testing.T) {
+	user := &models.User{ID: primitive.NewObjectID(), Email: "a@example.com", EmailVerified: true}
+	pt := newPasskeyTest(t, user)
+	a := newVirtualAuthenticator(t)
+
+	pt.register(t, a, user)
+	stored, _ := pt.passkeys.ListByUser(context.Background(), user.ID)
+	if len(stored) != 1 || stored[0].Name != "Laptop" || !bytes.Equal(stored[0].CredentialID, a.credID) {
+		t.Fatalf("stored passkeys = %+v", stored)
+	}
+	if !bytes.Equal(a.userHandle, user.ID[:]) {
+		t.Errorf("user handle = %x, want the account id", a.userHandle)
+	}
+
+	for i := 1; i <= 2; i++ {
+		opts := pt.begin(t, "/login/passkey/begin", nil)
+		status, body := pt.post(t, "/login/passkey/finish", nil, fiber.Map{
+			"ceremony": opts.Ceremony, "credential": a.get(t, opts, a.key),
+		})
+		if status != 200 || body["user_id"] != user.ID.Hex() {
+			t.Fatalf("login %d: status = %d (%v)", i, status, body)
+		}
+		stored, _ = pt.passkeys.ListByUser(context.Background(), user.ID)
+		if stored[0].SignCount != uint32(i) {
+			t.Errorf("login %d: sign count = %d", i, stored[0].SignCount)
+		}
+	}
+	if len(pt.ceremonies.ceremonies) != 0 {
+		t.Errorf("%d ceremonies left after finishing them all", len(pt.ceremonies.ceremonies))
+	}
+}
+
+func TestPasskeyReplayRejected(t *testing.T) {
+	user := &models.User{ID: primitive.NewObjectID(), Email: "a@example.com", EmailVerified: true}
+	pt := newPasskeyTest(t, user)
+	a := newVirtualAuthenticator(t)
+
+	t.Run("registration", func(t *testing.T) {
+		opts := pt.begin(t, "/passkeys/register/begin", user)
+		req := fiber.Map{"ceremony": opts.Ceremony, "credential": a.create(t, opts)}
+		if status, body := pt.post(t, "/passkeys/register/finish", user, req); status != 201 {
+			t.Fatalf("first finish: status = %d (%v)", status, body)
+		}
+		status, body := pt.post(t, "/passkeys/register/finish", user, req)
+		if status != 400 || body["error"] != "invalid or expired ceremony" {
+			t.Errorf("replayed finish: status = %d (%v)", status, body)
+		}
+	})
+
+	t.Run("login with the same ceremony", func(t *testing.T) {
+		opts := pt.begin(t, "/login/passkey/begin", nil)
+		req := fiber.Map{"ceremony": opts.Ceremony, "credential": a.get(t, opts, a.key)}
+		if status, body := pt.post(t, "/login/passkey/finish", nil, req); status != 200 {
+			t.Fatalf("first finish: status = %d (%v)", status, body)
+		}
+		status, body := pt.post(t, "/login/passkey/finish", nil, req)
+		if status != 400 || body["error"] != "invalid or expired ceremony" {
+			t.Errorf("replayed finish: status = %d (%v)", status, body)
+		}
+	})
+
+	t.Run("login with a new ceremony", func(t *testing.T) {
+		old := pt.begin(t, "/login/passkey/begin", nil)
+		assertion := a.get(t, old, a.key)
+		if status, _ := pt.post(t, "/login/passkey/finish", nil, fiber.Map{"ceremony": old.Ceremony, "credential": assertion}); status != 200 {
+			t.Fatalf("first finish: status = %d", status)
+		}
+		// the captured assertion answers the old challenge, not the new one
+		fresh := pt.begin(t, "/login/passkey/begin", nil)
+		status, body := pt.post(t, "/login/passkey/finish", nil, fiber.Map{"ceremony": fresh.Ceremony, "credential": assertion})
+		if status != 401 {
+			t.Errorf("replayed assertion: status = %d (%v)", status, body)
+		}
+		if ev := pt.audit.last(); ev == nil || ev.Outcome != models.AuditFailure {
+			t.Errorf("last audit event = %+v, want a failure", ev)
+		}
+	})
+}
+
+func TestPasskeyCeremonyRejected(t *testing.T) {
+	alice := &models.User{ID: primitive.NewObjectID(), Email: "a@example.com", EmailVerified: true}
+	bob := &models.User{ID: primitive.NewObjectID(), Email: "b@example.com", EmailVerified: true}
+
+	t.Run("registration started by another user", func(t *testing.T) {
+		pt := newPasskeyTest(t, alice, bob)
+		a := newVirtualAuthenticator(t)
+		opts := pt.begin(t, "/passkeys/register/begin", alice)
+		status, body := pt.post(t, "/passkeys/register/finish", bob, fiber.Map{"ceremony": opts.Ceremony, "credential": a.create(t, opts)})
+		if status != 400 {
+			t.Errorf("status = %d (%v)", status, body)
+		}
+		if stored, _ := pt.passkeys.ListByUser(context.Background(), bob.ID); len(stored) != 0 {
+			t.Errorf("bob got a passkey: %+v", stored)
+		}
+	})
+
+	t.Run("expired ceremony", func(t *testing.T) {
+		pt := newPasskeyTest(t, alice)
+		a := newVirtualAuthenticator(t)
+		pt.register(t, a, alice)
+		opts := pt.begin(t, "/login/passkey/begin", nil)
+		pt.ceremonies.expireAll()
+		status, body := pt.post(t, "/login/passkey/finish", nil, fiber.Map{"ceremony": opts.Ceremony, "credential": a.get(t, opts, a.key)})
+		if status != 400 {
+			t.Errorf("status = %d (%v)", status, body)
+		}
+	})
+
+	t.Run("login ceremony used for registration", func(t *testing.T) {
+		pt := newPasskeyTest(t, alice)
+		a := newVirtualAuthenticator(t)
+		opts := pt.begin(t, "/login/passkey/begin", nil)
+		opts.Options.PublicKey.User.ID = b64(alice.ID[:])
+		status, body := pt.post(t, "/passkeys/register/finish", alice, fiber.Map{"ceremony": opts.Ceremony, "credential": a.create(t, opts)})
+		if status != 400 {
+			t.Errorf("status = %d (%v)", status, body)
+		}
+	})
+
+	t.Run("signed with another key", func(t *testing.T) {
+		pt := newPasskeyTest(t, alice)
+		a := newVirtualAuthenticator(t)
+		pt.register(t, a, alice)
+		other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		opts := pt.begin(t, "/login/passkey/begin", nil)
+		status, body := pt.post(t, "/login/passkey/finish", nil, fiber.Map{"ceremony": opts.Ceremony, "credential": a.get(t, opts, other)})
+		if status != 401 {
+			t.Errorf("status = %d (%v)", status, body)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/mailer"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+)
+
+// ForgotPassword emails a reset link. It answers the same way whether or not
+// the email is registered so it cannot be used to probe for accounts. Accounts
+// whose password lives in an LDAP directory get no link.
+func (h *AuthHandler) ForgotPassword(c *fiber.Ctx) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	req.Email = models.NormalizeEmail(req.Email)
+	if req.Email == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "email required"})
+	}
+
+	accepted := fiber.Map{"message": "if the email is registered, a reset link has been sent"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.users.FindByEmail(ctx, req.Email)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to process request"})
+	}
+	if user == nil || user.DirectoryManaged {
+		return c.Status(202).JSON(accepted)
+	}
+
+	raw, hash, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to process request"})
+	}
+	t := &models.OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   models.PurposePasswordReset,
+		TokenHash: hash,
+		ExpiresAt: time.Now().UTC().Add(h.Config.PasswordResetTTL),
+	}
+	if err := h.OneTimeTokens.Create(ctx, t); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to process request"})
+	}
+
+	link := h.Config.AppBaseURL + "/reset-password?token=" + url.QueryEscape(raw)
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your account.\n\n"+
+			"Open this link within %s to choose a new password:\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.", h.Config.PasswordResetTTL, link),
+	}
+	// send in the background so response time does not reveal whether the account exists
+	go h.sendMail(msg)
+
+	return c.Status(202).JSON(accepted)
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword and
+// signs the user out everywhere.
+func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	if req.Token == "" || req.Password == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "token and password required"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t, err := h.OneTimeTokens.Consume(ctx, models.PurposePasswordReset, tokens.HashToken(req.Token))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to reset password"})
+	}
+	if t == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+	user, err := h.users.FindByID(ctx, t.UserID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to reset password"})
+	}
+	if user == nil || user.DirectoryManaged {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+
+	hash, err := h.Passwords.Hash(req.Password)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to reset password"})
+	}
+	if err := h.users.UpdatePassword(ctx, t.UserID, hash); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to reset password"})
+	}
+	if err := h.OneTimeTokens.InvalidateUser(ctx, t.UserID, models.PurposePasswordReset); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to reset password"})
+	}
+	if err := h.Tokens.RevokeAll(ctx, t.UserID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to reset password"})
+	}
+	h.Audit.Record(ctx, revocationEvent(c, "password_reset", t.UserID))
+	return c.JSON(fiber.Map{"message": "password updated"})
+}
+
+func (h *AuthHandler) sendMail(msg mailer.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := h.Mailer.Send(ctx, msg); err != nil {
+		log.Printf("failed to send %q mail: %v", msg.Subject, err)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/audit"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sessionLister is the part of repo.SessionRepo the session handlers need.
+type sessionLister interface {
+	ListActive(ctx context.Context, userID primitive.ObjectID) ([]models.Session, error)
+}
+
+type SessionHandler struct {
+	SessionRepo *repo.SessionRepo
+	Tokens      *tokens.Service
+	Audit       *audit.Recorder
+
+	sessions sessionLister
+}
+
+func NewSessionHandler(sessionRepo *repo.SessionRepo, tokenSvc *tokens.Service, rec *audit.Recorder) *SessionHandler {
+	return &SessionHandler{
+		SessionRepo: sessionRepo,
+		Tokens:      tokenSvc,
+		Audit:       rec,
+		sessions:    sessionRepo,
+	}
+}
+
+// ListSessions shows the devices the user is logged in on, flagging the one
+// making the request.
+func (h *SessionHandler) ListSessions(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*tokens.Claims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	items, err := h.sessions.ListActive(ctx, claims.UserID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch sessions"})
+	}
+	type session struct {
+		models.Session
+		Current bool `json:"current"`
+	}
+	out := make([]session, len(items))
+	for i, s := range items {
+		out[i] = session{Session: s, Current: s.ID == claims.SessionID}
+	}
+	return c.JSON(fiber.Map{"sessions": out})
+}
+
+// RevokeSession logs one of the user's devices out.
+func (h *SessionHandler) RevokeSession(c *fiber.Ctx) error {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = h.Tokens.RevokeSession(ctx, userID, oid)
+	if errors.Is(err, tokens.ErrSessionNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to revoke session"})
+	}
+	ev := auditEvent(c, models.AuditSessionRevoke, models.AuditSuccess)
+	ev.TargetType = "session"
+	ev.TargetID = oid.Hex()
+	h.Audit.Record(ctx, ev)
+	return c.JSON(fiber.Map{"message": "session revoked"})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/middleware"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type sessionTest struct {
+	*testAuth
+	app    *fiber.App
+	user   *models.User
+	laptop *tokens.Pair
+	phone  *tokens.Pair
+	other  *tokens.Pair // someone else's login
+}
+
+// newSessionTest serves the session endpoints behind the real authentication
+// middleware, with the user logged in on a laptop and a phone.
+func newSessionTest(t *testing.T) *sessionTest {
+	t.Helper()
+	ta := newTestAuth(t, &config.Config{})
+	h := &SessionHandler{Tokens: ta.Tokens, Audit: ta.Audit, sessions: ta.sessions}
+	st := &sessionTest{testAuth: ta, app: fiber.New(), user: &models.User{ID: primitive.NewObjectID(), Email: "ada@example.com"}}
+	other := &models.User{ID: primitive.NewObjectID(), Email: "bob@example.com"}
+	ta.users.users = []*models.User{st.user, other}
+
+	login := func(u *models.User, device string) *tokens.Pair {
+		pair, err := ta.Tokens.Issue(t.Context(), u, tokens.Client{UserAgent: device, IP: "127.0.0.1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pair
+	}
+	st.laptop = login(st.user, "laptop")
+	st.phone = login(st.user, "phone")
+	st.other = login(other, "laptop")
+
+	auth := middleware.RequireAuth(ta.Tokens)
+	st.app.Get("/sessions", auth, h.ListSessions)
+	st.app.Delete("/sessions/:id", auth, h.RevokeSession)
+	return st
+}
+
+// sessionID is the session a pair was issued for.
+func (st *sessionTest) sessionID(t *testing.T, pair *tokens.Pair) primitive.ObjectID {
+	t.Helper()
+	claims, err := st.Tokens.Parse(t.Context(), pair.AccessToken, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims.SessionID
+}
+
+func (st *sessionTest) do(t *testing.T, method, path string, pair *tokens.Pair) (int, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+pair.AccessToken)
+	resp, err := st.app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body json.RawMessage
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+type listedSession struct {
+	ID        primitive.ObjectID `json:"id"`
+	UserAgent string             `json:"user_agent"`
+	Current   bool               `json:"current"`
+}
+
+func (st *sessionTest) list(t *testing.T, pair *tokens.Pair) []listedSession {
+	t.Helper()
+	status, body := st.do(t, "GET", "/sessions", pair)
+	if status != 200 {
+		t.Fatalf("GET /sessions status = %d: %s", status, body)
+	}
+	var out struct {
+		Sessions []listedSession `json:"sessions"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out.Sessions
+}
+
+func TestListSessions(t *testing.T) {
+	st := newSessionTest(t)
+	current := map[string]bool{}
+	for _, s := range st.list(t, st.phone) {
+		current[s.UserAgent] = s.Current
+	}
+	if want := map[string]bool{"laptop": false, "phone": true}; len(current) != len(want) || current["laptop"] || !current["phone"] {
+		t.Errorf("sessions (user agent: current) = %v, want %v", current, want)
+	}
+
+	if err := st.Tokens.RevokeSession(t.Context(), st.user.ID, st.sessionID(t, st.laptop)); err != nil {
+		t.Fatal(err)
+	}
+	if got := st.list(t, st.phone); len(got) != 1 || got[0].UserAgent != "phone" {
+		t.Errorf("sessions after logging the laptop out = %+v, want only the phone", got)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     func(t *testing.T, st *sessionTest) string
+		wantStatus int
+		// which of the user's logins still work afterwards
+		laptopLive, phoneLive bool
+	}{
+		{"another device", func(t *testing.T, st *sessionTest) string { return st.sessionID(t, st.phone).Hex() }, 200, true, false},
+		{"the current session", func(t *testing.T, st *sessionTest) string { return st.sessionID(t, st.laptop).Hex() }, 200, false, true},
+		{"someone else's", func(t *testing.T, st *sessionTest) string { return st.sessionID(t, st.other).Hex() }, 404, true, true},
+		{"unknown", func(*testing.T, *sessionTest) string { return primitive.NewObjectID().Hex() }, 404, true, true},
+		{"invalid id", func(*testing.T, *sessionTest) string { return "nope" }, 400, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newSessionTest(t)
+			target := tt.target(t, st)
+
+			status, body := st.do(t, "DELETE", "/sessions/"+target, st.laptop)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", status, tt.wantStatus, body)
+			}
+			if !tt.laptopLive {
+				if status, _ := st.do(t, "GET", "/sessions", st.laptop); status != 401 {
+					t.Errorf("request from the revoked session: status = %d, want 401", status)
+				}
+			}
+			for _, login := range []struct {
+				name string
+				pair *tokens.Pair
+				live bool
+			}{{"laptop", st.laptop, tt.laptopLive}, {"phone", st.phone, tt.phoneLive}, {"someone else's", st.other, true}} {
+				if _, err := st.Tokens.Parse(t.Context(), login.pair.AccessToken, "127.0.0.1"); (err == nil) != login.live {
+					t.Errorf("%s access token: err = %v, want live = %v", login.name, err, login.live)
+				}
+				if _, err := st.Tokens.Refresh(t.Context(), login.pair.RefreshToken); (err == nil) != login.live {
+					t.Errorf("%s refresh token: err = %v, want live = %v", login.name, err, login.live)
+				}
+			}
+
+			ev := st.audit.last()
+			if status != 200 {
+				if ev != nil {
+					t.Errorf("recorded %s for status %d", ev.Action, status)
+				}
+				return
+			}
+			if ev == nil || ev.Action != models.AuditSessionRevoke || ev.TargetType != "session" || ev.TargetID != target {
+				t.Errorf("audit event = %+v, want %s of session %s", ev, models.AuditSessionRevoke, target)
+			}
+		})
+	}
+
+	t.Run("twice", func(t *testing.T) {
+		st := newSessionTest(t)
+		target := st.sessionID(t, st.phone).Hex()
+		if status, body := st.do(t, "DELETE", "/sessions/"+target, st.laptop); status != 200 {
+			t.Fatalf("first revoke status = %d: %s", status, body)
+		}
+		if status, _ := st.do(t, "DELETE", "/sessions/"+target, st.laptop); status != 404 {
+			t.Errorf("second revoke status = %d, want 404", status)
+		}
+	})
+}
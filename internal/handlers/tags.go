@@ -7,6 +7,8 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type TagHandler struct {
@@ -27,10 +29,26 @@ func (h *TagHandler) TopTags(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
-	// convert to friendly JSON
+	return c.JSON(tagCounts(arr))
+}
+
+// MyTags lists the tags on the caller's notes, with how many notes use each.
+func (h *TagHandler) MyTags(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(primitive.ObjectID)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	arr, err := h.TagRepo.UserTags(ctx, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to list tags"})
+	}
+	return c.JSON(tagCounts(arr))
+}
+
+// tagCounts converts aggregation results to friendly JSON.
+func tagCounts(arr []bson.M) []fiber.Map {
 	out := make([]fiber.Map, 0, len(arr))
 	for _, a := range arr {
 		out = append(out, fiber.Map{"tag": a["_id"], "count": a["count"]})
 	}
-	return c.JSON(out)
+	return out
 }
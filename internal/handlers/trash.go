@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/authz"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/middleware"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ListTrash lists the caller's deleted notes, most recently deleted first.
+func (h *NoteHandler) ListTrash(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(primitive.ObjectID)
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	items, err := h.NoteRepo.ListTrash(ctx, userID, page, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch trash"})
+	}
+	return c.JSON(fiber.Map{"notes": items, "retention": h.Config.TrashRetention.String()})
+}
+
+// RestoreNote takes a note out of the trash.
+func (h *NoteHandler) RestoreNote(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := h.trashedNote(c, ctx)
+	if n == nil {
+		return err
+	}
+	restored, err := h.NoteRepo.Restore(ctx, n.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to restore note"})
+	}
+	if restored == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	h.Audit.Record(ctx, noteEvent(c, models.AuditNoteRestore, restored))
+	return c.JSON(restored)
+}
+
+// PurgeNote deletes one note from the trash for good.
+func (h *NoteHandler) PurgeNote(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := h.trashedNote(c, ctx)
+	if n == nil {
+		return err
+	}
+	err = h.NoteRepo.Delete(ctx, n.ID)
+	if err == mongo.ErrNoDocuments {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to delete note"})
+	}
+	h.purged(c, ctx, n)
+	return c.JSON(fiber.Map{"message": "note deleted permanently"})
+}
+
+// EmptyTrash deletes all of the caller's trashed notes for good.
+func (h *NoteHandler) EmptyTrash(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(primitive.ObjectID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ids, err := h.NoteRepo.EmptyTrash(ctx, userID)
+	for _, id := range ids {
+		h.purged(c, ctx, &models.Note{ID: id, UserID: userID})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to empty trash"})
+	}
+	return c.JSON(fiber.Map{"message": "trash emptied", "deleted": len(ids)})
+}
+
+// purged cleans up after a note was deleted permanently.
+func (h *NoteHandler) purged(c *fiber.Ctx, ctx context.Context, n *models.Note) {
+	if err := h.Revisions.DeleteByNotes(ctx, []primitive.ObjectID{n.ID}); err != nil {
+		log.Printf("failed to delete revisions of note %s: %v", n.ID.Hex(), err)
+	}
+	h.Audit.Record(ctx, noteEvent(c, models.AuditNotePurge, n))
+}
+
+// trashedNote loads the trashed note named by the id param and checks that
+// the caller may restore or purge it. A nil note means the error response has been
+// written; return the accompanying error.
+func (h *NoteHandler) trashedNote(c *fiber.Ctx, ctx context.Context) (*models.Note, error) {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "invalid id"})
+	}
+	n, err := h.NoteRepo.FindTrashed(ctx, oid)
+	if err != nil {
+		return nil, c.Status(500).JSON(fiber.Map{"error": "failed to fetch note"})
+	}
+	if n == nil {
+		return nil, c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if !h.Policy.Can(middleware.Subject(c), authz.TrashManage, n) {
+		return nil, c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+	}
+	return n, nil
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+)
+
+// UserHandler serves public profiles, addressed by username.
+type UserHandler struct {
+	UserRepo *repo.UserRepo
+	NoteRepo *repo.NoteRepo
+}
+
+func NewUserHandler(userRepo *repo.UserRepo, noteRepo *repo.NoteRepo) *UserHandler {
+	return &UserHandler{
+		UserRepo: userRepo,
+		NoteRepo: noteRepo,
+	}
+}
+
+// publicProfile is the part of an account anyone may see.
+func publicProfile(u *models.User) fiber.Map {
+	return fiber.Map{
+		"username":     u.Username,
+		"display_name": u.DisplayName,
+		"bio":          u.Bio,
+		"avatar_url":   u.AvatarURL,
+		"created_at":   u.CreatedAt,
+	}
+}
+
+func (h *UserHandler) findUser(c *fiber.Ctx, ctx context.Context) (*models.User, error) {
+	name := c.Params("username")
+	if !models.ValidUsername(name) {
+		return nil, nil
+	}
+	return h.UserRepo.FindByUsername(ctx, name)
+}
+
+func (h *UserHandler) GetProfile(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.findUser(c, ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch profile"})
+	}
+	if user == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(publicProfile(user))
+}
+
+// GetUserNotes lists a user's public notes, newest first.
+func (h *UserHandler) GetUserNotes(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "20"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.findUser(c, ctx)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch notes"})
+	}
+	if user == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	items, err := h.NoteRepo.ListPublicByUser(ctx, user.ID, page, limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to fetch notes"})
+	}
+	return c.JSON(fiber.Map{"user": publicProfile(user), "notes": items})
+}
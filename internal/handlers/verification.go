@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/mailer"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// VerifyEmail confirms the address from a link sent by sendVerification.
+func (h *AuthHandler) VerifyEmail(c *fiber.Ctx) error {
+	claims, err := h.Tokens.ParsePurpose(models.PurposeEmailVerification, c.Query("token"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+	uidStr, _ := claims["user_id"].(string)
+	email, _ := claims["email"].(string)
+	uid, err := primitive.ObjectIDFromHex(uidStr)
+	if err != nil || email == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ok, err := h.users.MarkEmailVerified(ctx, uid, email)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to verify email"})
+	}
+	if !ok {
+		// the account's email changed since the link was sent
+		return c.Status(400).JSON(fiber.Map{"error": "invalid or expired token"})
+	}
+	return c.JSON(fiber.Map{"message": "email verified"})
+}
+
+// ResendVerification sends a fresh verification link. Like ForgotPassword it
+// does not reveal whether the email is registered.
+func (h *AuthHandler) ResendVerification(c *fiber.Ctx) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid input"})
+	}
+	req.Email = models.NormalizeEmail(req.Email)
+	if req.Email == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "email required"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.users.FindByEmail(ctx, req.Email)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "failed to process request"})
+	}
+	if user != nil && !user.EmailVerified {
+		if err := h.sendVerification(user); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "failed to process request"})
+		}
+	}
+	return c.Status(202).JSON(fiber.Map{"message": "if the email is registered and unverified, a verification link has been sent"})
+}
+
+// sendVerification mails a signed link bound to the user's current email.
+func (h *AuthHandler) sendVerification(user *models.User) error {
+	token, err := h.Tokens.SignPurpose(models.PurposeEmailVerification, jwt.MapClaims{
+		"user_id": user.ID,
+		"email":   user.Email,
+	}, h.Config.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := h.Config.AppBaseURL + "/api/verify-email?token=" + url.QueryEscape(token)
+	go h.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Welcome! Please confirm this is your email address by opening the link below within %s:\n%s\n\n"+
+			"If you did not create an account, you can ignore this email.", h.Config.EmailVerificationTTL, link),
+	})
+	return nil
+}
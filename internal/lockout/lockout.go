@@ -0,0 +1,122 @@
+// Package lockout slows down password guessing by tracking failed logins per
+// account and per client address and locking them out with exponential backoff.
+package lockout
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+)
+
+// Store persists the failure counters, as repo.LoginThrottleRepo does.
+type Store interface {
+	Find(ctx context.Context, keys ...string) ([]models.LoginThrottle, error)
+	RecordFailure(ctx context.Context, key string, window time.Duration) (*models.LoginThrottle, error)
+	Lock(ctx context.Context, key string, until, expiresAt time.Time) error
+	Clear(ctx context.Context, keys ...string) (int64, error)
+}
+
+// Guard decides whether a login attempt may proceed.
+type Guard struct {
+	repo       Store
+	maxAccount int
+	maxIP      int
+	base       time.Duration
+	max        time.Duration
+	window     time.Duration
+}
+
+func NewGuard(throttles Store, cfg *config.Config) *Guard {
+	return &Guard{
+		repo:       throttles,
+		maxAccount: cfg.LoginMaxAttempts,
+		maxIP:      cfg.LoginMaxAttemptsPerIP,
+		base:       cfg.LoginLockoutBase,
+		max:        cfg.LoginLockoutMax,
+		window:     cfg.LoginFailureWindow,
+	}
+}
+
+// AccountKey and IPKey build the keys failures are counted under. Accounts are
+// keyed by the submitted email, whether or not it exists, so lockouts do not
+// reveal which emails are registered.
+func AccountKey(email string) string { return "acct:" + strings.ToLower(strings.TrimSpace(email)) }
+func IPKey(ip string) string         { return "ip:" + ip }
+
+// Check returns how long the caller must wait before trying again, or zero if
+// neither the account nor the address is locked.
+func (g *Guard) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	entries, err := g.repo.Find(ctx, AccountKey(email), IPKey(ip))
+	if err != nil {
+		return 0, err
+	}
+	var wait time.Duration
+	now := time.Now()
+	for _, e := range entries {
+		if d := e.LockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// Fail records a failed attempt and locks whichever key crossed its threshold.
+func (g *Guard) Fail(ctx context.Context, email, ip string) error {
+	if err := g.fail(ctx, AccountKey(email), g.maxAccount); err != nil {
+		return err
+	}
+	return g.fail(ctx, IPKey(ip), g.maxIP)
+}
+
+func (g *Guard) fail(ctx context.Context, key string, threshold int) error {
+	t, err := g.repo.RecordFailure(ctx, key, g.window)
+	if err != nil {
+		return err
+	}
+	if t.Failures < threshold {
+		return nil
+	}
+	d := g.backoff(t.Failures - threshold)
+	until := time.Now().UTC().Add(d)
+	return g.repo.Lock(ctx, key, until, until.Add(g.window))
+}
+
+// backoff doubles the lockout for every failure past the threshold.
+func (g *Guard) backoff(over int) time.Duration {
+	d := g.base
+	for i := 0; i < over && d < g.max; i++ {
+		d *= 2
+	}
+	return min(d, g.max)
+}
+
+// Succeed clears the account's failures. The address keeps its count so one
+// valid account cannot be used to reset guessing against others.
+func (g *Guard) Succeed(ctx context.Context, email string) error {
+	_, err := g.repo.Clear(ctx, AccountKey(email))
+	return err
+}
+
+// EraseUser forgets the failures counted against a deleted user's email.
+func (g *Guard) EraseUser(ctx context.Context, user *models.User) (int64, error) {
+	return g.repo.Clear(ctx, AccountKey(user.Email))
+}
+
+// Unlock clears lockouts for an account and/or address, returning how many
+// entries were removed.
+func (g *Guard) Unlock(ctx context.Context, email, ip string) (int64, error) {
+	var keys []string
+	if email != "" {
+		keys = append(keys, AccountKey(email))
+	}
+	if ip != "" {
+		keys = append(keys, IPKey(ip))
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	return g.repo.Clear(ctx, keys...)
+}
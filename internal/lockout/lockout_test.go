@@ -0,0 +1,150 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+)
+
+// memoryStore is a Store that ignores entry expiry.
+type memoryStore map[string]*models.LoginThrottle
+
+func (m memoryStore) Find(ctx context.Context, keys ...string) ([]models.LoginThrottle, error) {
+	var out []models.LoginThrottle
+	for _, k := range keys {
+		if t, ok := m[k]; ok {
+			out = append(out, *t)
+		}
+	}
+	return out, nil
+}
+
+func (m memoryStore) RecordFailure(ctx context.Context, key string, window time.Duration) (*models.LoginThrottle, error) {
+	t, ok := m[key]
+	if !ok {
+		t = &models.LoginThrottle{Key: key}
+		m[key] = t
+	}
+	t.Failures++
+	return t, nil
+}
+
+func (m memoryStore) Lock(ctx context.Context, key string, until, expiresAt time.Time) error {
+	m[key].LockedUntil = until
+	return nil
+}
+
+func (m memoryStore) Clear(ctx context.Context, keys ...string) (int64, error) {
+	var n int64
+	for _, k := range keys {
+		if _, ok := m[k]; ok {
+			delete(m, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func newTestGuard() (*Guard, memoryStore) {
+	store := memoryStore{}
+	return NewGuard(store, &config.Config{
+		LoginMaxAttempts:      3,
+		LoginMaxAttemptsPerIP: 5,
+		LoginLockoutBase:      time.Minute,
+		LoginLockoutMax:       10 * time.Minute,
+		LoginFailureWindow:    time.Hour,
+	}), store
+}
+
+func TestBackoff(t *testing.T) {
+	g, _ := newTestGuard()
+	tests := []struct {
+		over int
+		want time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{3, 8 * time.Minute},
+		{4, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := g.backoff(tt.over); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.over, got, tt.want)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		fails      []string // emails failing from 10.0.0.1, in order
+		check      string
+		checkIP    string
+		wantLocked bool
+	}{
+		{"below threshold", []string{"a@x.com", "a@x.com"}, "a@x.com", "10.0.0.2", false},
+		{"account threshold", []string{"a@x.com", "a@x.com", "a@x.com"}, "a@x.com", "10.0.0.2", true},
+		{"keys ignore case and space", []string{"a@x.com", "A@x.com", " a@x.com"}, "a@X.com", "10.0.0.2", true},
+		{"other account is free", []string{"a@x.com", "a@x.com", "a@x.com"}, "b@x.com", "10.0.0.2", false},
+		{"address threshold", []string{"a@x.com", "b@x.com", "c@x.com", "d@x.com", "e@x.com"}, "f@x.com", "10.0.0.1", true},
+		{"other address is free", []string{"a@x.com", "b@x.com", "c@x.com", "d@x.com", "e@x.com"}, "f@x.com", "10.0.0.2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, _ := newTestGuard()
+			for _, email := range tt.fails {
+				if err := g.Fail(ctx, email, "10.0.0.1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			wait, err := g.Check(ctx, tt.check, tt.checkIP)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (wait > 0) != tt.wantLocked {
+				t.Errorf("Check() = %v, want locked: %v", wait, tt.wantLocked)
+			}
+		})
+	}
+}
+
+func TestGuardSucceedKeepsAddressCount(t *testing.T) {
+	ctx := context.Background()
+	g, store := newTestGuard()
+	for i := 0; i < 3; i++ {
+		g.Fail(ctx, "a@x.com", "10.0.0.1")
+	}
+	if err := g.Succeed(ctx, "a@x.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store[AccountKey("a@x.com")]; ok {
+		t.Error("Succeed kept the account's failures")
+	}
+	if got := store[IPKey("10.0.0.1")]; got == nil || got.Failures != 3 {
+		t.Errorf("address entry = %+v, want 3 failures", got)
+	}
+}
+
+func TestGuardUnlock(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestGuard()
+	for i := 0; i < 5; i++ {
+		g.Fail(ctx, "a@x.com", "10.0.0.1")
+	}
+	n, err := g.Unlock(ctx, "a@x.com", "10.0.0.1")
+	if err != nil || n != 2 {
+		t.Fatalf("Unlock() = %d, %v, want 2 entries", n, err)
+	}
+	if wait, _ := g.Check(ctx, "a@x.com", "10.0.0.1"); wait != 0 {
+		t.Errorf("still locked for %v", wait)
+	}
+	if n, _ := g.Unlock(ctx, "", ""); n != 0 {
+		t.Errorf("Unlock of nothing removed %d entries", n)
+	}
+}
@@ -0,0 +1,180 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		driver  string
+		want    string
+		wantErr bool
+	}{
+		{"", "*mailer.LogMailer", false},
+		{"log", "*mailer.LogMailer", false},
+		{"memory", "*mailer.MemoryMailer", false},
+		{"smtp", "*mailer.SMTPMailer", false},
+		{"carrier-pigeon", "", true},
+	}
+	for _, tt := range tests {
+		m, err := New(&config.Config{MailDriver: tt.driver, SMTPHost: "localhost", SMTPPort: "25"})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("New(%q) succeeded, want error", tt.driver)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("New(%q): %v", tt.driver, err)
+			continue
+		}
+		if got := fmt.Sprintf("%T", m); got != tt.want {
+			t.Errorf("New(%q) = %s, want %s", tt.driver, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryMailer()
+	if _, ok := m.Last("a@example.com"); ok {
+		t.Fatal("Last found a message in an empty mailer")
+	}
+
+	m.Send(ctx, Message{To: "a@example.com", Subject: "first"})
+	m.Send(ctx, Message{To: "b@example.com", Subject: "other"})
+	m.Send(ctx, Message{To: "a@example.com", Subject: "second"})
+
+	if got := len(m.Messages()); got != 3 {
+		t.Fatalf("Messages() has %d messages, want 3", got)
+	}
+	msg, ok := m.Last("a@example.com")
+	if !ok || msg.Subject != "second" {
+		t.Errorf("Last() = %+v, %v, want the second message", msg, ok)
+	}
+
+	// callers get a copy they cannot use to alter the mailbox
+	msgs := m.Messages()
+	msgs[0].Subject = "changed"
+	if m.Messages()[0].Subject != "first" {
+		t.Error("Messages() exposes the mailer's own slice")
+	}
+}
+
+func TestLogMailerAppendsToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m := NewLogMailer(path)
+	for _, subject := range []string{"one", "two"} {
+		if err := m.Send(context.Background(), Message{To: "a@example.com", Subject: subject, Body: "link: https://example.com/x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, want := range []string{"To: a@example.com", "Subject: one", "Subject: two", "link: https://example.com/x"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log file lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go serveSMTP(ln, received)
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	m := NewSMTPMailer(host, port, "", "", "noreply@example.com")
+	err = m.Send(context.Background(), Message{To: "a@example.com", Subject: "Reset", Body: "line one\nline two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := <-received
+	for _, want := range []string{
+		"MAIL FROM:<noreply@example.com>",
+		"RCPT TO:<a@example.com>",
+		"From: noreply@example.com\r\n",
+		"To: a@example.com\r\n",
+		"Subject: Reset\r\n",
+		"line one\r\nline two",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("SMTP session lacks %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	m := NewSMTPMailer("127.0.0.1", "1", "", "", "noreply@example.com")
+	for _, msg := range []Message{
+		{To: "a@example.com\r\nBcc: b@example.com", Subject: "x"},
+		{To: "a@example.com", Subject: "x\nBcc: b@example.com"},
+	} {
+		if err := m.Send(context.Background(), msg); err == nil {
+			t.Errorf("Send(%q, %q) succeeded, want error", msg.To, msg.Subject)
+		}
+	}
+}
+
+// serveSMTP accepts one connection and plays a minimal SMTP server without
+// extensions, sending the whole transcript it read to received.
+func serveSMTP(ln net.Listener, received chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var transcript strings.Builder
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	inData := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		transcript.WriteString(line)
+		if inData {
+			if line == ".\r\n" {
+				inData = false
+				reply("250 OK")
+			}
+			continue
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case cmd == "DATA":
+			inData = true
+			reply("354 go ahead")
+		case cmd == "QUIT":
+			reply("221 bye")
+			received <- transcript.String()
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+	received <- transcript.String()
+}
@@ -1,17 +1,20 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
-	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/authz"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
 )
 
 // RequireAuth verifies Bearer token and sets "user_id" local (primitive.ObjectID)
-func RequireAuth(cfg *config.Config) fiber.Handler {
+// and "claims" local (*tokens.Claims). Revoked tokens and tokens of revoked
+// sessions are rejected.
+func RequireAuth(tokenSvc *tokens.Service) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		auth := c.Get("Authorization")
 		if auth == "" {
@@ -22,32 +25,56 @@ func RequireAuth(cfg *config.Config) fiber.Handler {
 			return c.Status(401).JSON(fiber.Map{"error": "invalid authorization header"})
 		}
 		tokenStr := parts[1]
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			return []byte(cfg.JWTSecret), nil
-		})
-		if err != nil || !token.Valid {
-			return c.Status(401).JSON(fiber.Map{"error": "invalid token"})
-		}
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return c.Status(401).JSON(fiber.Map{"error": "invalid token claims"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		claims, err := tokenSvc.Parse(ctx, tokenStr, c.IP())
+		switch {
+		case errors.Is(err, tokens.ErrInvalidToken), errors.Is(err, tokens.ErrTokenRevoked):
+			return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": "failed to verify token"})
 		}
-		uidStr, ok := claims["user_id"].(string)
+		// set user id to locals for handlers
+		c.Locals("user_id", claims.UserID)
+		c.Locals("claims", claims)
+		return c.Next()
+	}
+}
+
+// RequireScope must run after RequireAuth. It rejects personal access tokens
+// that were not granted scope.
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("claims").(*tokens.Claims)
 		if !ok {
-			return c.Status(401).JSON(fiber.Map{"error": "invalid user id in token"})
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
 		}
-		oid, err := primitive.ObjectIDFromHex(uidStr)
-		if err != nil {
-			return c.Status(401).JSON(fiber.Map{"error": "invalid user id"})
+		if !claims.HasScope(scope) {
+			return c.Status(403).JSON(fiber.Map{"error": "token lacks scope " + scope})
 		}
-		// optional: check exp
-		if exp, ok := claims["exp"].(float64); ok {
-			if time.Unix(int64(exp), 0).Before(time.Now()) {
-				return c.Status(401).JSON(fiber.Map{"error": "token expired"})
-			}
+		return c.Next()
+	}
+}
+
+// Subject describes the caller for authorization checks. Requests that did not
+// pass RequireAuth yield the anonymous subject.
+func Subject(c *fiber.Ctx) authz.Subject {
+	claims, ok := c.Locals("claims").(*tokens.Claims)
+	if !ok {
+		return authz.Subject{}
+	}
+	return authz.Subject{UserID: claims.UserID, Role: claims.Role}
+}
+
+// Authorize must run after RequireAuth. It admits callers the policy allows to
+// perform action regardless of any particular resource, e.g. admin actions.
+func Authorize(policy *authz.Policy, action authz.Action) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !policy.Can(Subject(c), action, nil) {
+			return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
 		}
-		// set user id to locals for handlers
-		c.Locals("user_id", oid)
 		return c.Next()
 	}
 }
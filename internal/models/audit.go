@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audited actions.
+const (
+	AuditLogin          = "auth.login"
+	AuditLogout         = "auth.logout"
+	AuditLogoutAll      = "auth.logout_all"
+	AuditIdentityLink   = "auth.identity_link"
+	AuditRevocation     = "auth.revocation"
+	AuditSessionRevoke  = "session.revoke"
+	AuditTokenCreate    = "access_token.create"
+	AuditTokenRevoke    = "access_token.revoke"
+	AuditRoleChange     = "user.role_change"
+	AuditNoteCreate     = "note.create"
+	AuditNoteUpdate     = "note.update"
+	AuditNoteDelete     = "note.delete"
+	AuditNoteVisibility = "note.visibility"
+	AuditNoteRestore    = "note.restore"
+	AuditNotePurge      = "note.purge"
+)
+
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEvent records who did what to which object, from where. Events are
+// only ever inserted and outlive the accounts they mention, but the email, IP
+// and user agent are erased when the account is deleted.
+type AuditEvent struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Time       time.Time           `bson:"time" json:"time"`
+	Action     string              `bson:"action" json:"action"`
+	Outcome    string              `bson:"outcome" json:"outcome"`
+	ActorID    *primitive.ObjectID `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	Email      string              `bson:"email,omitempty" json:"email,omitempty"`
+	TargetType string              `bson:"target_type,omitempty" json:"target_type,omitempty"`
+	TargetID   string              `bson:"target_id,omitempty" json:"target_id,omitempty"`
+	IP         string              `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent  string              `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	Details    map[string]string   `bson:"details,omitempty" json:"details,omitempty"`
+}
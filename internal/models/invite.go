@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invite lets people register while registration is invite-only. Only the
+// hash of the code is stored; Prefix keeps the first characters for display.
+type Invite struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CreatedBy primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CodeHash  string             `bson:"code_hash" json:"-"`
+	Prefix    string             `bson:"prefix" json:"prefix"`
+	MaxUses   int                `bson:"max_uses" json:"max_uses"`
+	Uses      int                `bson:"uses" json:"uses"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	RevokedAt *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
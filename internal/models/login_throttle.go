@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// LoginThrottle counts recent failed logins for one key, which is either an
+// account ("acct:<email>") or a client address ("ip:<addr>").
+type LoginThrottle struct {
+	Key           string    `bson:"_id" json:"key"`
+	Failures      int       `bson:"failures" json:"failures"`
+	LockedUntil   time.Time `bson:"locked_until,omitempty" json:"locked_until,omitempty"`
+	LastFailureAt time.Time `bson:"last_failure_at" json:"last_failure_at"`
+	// ExpiresAt lets the counter lapse after a quiet period.
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+}
@@ -7,12 +7,32 @@ import (
 )
 
 type Note struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID   primitive.ObjectID `bson:"user_id,omitempty" json:"user_id"`
+	Title    string             `bson:"title" json:"title"`
+	Content  string             `bson:"content" json:"content"`
+	IsPublic bool               `bson:"is_public" json:"is_public"`
+	Tags     []string           `bson:"tags" json:"tags"`
+	// Version starts at 1 and is bumped by every update. It numbers the note's
+	// revisions and is its ETag; notes from before versioning read as 0.
+	Version   int       `bson:"version" json:"version"`
+	CreatedAt time.Time `bson:"created_at,omitempty" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at,omitempty" json:"updated_at"`
+	// DeletedAt is set while the note is in the trash.
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+}
+
+// NoteRevision is an immutable copy of a note as it was at one version. Notes
+// from before revisions existed start at version 0.
+type NoteRevision struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID    primitive.ObjectID `bson:"user_id,omitempty" json:"user_id"`
+	NoteID    primitive.ObjectID `bson:"note_id" json:"note_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Version   int                `bson:"version" json:"version"`
 	Title     string             `bson:"title" json:"title"`
-	Content   string             `bson:"content" json:"content"`
+	Content   string             `bson:"content" json:"content,omitempty"`
 	IsPublic  bool               `bson:"is_public" json:"is_public"`
 	Tags      []string           `bson:"tags" json:"tags"`
-	CreatedAt time.Time          `bson:"created_at,omitempty" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at,omitempty" json:"updated_at"`
+	EditedBy  primitive.ObjectID `bson:"edited_by" json:"edited_by"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
 }
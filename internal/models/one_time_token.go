@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Purposes of one-time tokens and signed purpose tokens. Each flow has its
+// own, so a token issued for one can never be redeemed by another.
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeMagicLink         = "magic_link"
+	PurposeMFAChallenge      = "mfa_challenge"
+	PurposeEmailVerification = "email_verification"
+	PurposeEmailChange       = "email_change"
+	PurposePasskeyRegister   = "passkey_register"
+	PurposePasskeyLogin      = "passkey_login"
+	PurposeOIDCFlow          = "oidc_flow"
+	// PurposeIdentityLink tokens name an identity, from OIDC or the
+	// directory, that the account owner has to confirm linking.
+	PurposeIdentityLink = "identity_link"
+)
+
+// OneTimeToken is a hashed, single-use, expiring secret sent to a user out of
+// band, e.g. in a password reset email.
+type OneTimeToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Purpose   string             `bson:"purpose" json:"purpose"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
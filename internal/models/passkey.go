@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Passkey is a WebAuthn credential registered to a user.
+type Passkey struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID          primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Name            string             `bson:"name" json:"name"`
+	CredentialID    []byte             `bson:"credential_id" json:"-"`
+	PublicKey       []byte             `bson:"public_key" json:"-"`
+	AttestationType string             `bson:"attestation_type" json:"-"`
+	Transports      []string           `bson:"transports,omitempty" json:"transports,omitempty"`
+	AAGUID          []byte             `bson:"aaguid,omitempty" json:"-"`
+	// Flags are the authenticator data flags seen at registration, with the
+	// backup state kept current on each login.
+	Flags      byte       `bson:"flags" json:"-"`
+	SignCount  uint32     `bson:"sign_count" json:"-"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	LastUsedAt *time.Time `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+}
+
+// PasskeyCeremony keeps the WebAuthn session data of a registration or login
+// between its begin and finish requests. The client only holds a random
+// handle, stored hashed like other opaque tokens, and a ceremony can be
+// finished once.
+type PasskeyCeremony struct {
+	ID        string             `bson:"_id"`
+	Purpose   string             `bson:"purpose"`
+	UserID    primitive.ObjectID `bson:"user_id,omitempty"`
+	Session   []byte             `bson:"session"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
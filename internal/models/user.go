@@ -1,15 +1,106 @@
 package models
 
 import (
+	"regexp"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Role is a user's coarse permission level; see the authz package for what
+// each role may do.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	return r == RoleUser || r == RoleModerator || r == RoleAdmin
+}
+
 type User struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Username  string             `bson:"username,omitempty" json:"username" `
 	Email     string             `bson:"email,omitempty" json:"email"`
 	Password  string             `bson:"password,omitempty" json:"-"`
 	CreatedAt time.Time          `bson:"created_at,omitempty" json:"created_at"`
+	Role      Role               `bson:"role,omitempty" json:"role"`
+
+	// UsernameKey is the case-folded username, unique across accounts.
+	UsernameKey string `bson:"username_key,omitempty" json:"-"`
+
+	DisplayName string `bson:"display_name,omitempty" json:"display_name,omitempty"`
+	Bio         string `bson:"bio,omitempty" json:"bio,omitempty"`
+	AvatarURL   string `bson:"avatar_url,omitempty" json:"avatar_url,omitempty"`
+
+	EmailVerified   bool       `bson:"email_verified" json:"email_verified"`
+	EmailVerifiedAt *time.Time `bson:"email_verified_at,omitempty" json:"email_verified_at,omitempty"`
+
+	// TOTPSecret is set at enrollment but only enforced once TOTPEnabled.
+	TOTPSecret   string `bson:"totp_secret,omitempty" json:"-"`
+	TOTPEnabled  bool   `bson:"totp_enabled" json:"totp_enabled"`
+	TOTPLastStep int64  `bson:"totp_last_step,omitempty" json:"-"`
+	// RecoveryCodes holds hashes of the unused recovery codes.
+	RecoveryCodes []string `bson:"recovery_codes,omitempty" json:"-"`
+
+	// DeletionScheduledFor is set while the user's deletion request is in its
+	// grace period; the account and its data are purged after that time.
+	DeletionRequestedAt  *time.Time `bson:"deletion_requested_at,omitempty" json:"deletion_requested_at,omitempty"`
+	DeletionScheduledFor *time.Time `bson:"deletion_scheduled_for,omitempty" json:"deletion_scheduled_for,omitempty"`
+	DeletionStartedAt    *time.Time `bson:"deletion_started_at,omitempty" json:"-"`
+
+	// Identities links the account to external identity providers.
+	Identities []Identity `bson:"identities,omitempty" json:"-"`
+	// DirectoryManaged is set on accounts an LDAP directory created. Their
+	// password and role live in the directory, so the API neither resets the
+	// password nor sends login links, and syncs the role on every login.
+	DirectoryManaged bool `bson:"directory_managed,omitempty" json:"directory_managed,omitempty"`
+}
+
+// Identity is an account at an external identity provider: an OpenID Connect
+// issuer, or an LDAP directory keyed by its URL.
+type Identity struct {
+	Issuer  string `bson:"issuer" json:"issuer"`
+	Subject string `bson:"subject" json:"subject"`
+}
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_]{3,30}$`)
+
+// ValidUsername reports whether s is an acceptable handle: 3 to 30 letters,
+// digits or underscores.
+func ValidUsername(s string) bool {
+	return usernamePattern.MatchString(s)
+}
+
+// FoldUsername is the form usernames are compared in, so that handles
+// differing only in case are the same handle.
+func FoldUsername(s string) string {
+	return strings.ToLower(s)
+}
+
+// NormalizeEmail is applied to every email address the API is given, so that
+// Foo@x.com and foo@x.com are the same account.
+func NormalizeEmail(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// EffectiveRole is the user's role, treating accounts created before roles
+// existed as plain users.
+func (u *User) EffectiveRole() Role {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+// HasCredentials reports whether the account can sign in by itself, with a
+// password, a second factor, an identity provider or its directory. Such an
+// account must not be linked to a new identity just because the emails match.
+func (u *User) HasCredentials() bool {
+	return u.Password != "" || u.TOTPEnabled || len(u.Identities) > 0 || u.DirectoryManaged
 }
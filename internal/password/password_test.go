@@ -0,0 +1,101 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestHasher(t *testing.T, memory, iterations, parallelism int) *Hasher {
+	t.Helper()
+	h, err := NewHasher(&config.Config{Argon2Memory: memory, Argon2Iterations: iterations, Argon2Parallelism: parallelism})
+	if err != nil {
+		t.Fatalf("NewHasher: %v", err)
+	}
+	return h
+}
+
+func TestHashFormat(t *testing.T) {
+	h := newTestHasher(t, 1024, 1, 1)
+	a, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(a, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("Hash() = %q, want argon2id PHC format", a)
+	}
+	b, _ := h.Hash("secret")
+	if a == b {
+		t.Fatal("two hashes of one password share a salt")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	h := newTestHasher(t, 1024, 1, 1)
+	current, _ := h.Hash("secret")
+	weaker, _ := newTestHasher(t, 512, 1, 1).Hash("secret")
+	moreRounds, _ := newTestHasher(t, 1024, 2, 1).Hash("secret")
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// $2a$ as written by older bcrypt libraries
+	bcrypt2a := "$2a$" + strings.TrimPrefix(string(bcryptHash), "$2a$")
+
+	tests := []struct {
+		name       string
+		password   string
+		encoded    string
+		ok, rehash bool
+	}{
+		{"current", "secret", current, true, false},
+		{"wrong password", "Secret", current, false, false},
+		{"empty password", "", current, false, false},
+		{"other memory", "secret", weaker, true, true},
+		{"other iterations", "secret", moreRounds, true, true},
+		{"bcrypt", "secret", bcrypt2a, true, true},
+		{"bcrypt wrong password", "nope", bcrypt2a, false, false},
+		{"bcrypt 2y", "secret", "$2y$" + strings.TrimPrefix(string(bcryptHash), "$2a$"), true, true},
+		{"empty hash", "secret", "", false, false},
+		{"plain text", "secret", "secret", false, false},
+		{"argon2i", "secret", strings.Replace(current, "argon2id", "argon2i", 1), false, false},
+		{"other version", "secret", strings.Replace(current, "v=19", "v=16", 1), false, false},
+		{"zero memory", "secret", strings.Replace(current, "m=1024", "m=0", 1), false, false},
+		{"bad salt", "secret", replaceField(current, 4, "!!"), false, false},
+		{"empty key", "secret", replaceField(current, 5, ""), false, false},
+		{"extra field", "secret", current + "$x", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash := h.Verify(tt.password, tt.encoded)
+			if ok != tt.ok || rehash != tt.rehash {
+				t.Fatalf("Verify() = %v, %v, want %v, %v", ok, rehash, tt.ok, tt.rehash)
+			}
+		})
+	}
+}
+
+func TestDummy(t *testing.T) {
+	h := newTestHasher(t, 1024, 1, 1)
+	if _, _, _, err := decode(h.Dummy()); err != nil {
+		t.Fatalf("Dummy() is not a valid hash: %v", err)
+	}
+	if ok, _ := h.Verify("", h.Dummy()); ok {
+		t.Fatal("Dummy() matches the empty password")
+	}
+}
+
+func TestNewHasherRejectsParallelism(t *testing.T) {
+	if _, err := NewHasher(&config.Config{Argon2Memory: 1024, Argon2Iterations: 1, Argon2Parallelism: 256}); err == nil {
+		t.Fatal("NewHasher accepted a parallelism above 255")
+	}
+}
+
+// replaceField replaces the i-th $-separated field of a PHC string.
+func replaceField(encoded string, i int, v string) string {
+	parts := strings.Split(encoded, "$")
+	parts[i] = v
+	return strings.Join(parts, "$")
+}
@@ -0,0 +1,228 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("bad test JSON %s: %v", s, err)
+	}
+	return v
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string // the result, if the patch applies
+		err   error  // the error kind, if it is not applied; nil for any
+	}{
+		// add
+		{name: "add member", doc: `{"a":1}`, patch: `[{"op":"add","path":"/b","value":2}]`, want: `{"a":1,"b":2}`},
+		{name: "add replaces member", doc: `{"a":1}`, patch: `[{"op":"add","path":"/a","value":[1]}]`, want: `{"a":[1]}`},
+		{name: "add null", doc: `{}`, patch: `[{"op":"add","path":"/a","value":null}]`, want: `{"a":null}`},
+		{name: "add nested", doc: `{"a":{"b":{}}}`, patch: `[{"op":"add","path":"/a/b/c","value":true}]`, want: `{"a":{"b":{"c":true}}}`},
+		{name: "add whole document", doc: `{"a":1}`, patch: `[{"op":"add","path":"","value":[1,2]}]`, want: `[1,2]`},
+		{name: "add to missing parent", doc: `{}`, patch: `[{"op":"add","path":"/a/b","value":1}]`},
+		{name: "add to scalar", doc: `{"a":1}`, patch: `[{"op":"add","path":"/a/b","value":1}]`},
+		{name: "add without value", doc: `{}`, patch: `[{"op":"add","path":"/a"}]`, err: ErrMalformed},
+
+		// arrays
+		{name: "insert at start", doc: `{"a":[1,2]}`, patch: `[{"op":"add","path":"/a/0","value":0}]`, want: `{"a":[0,1,2]}`},
+		{name: "insert in middle", doc: `{"a":[1,3]}`, patch: `[{"op":"add","path":"/a/1","value":2}]`, want: `{"a":[1,2,3]}`},
+		{name: "insert at length appends", doc: `{"a":[1,2]}`, patch: `[{"op":"add","path":"/a/2","value":3}]`, want: `{"a":[1,2,3]}`},
+		{name: "insert past length", doc: `{"a":[1,2]}`, patch: `[{"op":"add","path":"/a/3","value":3}]`},
+		{name: "dash appends", doc: `{"a":[1]}`, patch: `[{"op":"add","path":"/a/-","value":2}]`, want: `{"a":[1,2]}`},
+		{name: "dash appends to empty", doc: `{"a":[]}`, patch: `[{"op":"add","path":"/a/-","value":1}]`, want: `{"a":[1]}`},
+		{name: "dash in the middle of a path", doc: `{"a":[{"b":1}]}`, patch: `[{"op":"add","path":"/a/-/b","value":2}]`},
+		{name: "index with leading zero", doc: `{"a":[1,2]}`, patch: `[{"op":"add","path":"/a/01","value":0}]`},
+		{name: "negative index", doc: `{"a":[1,2]}`, patch: `[{"op":"add","path":"/a/-1","value":0}]`},
+		{name: "index is not a number", doc: `{"a":[1,2]}`, patch: `[{"op":"replace","path":"/a/x","value":0}]`},
+		{name: "replace last element", doc: `{"a":[1,2]}`, patch: `[{"op":"replace","path":"/a/1","value":3}]`, want: `{"a":[1,3]}`},
+		{name: "replace past the end", doc: `{"a":[1,2]}`, patch: `[{"op":"replace","path":"/a/2","value":3}]`},
+		{name: "remove first element", doc: `{"a":[1,2,3]}`, patch: `[{"op":"remove","path":"/a/0"}]`, want: `{"a":[2,3]}`},
+		{name: "remove last element", doc: `{"a":[1,2,3]}`, patch: `[{"op":"remove","path":"/a/2"}]`, want: `{"a":[1,2]}`},
+		{name: "remove past the end", doc: `{"a":[1]}`, patch: `[{"op":"remove","path":"/a/1"}]`},
+		{name: "remove dash", doc: `{"a":[1]}`, patch: `[{"op":"remove","path":"/a/-"}]`},
+		{name: "nested array", doc: `[[1],[2]]`, patch: `[{"op":"add","path":"/1/0","value":0}]`, want: `[[1],[0,2]]`},
+
+		// remove
+		{name: "remove member", doc: `{"a":1,"b":2}`, patch: `[{"op":"remove","path":"/a"}]`, want: `{"b":2}`},
+		{name: "remove missing member", doc: `{"a":1}`, patch: `[{"op":"remove","path":"/b"}]`},
+		{name: "remove whole document", doc: `{"a":1}`, patch: `[{"op":"remove","path":""}]`},
+
+		// replace
+		{name: "replace member", doc: `{"a":1}`, patch: `[{"op":"replace","path":"/a","value":"x"}]`, want: `{"a":"x"}`},
+		{name: "replace with null", doc: `{"a":1}`, patch: `[{"op":"replace","path":"/a","value":null}]`, want: `{"a":null}`},
+		{name: "replace missing member", doc: `{"a":1}`, patch: `[{"op":"replace","path":"/b","value":2}]`},
+		{name: "replace whole document", doc: `{"a":1}`, patch: `[{"op":"replace","path":"","value":{"b":2}}]`, want: `{"b":2}`},
+
+		// move
+		{name: "move member", doc: `{"a":1}`, patch: `[{"op":"move","from":"/a","path":"/b"}]`, want: `{"b":1}`},
+		{name: "move into object", doc: `{"a":1,"b":{}}`, patch: `[{"op":"move","from":"/a","path":"/b/c"}]`, want: `{"b":{"c":1}}`},
+		{name: "move within array", doc: `{"a":[1,2,3]}`, patch: `[{"op":"move","from":"/a/0","path":"/a/2"}]`, want: `{"a":[2,3,1]}`},
+		{name: "move to dash", doc: `{"a":[1,2,3]}`, patch: `[{"op":"move","from":"/a/0","path":"/a/-"}]`, want: `{"a":[2,3,1]}`},
+		{name: "move onto itself", doc: `{"a":1}`, patch: `[{"op":"move","from":"/a","path":"/a"}]`, want: `{"a":1}`},
+		{name: "move into own child", doc: `{"a":{"b":1}}`, patch: `[{"op":"move","from":"/a","path":"/a/b"}]`},
+		{name: "move missing member", doc: `{"a":1}`, patch: `[{"op":"move","from":"/b","path":"/c"}]`},
+		{name: "move without from", doc: `{"a":1}`, patch: `[{"op":"move","path":"/b"}]`, err: ErrMalformed},
+
+		// copy
+		{name: "copy member", doc: `{"a":[1]}`, patch: `[{"op":"copy","from":"/a","path":"/b"}]`, want: `{"a":[1],"b":[1]}`},
+		{
+			name:  "copy is deep",
+			doc:   `{"a":{"x":1}}`,
+			patch: `[{"op":"copy","from":"/a","path":"/b"},{"op":"replace","path":"/b/x","value":2}]`,
+			want:  `{"a":{"x":1},"b":{"x":2}}`,
+		},
+		{name: "copy into array", doc: `{"a":[1,2]}`, patch: `[{"op":"copy","from":"/a/1","path":"/a/0"}]`, want: `{"a":[2,1,2]}`},
+		{name: "copy missing member", doc: `{}`, patch: `[{"op":"copy","from":"/a","path":"/b"}]`},
+
+		// test
+		{name: "test passes", doc: `{"a":{"b":[1,"x",null]}}`, patch: `[{"op":"test","path":"/a","value":{"b":[1,"x",null]}}]`, want: `{"a":{"b":[1,"x",null]}}`},
+		{name: "test null", doc: `{"a":null}`, patch: `[{"op":"test","path":"/a","value":null}]`, want: `{"a":null}`},
+		{name: "test differs", doc: `{"a":1}`, patch: `[{"op":"test","path":"/a","value":2}]`, err: ErrTestFailed},
+		{name: "test type differs", doc: `{"a":1}`, patch: `[{"op":"test","path":"/a","value":"1"}]`, err: ErrTestFailed},
+		{name: "test missing member", doc: `{}`, patch: `[{"op":"test","path":"/a","value":null}]`},
+
+		// escaping
+		{name: "tilde one is a slash", doc: `{"a/b":1}`, patch: `[{"op":"replace","path":"/a~1b","value":2}]`, want: `{"a/b":2}`},
+		{name: "tilde zero is a tilde", doc: `{"a~b":1}`, patch: `[{"op":"remove","path":"/a~0b"}]`, want: `{}`},
+		{name: "tilde zero one is tilde one", doc: `{"~1":1}`, patch: `[{"op":"test","path":"/~01","value":1}]`, want: `{"~1":1}`},
+		{name: "escaped from", doc: `{"~/":1}`, patch: `[{"op":"move","from":"/~0~1","path":"/x"}]`, want: `{"x":1}`},
+		{name: "empty member name", doc: `{"":1}`, patch: `[{"op":"replace","path":"/","value":2}]`, want: `{"":2}`},
+
+		// malformed
+		{name: "not an array", doc: `{}`, patch: `{"op":"add","path":"/a","value":1}`, err: ErrMalformed},
+		{name: "unknown op", doc: `{}`, patch: `[{"op":"merge","path":"/a","value":1}]`, err: ErrMalformed},
+		{name: "missing path", doc: `{}`, patch: `[{"op":"remove"}]`, err: ErrMalformed},
+		{name: "relative path", doc: `{"a":1}`, patch: `[{"op":"remove","path":"a"}]`, err: ErrMalformed},
+
+		// sequences
+		{name: "empty patch", doc: `{"a":1}`, patch: `[]`, want: `{"a":1}`},
+		{
+			name:  "operations see earlier ones",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"add","path":"/b","value":[]},{"op":"add","path":"/b/-","value":1},{"op":"test","path":"/b/0","value":1}]`,
+			want:  `{"a":1,"b":[1]}`,
+		},
+		{
+			name:  "failed test aborts",
+			doc:   `{"a":1,"b":[1]}`,
+			patch: `[{"op":"remove","path":"/a"},{"op":"add","path":"/b/-","value":2},{"op":"test","path":"/b/0","value":9}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "failed operation aborts",
+			doc:   `{"a":{"x":1}}`,
+			patch: `[{"op":"replace","path":"/a/x","value":2},{"op":"remove","path":"/missing"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := decode(t, tt.doc)
+			got, err := Apply(doc, []byte(tt.patch))
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("Apply() = %v, want an error", got)
+				}
+				if tt.err != nil && !errors.Is(err, tt.err) {
+					t.Fatalf("Apply() error = %v, want %v", err, tt.err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Apply() error = %v", err)
+				}
+				if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+					t.Fatalf("Apply() = %v, want %v", got, want)
+				}
+			}
+			// whatever happened, the input is untouched
+			if orig := decode(t, tt.doc); !reflect.DeepEqual(doc, orig) {
+				t.Fatalf("document changed to %v", doc)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		// the examples of RFC 7396, appendix A
+		{"replace member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"null removes", `{"a":"b"}`, `{"a":null}`, `{}`},
+		{"null removes one", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"arrays are replaced", `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"with an array", `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{"nested", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{"array of objects", `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{"whole array", `["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{"object replaces array", `{"a":"b"}`, `["c"]`, `["c"]`},
+		{"null replaces document", `{"a":"foo"}`, `null`, `null`},
+		{"string replaces document", `{"a":"foo"}`, `"bar"`, `"bar"`},
+		{"null inside new member", `{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{"array becomes object", `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{"deep null dropped", `{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+
+		{"empty patch", `{"a":1}`, `{}`, `{"a":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := decode(t, tt.doc)
+			got, err := Merge(doc, []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+			if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Fatalf("Merge() = %v, want %v", got, want)
+			}
+			if orig := decode(t, tt.doc); !reflect.DeepEqual(doc, orig) {
+				t.Fatalf("document changed to %v", doc)
+			}
+		})
+	}
+
+	if _, err := Merge(map[string]any{}, []byte(`{"a":`)); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("Merge() of invalid JSON: error = %v, want %v", err, ErrMalformed)
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		pointer string
+		want    []string
+		err     bool
+	}{
+		{"", nil, false},
+		{"/", []string{""}, false},
+		{"/a/b", []string{"a", "b"}, false},
+		{"/a//b", []string{"a", "", "b"}, false},
+		{"/m~0n", []string{"m~n"}, false},
+		{"/a~1b", []string{"a/b"}, false},
+		{"/~01", []string{"~1"}, false},
+		{"/~10", []string{"/0"}, false},
+		{"a", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePointer(tt.pointer)
+		if (err != nil) != tt.err {
+			t.Errorf("parsePointer(%q) error = %v", tt.pointer, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePointer(%q) = %q, want %q", tt.pointer, got, tt.want)
+		}
+	}
+}
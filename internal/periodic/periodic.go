@@ -0,0 +1,44 @@
+// Package periodic runs background jobs, such as the purges, on a fixed
+// interval.
+package periodic
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Start calls job right away and then every interval until the returned stop
+// func is called. Each run gets timeout to finish, and is cancelled early by
+// stop, which waits for it to return. Errors are logged under name.
+func Start(name string, interval, timeout time.Duration, job func(ctx context.Context) error) (stop func()) {
+	base, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			run(base, name, timeout, job)
+			select {
+			case <-t.C:
+			case <-base.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func run(base context.Context, name string, timeout time.Duration, job func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(base, timeout)
+	defer cancel()
+	if err := job(ctx); err != nil {
+		log.Printf("%s: %v", name, err)
+	}
+}
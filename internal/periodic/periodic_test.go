@@ -0,0 +1,46 @@
+package periodic
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartRunsUntilStopped(t *testing.T) {
+	var runs atomic.Int32
+	stop := Start("test", 5*time.Millisecond, time.Second, func(ctx context.Context) error {
+		runs.Add(1)
+		return errors.New("logged, not fatal")
+	})
+	deadline := time.Now().Add(time.Second)
+	for runs.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	n := runs.Load()
+	if n < 3 {
+		t.Fatalf("ran %d times, want at least 3", n)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if runs.Load() != n {
+		t.Fatalf("ran again after stop")
+	}
+}
+
+func TestStopCancelsRunningJob(t *testing.T) {
+	started := make(chan struct{})
+	var cancelled atomic.Bool
+	stop := Start("test", time.Hour, time.Hour, func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		cancelled.Store(true)
+		return ctx.Err()
+	})
+	<-started
+	stop()
+	if !cancelled.Load() {
+		t.Fatal("stop returned before the job was cancelled")
+	}
+}
@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AccessTokenRepo struct {
+	col *mongo.Collection
+}
+
+func NewAccessTokenRepo(db *mongo.Database) *AccessTokenRepo {
+	return &AccessTokenRepo{
+		col: db.Collection("access_tokens"),
+	}
+}
+
+func (r *AccessTokenRepo) Create(ctx context.Context, t *models.PersonalAccessToken) error {
+	t.ID = primitive.NewObjectID()
+	t.CreatedAt = time.Now().UTC()
+	_, err := r.col.InsertOne(ctx, t)
+	return err
+}
+
+func (r *AccessTokenRepo) FindByHash(ctx context.Context, hash string) (*models.PersonalAccessToken, error) {
+	var t models.PersonalAccessToken
+	err := r.col.FindOne(ctx, bson.M{"token_hash": hash}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &t, err
+}
+
+func (r *AccessTokenRepo) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.PersonalAccessToken, error) {
+	cur, err := r.col.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.PersonalAccessToken{}
+	for cur.Next(ctx) {
+		var t models.PersonalAccessToken
+		if err := cur.Decode(&t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, cur.Err()
+}
+
+// TouchLastUsed records usage, writing at most once a minute per token.
+func (r *AccessTokenRepo) TouchLastUsed(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now().UTC()
+	_, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "$or": bson.A{
+			bson.M{"last_used_at": bson.M{"$lt": now.Add(-time.Minute)}},
+			bson.M{"last_used_at": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{"last_used_at": now}},
+	)
+	return err
+}
+
+// Delete removes a token owned by userID.
+func (r *AccessTokenRepo) Delete(ctx context.Context, id, userID primitive.ObjectID) error {
+	res, err := r.col.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteByUser removes every document owned by userID.
+func (r *AccessTokenRepo) DeleteByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	res, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (r *AccessTokenRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"token_hash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"user_id": 1}},
+	})
+	return err
+}
@@ -0,0 +1,146 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditRepo is append-only: events are never deleted, and the only update is
+// EraseUser, which strips personal data once an account is gone.
+type AuditRepo struct {
+	col *mongo.Collection
+}
+
+func NewAuditRepo(db *mongo.Database) *AuditRepo {
+	return &AuditRepo{
+		col: db.Collection("audit_events"),
+	}
+}
+
+func (r *AuditRepo) Insert(ctx context.Context, ev *models.AuditEvent) error {
+	ev.ID = primitive.NewObjectID()
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	_, err := r.col.InsertOne(ctx, ev)
+	return err
+}
+
+// AuditFilter narrows List. Zero fields match everything.
+type AuditFilter struct {
+	Action   string
+	Outcome  string
+	ActorID  *primitive.ObjectID
+	TargetID string
+	IP       string
+	Since    time.Time
+	Until    time.Time
+	// Before pages backwards: only events older than this id are returned.
+	Before *primitive.ObjectID
+}
+
+// List returns matching events newest first.
+func (r *AuditRepo) List(ctx context.Context, f AuditFilter, limit int) ([]models.AuditEvent, error) {
+	filter := bson.M{}
+	if f.Action != "" {
+		filter["action"] = f.Action
+	}
+	if f.Outcome != "" {
+		filter["outcome"] = f.Outcome
+	}
+	if f.ActorID != nil {
+		filter["actor_id"] = *f.ActorID
+	}
+	if f.TargetID != "" {
+		filter["target_id"] = f.TargetID
+	}
+	if f.IP != "" {
+		filter["ip"] = f.IP
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		t := bson.M{}
+		if !f.Since.IsZero() {
+			t["$gte"] = f.Since
+		}
+		if !f.Until.IsZero() {
+			t["$lt"] = f.Until
+		}
+		filter["time"] = t
+	}
+	if f.Before != nil {
+		filter["_id"] = bson.M{"$lt": *f.Before}
+	}
+
+	// ids grow with insertion time, which keeps Before a stable cursor
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit))
+	cur, err := r.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.AuditEvent{}
+	for cur.Next(ctx) {
+		var ev models.AuditEvent
+		if err := cur.Decode(&ev); err != nil {
+			return nil, err
+		}
+		out = append(out, ev)
+	}
+	return out, cur.Err()
+}
+
+// ListByUser returns every event the user acted in, or that names their email
+// such as failed logins, oldest first.
+func (r *AuditRepo) ListByUser(ctx context.Context, userID primitive.ObjectID, email string) ([]models.AuditEvent, error) {
+	cur, err := r.col.Find(ctx, userEventsFilter(userID, email), options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.AuditEvent{}
+	for cur.Next(ctx) {
+		var ev models.AuditEvent
+		if err := cur.Decode(&ev); err != nil {
+			return nil, err
+		}
+		out = append(out, ev)
+	}
+	return out, cur.Err()
+}
+
+// EraseUser removes the email address, IP address and user agent from the
+// events of a deleted user. The events themselves stay, keyed by an id that no
+// longer leads to anyone.
+func (r *AuditRepo) EraseUser(ctx context.Context, user *models.User) (int64, error) {
+	res, err := r.col.UpdateMany(ctx, userEventsFilter(user.ID, user.Email),
+		bson.M{"$unset": bson.M{"email": "", "ip": "", "user_agent": ""}})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func userEventsFilter(userID primitive.ObjectID, email string) bson.M {
+	or := bson.A{bson.M{"actor_id": userID}}
+	if email != "" {
+		or = append(or, bson.M{"email": email})
+	}
+	return bson.M{"$or": or}
+}
+
+func (r *AuditRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"time": -1}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.M{"email": 1}},
+	})
+	return err
+}
@@ -0,0 +1,110 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type InviteRepo struct {
+	col *mongo.Collection
+}
+
+func NewInviteRepo(db *mongo.Database) *InviteRepo {
+	return &InviteRepo{
+		col: db.Collection("invites"),
+	}
+}
+
+func (r *InviteRepo) Create(ctx context.Context, inv *models.Invite) error {
+	inv.ID = primitive.NewObjectID()
+	inv.CreatedAt = time.Now().UTC()
+	_, err := r.col.InsertOne(ctx, inv)
+	return err
+}
+
+func (r *InviteRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Invite, error) {
+	var inv models.Invite
+	err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&inv)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &inv, err
+}
+
+// Use atomically takes one use of a live invite and returns it, or nil if the
+// code is unknown, revoked, expired or used up.
+func (r *InviteRepo) Use(ctx context.Context, hash string) (*models.Invite, error) {
+	filter := bson.M{
+		"code_hash":  hash,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": time.Now().UTC()},
+		"$expr":      bson.M{"$lt": bson.A{"$uses", "$max_uses"}},
+	}
+	var inv models.Invite
+	err := r.col.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"uses": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&inv)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &inv, err
+}
+
+// Release gives back a use taken by Use when registration failed afterwards.
+func (r *InviteRepo) Release(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id, "uses": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"uses": -1}})
+	return err
+}
+
+// List returns invites newest first, all of them when createdBy is nil.
+func (r *InviteRepo) List(ctx context.Context, createdBy *primitive.ObjectID) ([]models.Invite, error) {
+	filter := bson.M{}
+	if createdBy != nil {
+		filter["created_by"] = *createdBy
+	}
+	cur, err := r.col.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.Invite{}
+	for cur.Next(ctx) {
+		var inv models.Invite
+		if err := cur.Decode(&inv); err != nil {
+			return nil, err
+		}
+		out = append(out, inv)
+	}
+	return out, cur.Err()
+}
+
+// Revoke stops an invite from being used again.
+func (r *InviteRepo) Revoke(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// DeleteByUser removes every invite created by userID.
+func (r *InviteRepo) DeleteByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	res, err := r.col.DeleteMany(ctx, bson.M{"created_by": userID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (r *InviteRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"code_hash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "created_by", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LoginThrottleRepo struct {
+	col *mongo.Collection
+}
+
+func NewLoginThrottleRepo(db *mongo.Database) *LoginThrottleRepo {
+	return &LoginThrottleRepo{
+		col: db.Collection("login_throttles"),
+	}
+}
+
+// Find returns the throttle entries for the given keys that have not lapsed.
+func (r *LoginThrottleRepo) Find(ctx context.Context, keys ...string) ([]models.LoginThrottle, error) {
+	filter := bson.M{"_id": bson.M{"$in": keys}, "expires_at": bson.M{"$gt": time.Now().UTC()}}
+	cur, err := r.col.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var out []models.LoginThrottle
+	for cur.Next(ctx) {
+		var t models.LoginThrottle
+		if err := cur.Decode(&t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, cur.Err()
+}
+
+// RecordFailure increments the failure counter of key, restarting it if the
+// previous entry lapsed, and returns the updated entry.
+func (r *LoginThrottleRepo) RecordFailure(ctx context.Context, key string, window time.Duration) (*models.LoginThrottle, error) {
+	now := time.Now().UTC()
+	// a lapsed entry may linger until the TTL monitor runs; start it over
+	if _, err := r.col.DeleteOne(ctx, bson.M{"_id": key, "expires_at": bson.M{"$lte": now}}); err != nil {
+		return nil, err
+	}
+	var t models.LoginThrottle
+	err := r.col.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"last_failure_at": now, "expires_at": now.Add(window)},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&t)
+	return &t, err
+}
+
+// Lock blocks key until the given time, extending the entry's lifetime to match.
+func (r *LoginThrottleRepo) Lock(ctx context.Context, key string, until, expiresAt time.Time) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": key},
+		bson.M{"$set": bson.M{"locked_until": until, "expires_at": expiresAt}})
+	return err
+}
+
+// Clear forgets the given keys, unlocking them.
+func (r *LoginThrottleRepo) Clear(ctx context.Context, keys ...string) (int64, error) {
+	res, err := r.col.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (r *LoginThrottleRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
@@ -28,6 +28,7 @@ func (r *NoteRepo) Create(ctx context.Context, n *models.Note) error {
 	n.ID = primitive.NewObjectID()
 	n.CreatedAt = now
 	n.UpdatedAt = now
+	n.Version = 1
 	_, err := r.col.InsertOne(ctx, n)
 	return err
 
@@ -35,7 +36,7 @@ func (r *NoteRepo) Create(ctx context.Context, n *models.Note) error {
 
 func (r *NoteRepo) FindById(ctx context.Context, id primitive.ObjectID) (*models.Note, error) {
 	var n models.Note
-	err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&n)
+	err := r.col.FindOne(ctx, bson.M{"_id": id, "deleted_at": nil}).Decode(&n)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
@@ -52,7 +53,7 @@ func (r *NoteRepo) ListByUser(ctx context.Context, userId primitive.ObjectID, pa
 	skip := int64((page - 1) * limit)
 	limit64 := int64(limit)
 
-	filter := bson.M{"user_id": userId}
+	filter := bson.M{"user_id": userId, "deleted_at": nil}
 	cur, err := r.col.Find(ctx, filter, &options.FindOptions{
 		Skip:  &skip,
 		Limit: &limit64,
@@ -74,6 +75,15 @@ func (r *NoteRepo) ListByUser(ctx context.Context, userId primitive.ObjectID, pa
 }
 
 func (r *NoteRepo) ListPublic(ctx context.Context, page, limit int) ([]models.Note, error) {
+	return r.listPublic(ctx, bson.M{}, page, limit)
+}
+
+// ListPublicByUser lists the public notes of one author, newest first.
+func (r *NoteRepo) ListPublicByUser(ctx context.Context, userId primitive.ObjectID, page, limit int) ([]models.Note, error) {
+	return r.listPublic(ctx, bson.M{"user_id": userId}, page, limit)
+}
+
+func (r *NoteRepo) listPublic(ctx context.Context, filter bson.M, page, limit int) ([]models.Note, error) {
 	if page < 1 {
 		page = 1
 	}
@@ -83,7 +93,8 @@ func (r *NoteRepo) ListPublic(ctx context.Context, page, limit int) ([]models.No
 	skip := int64((page - 1) * limit)
 	limit64 := int64(limit)
 
-	filter := bson.M{"is_public": true}
+	filter["is_public"] = true
+	filter["deleted_at"] = nil
 	cur, err := r.col.Find(ctx, filter, &options.FindOptions{
 		Skip:  &skip,
 		Limit: &limit64,
@@ -106,24 +117,44 @@ func (r *NoteRepo) ListPublic(ctx context.Context, page, limit int) ([]models.No
 
 func (r *NoteRepo) GetById(ctx context.Context, id primitive.ObjectID) (*models.Note, error) {
 	var n models.Note
-	err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&n)
+	err := r.col.FindOne(ctx, bson.M{"_id": id, "deleted_at": nil}).Decode(&n)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
 	return &n, err
 }
 
-func (r *NoteRepo) Update(ctx context.Context, id primitive.ObjectID, update bson.M) (*models.Note, error) {
+// AnyVersion makes a write unconditional.
+const AnyVersion = -1
+
+// versionFilter matches notes at version, counting notes from before versions
+// existed as version 0.
+func versionFilter(id primitive.ObjectID, version int) bson.M {
+	filter := bson.M{"_id": id, "deleted_at": nil}
+	switch {
+	case version == 0:
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	case version > 0:
+		filter["version"] = version
+	}
+	return filter
+}
+
+// Update sets the given fields and bumps the version, returning the note as
+// updated. It returns nil if the note is not found at version (AnyVersion
+// for any) or is in the trash.
+func (r *NoteRepo) Update(ctx context.Context, id primitive.ObjectID, version int, update bson.M) (*models.Note, error) {
 	update["updated_at"] = time.Now().UTC()
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 	var n models.Note
-	err := r.col.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": update}, opts).Decode(&n)
+	err := r.col.FindOneAndUpdate(ctx, versionFilter(id, version), bson.M{"$set": update, "$inc": bson.M{"version": 1}}, opts).Decode(&n)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
 	return &n, err
 }
 
+// Delete removes a note permanently, whether or not it is in the trash.
 func (r *NoteRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
 	res, err := r.col.DeleteOne(ctx, bson.M{"_id": id})
 	if err != nil {
@@ -134,3 +165,153 @@ func (r *NoteRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
 	}
 	return err
 }
+
+// Trash moves a note to the trash. It reports false if there was no such
+// note at version (AnyVersion for any) outside the trash.
+func (r *NoteRepo) Trash(ctx context.Context, id primitive.ObjectID, version int) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		versionFilter(id, version),
+		bson.M{"$set": bson.M{"deleted_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// FindTrashed returns a note only if it is in the trash.
+func (r *NoteRepo) FindTrashed(ctx context.Context, id primitive.ObjectID) (*models.Note, error) {
+	var n models.Note
+	err := r.col.FindOne(ctx, bson.M{"_id": id, "deleted_at": bson.M{"$ne": nil}}).Decode(&n)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &n, err
+}
+
+// ListTrash lists a user's trashed notes, most recently deleted first.
+func (r *NoteRepo) ListTrash(ctx context.Context, userId primitive.ObjectID, page, limit int) ([]models.Note, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	opts := options.Find().
+		SetSort(bson.M{"deleted_at": -1}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cur, err := r.col.Find(ctx, bson.M{"user_id": userId, "deleted_at": bson.M{"$ne": nil}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	notes := []models.Note{}
+	for cur.Next(ctx) {
+		var note models.Note
+		if err := cur.Decode(&note); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, cur.Err()
+}
+
+// Restore takes a note out of the trash and returns it, or nil if it was not
+// in the trash.
+func (r *NoteRepo) Restore(ctx context.Context, id primitive.ObjectID) (*models.Note, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var n models.Note
+	err := r.col.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "deleted_at": bson.M{"$ne": nil}},
+		bson.M{"$unset": bson.M{"deleted_at": ""}},
+		opts,
+	).Decode(&n)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &n, err
+}
+
+// EmptyTrash permanently removes a user's trashed notes and returns their ids.
+func (r *NoteRepo) EmptyTrash(ctx context.Context, userId primitive.ObjectID) ([]primitive.ObjectID, error) {
+	return r.deleteTrashed(ctx, bson.M{"user_id": userId, "deleted_at": bson.M{"$ne": nil}}, 0)
+}
+
+// PurgeTrash permanently removes up to limit notes trashed before cutoff and
+// returns their ids.
+func (r *NoteRepo) PurgeTrash(ctx context.Context, cutoff time.Time, limit int) ([]primitive.ObjectID, error) {
+	return r.deleteTrashed(ctx, bson.M{"deleted_at": bson.M{"$lt": cutoff}}, limit)
+}
+
+func (r *NoteRepo) deleteTrashed(ctx context.Context, filter bson.M, limit int) ([]primitive.ObjectID, error) {
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cur, err := r.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	// one at a time, keeping the filter, so that a note restored meanwhile
+	// survives and is not reported
+	ids := []primitive.ObjectID{}
+	for _, d := range docs {
+		filter["_id"] = d.ID
+		res, err := r.col.DeleteOne(ctx, filter)
+		if err != nil {
+			return ids, err
+		}
+		if res.DeletedCount == 1 {
+			ids = append(ids, d.ID)
+		}
+	}
+	return ids, nil
+}
+
+// ListAllByUser returns every note of a user, oldest first, for data export.
+func (r *NoteRepo) ListAllByUser(ctx context.Context, userId primitive.ObjectID) ([]models.Note, error) {
+	cur, err := r.col.Find(ctx, bson.M{"user_id": userId}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	notes := []models.Note{}
+	for cur.Next(ctx) {
+		var note models.Note
+		if err := cur.Decode(&note); err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, cur.Err()
+}
+
+func (r *NoteRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		// own notes and per-author public listings, newest first
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		// the public feed
+		{Keys: bson.D{{Key: "is_public", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.M{"tags": 1}},
+		// trash listings and the retention purge
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "deleted_at", Value: -1}}},
+		{Keys: bson.M{"deleted_at": 1}, Options: options.Index().SetSparse(true)},
+	})
+	return err
+}
+
+// DeleteByUser removes every note owned by userId.
+func (r *NoteRepo) DeleteByUser(ctx context.Context, userId primitive.ObjectID) (int64, error) {
+	res, err := r.col.DeleteMany(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NoteRevisionRepo keeps the history of notes. Revisions are never changed
+// once written; they only go away when their note is deleted for good, or
+// when the edit that claimed them was not saved.
+type NoteRevisionRepo struct {
+	col *mongo.Collection
+}
+
+func NewNoteRevisionRepo(db *mongo.Database) *NoteRevisionRepo {
+	return &NoteRevisionRepo{
+		col: db.Collection("note_revisions"),
+	}
+}
+
+// Create records n as it is now. Recording a version that already exists is
+// not an error, so callers may safely retry.
+func (r *NoteRevisionRepo) Create(ctx context.Context, n *models.Note, editedBy primitive.ObjectID) error {
+	_, err := r.col.InsertOne(ctx, newRevision(n, editedBy))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// Claim records n as the revision at its version and returns the revision's
+// id, or a zero id if that version is already recorded. Edits claim the
+// version they are about to write, so of two edits of the same version only
+// one gets through.
+func (r *NoteRevisionRepo) Claim(ctx context.Context, n *models.Note, editedBy primitive.ObjectID) (primitive.ObjectID, error) {
+	rev := newRevision(n, editedBy)
+	_, err := r.col.InsertOne(ctx, rev)
+	if mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, nil
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return rev.ID, nil
+}
+
+// Release removes a claimed revision whose edit was not saved.
+func (r *NoteRevisionRepo) Release(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.col.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// ReleaseAbandoned removes a note's revision at version if it was claimed
+// before cutoff, and reports whether it did.
+func (r *NoteRevisionRepo) ReleaseAbandoned(ctx context.Context, noteID primitive.ObjectID, version int, cutoff time.Time) (bool, error) {
+	res, err := r.col.DeleteOne(ctx, bson.M{"note_id": noteID, "version": version, "created_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount == 1, nil
+}
+
+func newRevision(n *models.Note, editedBy primitive.ObjectID) *models.NoteRevision {
+	return &models.NoteRevision{
+		ID:        primitive.NewObjectID(),
+		NoteID:    n.ID,
+		UserID:    n.UserID,
+		Version:   n.Version,
+		Title:     n.Title,
+		Content:   n.Content,
+		IsPublic:  n.IsPublic,
+		Tags:      n.Tags,
+		EditedBy:  editedBy,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// List returns a note's revisions newest first, without their content.
+func (r *NoteRevisionRepo) List(ctx context.Context, noteID primitive.ObjectID, page, limit int) ([]models.NoteRevision, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	opts := options.Find().
+		SetSort(bson.M{"version": -1}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"content": 0})
+	cur, err := r.col.Find(ctx, bson.M{"note_id": noteID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.NoteRevision{}
+	for cur.Next(ctx) {
+		var rev models.NoteRevision
+		if err := cur.Decode(&rev); err != nil {
+			return nil, err
+		}
+		out = append(out, rev)
+	}
+	return out, cur.Err()
+}
+
+func (r *NoteRevisionRepo) Get(ctx context.Context, noteID primitive.ObjectID, version int) (*models.NoteRevision, error) {
+	var rev models.NoteRevision
+	err := r.col.FindOne(ctx, bson.M{"note_id": noteID, "version": version}).Decode(&rev)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &rev, err
+}
+
+// ListByUser returns every revision of the user's notes, with content.
+func (r *NoteRevisionRepo) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.NoteRevision, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "note_id", Value: 1}, {Key: "version", Value: 1}})
+	cur, err := r.col.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.NoteRevision{}
+	for cur.Next(ctx) {
+		var rev models.NoteRevision
+		if err := cur.Decode(&rev); err != nil {
+			return nil, err
+		}
+		out = append(out, rev)
+	}
+	return out, cur.Err()
+}
+
+// DeleteByNotes removes the history of permanently deleted notes.
+func (r *NoteRevisionRepo) DeleteByNotes(ctx context.Context, noteIDs []primitive.ObjectID) error {
+	if len(noteIDs) == 0 {
+		return nil
+	}
+	_, err := r.col.DeleteMany(ctx, bson.M{"note_id": bson.M{"$in": noteIDs}})
+	return err
+}
+
+// DeleteByUser removes the history of every note owned by userID.
+func (r *NoteRevisionRepo) DeleteByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	res, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (r *NoteRevisionRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "note_id", Value: 1}, {Key: "version", Value: -1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"user_id": 1}},
+	})
+	return err
+}
@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OneTimeTokenRepo struct {
+	col *mongo.Collection
+}
+
+func NewOneTimeTokenRepo(db *mongo.Database) *OneTimeTokenRepo {
+	return &OneTimeTokenRepo{
+		col: db.Collection("one_time_tokens"),
+	}
+}
+
+func (r *OneTimeTokenRepo) Create(ctx context.Context, t *models.OneTimeToken) error {
+	t.ID = primitive.NewObjectID()
+	t.CreatedAt = time.Now().UTC()
+	_, err := r.col.InsertOne(ctx, t)
+	return err
+}
+
+// Consume atomically marks an unused, unexpired token as used and returns it.
+// It returns nil when no such token exists.
+func (r *OneTimeTokenRepo) Consume(ctx context.Context, purpose, hash string) (*models.OneTimeToken, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"purpose":    purpose,
+		"token_hash": hash,
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+	}
+	var t models.OneTimeToken
+	err := r.col.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"used_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &t, err
+}
+
+// InvalidateUser burns every outstanding token of the given purpose for a user.
+func (r *OneTimeTokenRepo) InvalidateUser(ctx context.Context, userID primitive.ObjectID, purpose string) error {
+	_, err := r.col.UpdateMany(ctx,
+		bson.M{"user_id": userID, "purpose": purpose, "used_at": nil},
+		bson.M{"$set": bson.M{"used_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// DeleteByUser removes every document owned by userID.
+func (r *OneTimeTokenRepo) DeleteByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	res, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (r *OneTimeTokenRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"token_hash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
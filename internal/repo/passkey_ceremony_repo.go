@@ -0,0 +1,62 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PasskeyCeremonyRepo struct {
+	col *mongo.Collection
+}
+
+func NewPasskeyCeremonyRepo(db *mongo.Database) *PasskeyCeremonyRepo {
+	return &PasskeyCeremonyRepo{
+		col: db.Collection("passkey_ceremonies"),
+	}
+}
+
+func (r *PasskeyCeremonyRepo) Create(ctx context.Context, c *models.PasskeyCeremony) error {
+	c.CreatedAt = time.Now().UTC()
+	_, err := r.col.InsertOne(ctx, c)
+	return err
+}
+
+// Take atomically removes an unexpired ceremony and returns it, so a second
+// finish with the same handle finds nothing. It returns nil when no such
+// ceremony exists.
+func (r *PasskeyCeremonyRepo) Take(ctx context.Context, purpose, id string) (*models.PasskeyCeremony, error) {
+	filter := bson.M{
+		"_id":        id,
+		"purpose":    purpose,
+		"expires_at": bson.M{"$gt": time.Now().UTC()},
+	}
+	var c models.PasskeyCeremony
+	err := r.col.FindOneAndDelete(ctx, filter).Decode(&c)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &c, err
+}
+
+// DeleteByUser removes every document owned by userID.
+func (r *PasskeyCeremonyRepo) DeleteByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	res, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (r *PasskeyCeremonyRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"user_id": 1}},
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PasskeyRepo struct {
+	col *mongo.Collection
+}
+
+func NewPasskeyRepo(db *mongo.Database) *PasskeyRepo {
+	return &PasskeyRepo{
+		col: db.Collection("passkeys"),
+	}
+}
+
+func (r *PasskeyRepo) Create(ctx context.Context, p *models.Passkey) error {
+	p.ID = primitive.NewObjectID()
+	p.CreatedAt = time.Now().UTC()
+	_, err := r.col.InsertOne(ctx, p)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *PasskeyRepo) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.Passkey, error) {
+	cur, err := r.col.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.Passkey{}
+	for cur.Next(ctx) {
+		var p models.Passkey
+		if err := cur.Decode(&p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, cur.Err()
+}
+
+// RecordLogin stores the authenticator state seen in a successful assertion.
+func (r *PasskeyRepo) RecordLogin(ctx context.Context, id primitive.ObjectID, signCount uint32, flags byte) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"sign_count":   signCount,
+		"flags":        flags,
+		"last_used_at": time.Now().UTC(),
+	}})
+	return err
+}
+
+func (r *PasskeyRepo) Delete(ctx context.Context, id, userID primitive.ObjectID) error {
+	res, err := r.col.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteByUser removes every document owned by userID.
+func (r *PasskeyRepo) DeleteByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	res, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (r *PasskeyRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"credential_id": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
@@ -0,0 +1,85 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type RefreshTokenRepo struct {
+	col *mongo.Collection
+}
+
+func NewRefreshTokenRepo(db *mongo.Database) *RefreshTokenRepo {
+	return &RefreshTokenRepo{
+		col: db.Collection("refresh_tokens"),
+	}
+}
+
+func (r *RefreshTokenRepo) Create(ctx context.Context, t *models.RefreshToken) error {
+	t.ID = primitive.NewObjectID()
+	t.CreatedAt = time.Now().UTC()
+	_, err := r.col.InsertOne(ctx, t)
+	return err
+}
+
+func (r *RefreshTokenRepo) FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var t models.RefreshToken
+	err := r.col.FindOne(ctx, bson.M{"token_hash": hash}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &t, err
+}
+
+// MarkRotated flags the token as used. It reports false when the token had
+// already been rotated or revoked, so two concurrent refreshes cannot both win.
+func (r *RefreshTokenRepo) MarkRotated(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	filter := bson.M{"_id": id, "rotated_at": nil, "revoked_at": nil}
+	res, err := r.col.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"rotated_at": time.Now().UTC()}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// RevokeFamily revokes every token descended from the same login.
+func (r *RefreshTokenRepo) RevokeFamily(ctx context.Context, familyID primitive.ObjectID) error {
+	_, err := r.col.UpdateMany(ctx,
+		bson.M{"family_id": familyID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// RevokeUser revokes every outstanding refresh token of a user.
+func (r *RefreshTokenRepo) RevokeUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.col.UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// DeleteByUser removes every document owned by userID.
+func (r *RefreshTokenRepo) DeleteByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	res, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (r *RefreshTokenRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"token_hash": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"family_id": 1}},
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
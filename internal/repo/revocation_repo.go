@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RevocationRepo stores revoked access tokens. Documents are keyed either by
+// "jti:<id>" for a single token or "user:<id>" for a logout-all cutoff, and a
+// TTL index removes them once the tokens they cover have expired.
+type RevocationRepo struct {
+	col *mongo.Collection
+}
+
+func NewRevocationRepo(db *mongo.Database) *RevocationRepo {
+	return &RevocationRepo{
+		col: db.Collection("revoked_tokens"),
+	}
+}
+
+func (r *RevocationRepo) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": "jti:" + jti},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *RevocationRepo) RevokeUserTokens(ctx context.Context, userID primitive.ObjectID, issuedBefore, expiresAt time.Time) error {
+	_, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": "user:" + userID.Hex()},
+		bson.M{"$set": bson.M{"issued_before": issuedBefore, "expires_at": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *RevocationRepo) IsRevoked(ctx context.Context, jti string, userID primitive.ObjectID, issuedAt time.Time) (bool, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"expires_at": bson.M{"$gt": now},
+		"$or": bson.A{
+			bson.M{"_id": "jti:" + jti},
+			bson.M{"_id": "user:" + userID.Hex(), "issued_before": bson.M{"$gt": issuedAt}},
+		},
+	}
+	n, err := r.col.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *RevocationRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires_at": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
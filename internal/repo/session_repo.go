@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SessionRepo struct {
+	col *mongo.Collection
+}
+
+func NewSessionRepo(db *mongo.Database) *SessionRepo {
+	return &SessionRepo{
+		col: db.Collection("sessions"),
+	}
+}
+
+func (r *SessionRepo) Create(ctx context.Context, s *models.Session) error {
+	now := time.Now().UTC()
+	s.ID = primitive.NewObjectID()
+	s.CreatedAt = now
+	s.LastSeenAt = now
+	_, err := r.col.InsertOne(ctx, s)
+	return err
+}
+
+func (r *SessionRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error) {
+	var s models.Session
+	err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &s, err
+}
+
+// ListActive returns the user's sessions that are neither revoked nor expired,
+// most recently used first.
+func (r *SessionRepo) ListActive(ctx context.Context, userID primitive.ObjectID) ([]models.Session, error) {
+	filter := bson.M{"user_id": userID, "revoked_at": nil, "expires_at": bson.M{"$gt": time.Now().UTC()}}
+	cur, err := r.col.Find(ctx, filter, options.Find().SetSort(bson.M{"last_seen_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.Session{}
+	for cur.Next(ctx) {
+		var s models.Session
+		if err := cur.Decode(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, cur.Err()
+}
+
+// ListByUser returns all of the user's sessions, including ended ones, oldest first.
+func (r *SessionRepo) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]models.Session, error) {
+	cur, err := r.col.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.Session{}
+	for cur.Next(ctx) {
+		var s models.Session
+		if err := cur.Decode(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, cur.Err()
+}
+
+// Touch records activity on a session.
+func (r *SessionRepo) Touch(ctx context.Context, id primitive.ObjectID, ip string) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_seen_at": time.Now().UTC(), "ip": ip}})
+	return err
+}
+
+// Extend pushes out the expiry of a live session when its refresh token rotates.
+func (r *SessionRepo) Extend(ctx context.Context, id primitive.ObjectID, expiresAt time.Time) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id, "revoked_at": nil}, bson.M{"$set": bson.M{
+		"expires_at":   expiresAt,
+		"last_seen_at": time.Now().UTC(),
+	}})
+	return err
+}
+
+// Revoke ends a session owned by userID, reporting whether it was live.
+func (r *SessionRepo) Revoke(ctx context.Context, id, userID primitive.ObjectID) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// RevokeUser ends every session of a user.
+func (r *SessionRepo) RevokeUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.col.UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now().UTC()}},
+	)
+	return err
+}
+
+// DeleteByUser removes every document owned by userID.
+func (r *SessionRepo) DeleteByUser(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	res, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (r *SessionRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen_at", Value: -1}}},
+		{Keys: bson.M{"expires_at": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
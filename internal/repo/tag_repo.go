@@ -4,16 +4,19 @@ import (
 	"context"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type TagRepo struct {
-	col *mongo.Collection
+	col   *mongo.Collection
+	notes *mongo.Collection
 }
 
 func NewTagRepo(db *mongo.Database) *TagRepo {
 	return &TagRepo{
-		col: db.Collection("tags"),
+		col:   db.Collection("tags"),
+		notes: db.Collection("notes"),
 	}
 }
 
@@ -25,7 +28,23 @@ func (r *TagRepo) TopTags(ctx context.Context, limit int) ([]bson.M, error) {
 		{{Key: "$sort", Value: bson.M{"count": -1}}},
 		{{Key: "$limit", Value: limit}},
 	}
-	cur, err := r.col.Aggregate(ctx, pipeline)
+	return aggregate(ctx, r.col, pipeline)
+}
+
+// UserTags returns the tags on a user's notes outside the trash, with the
+// number of notes carrying each, most used first.
+func (r *TagRepo) UserTags(ctx context.Context, userId primitive.ObjectID) ([]bson.M, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": userId, "deleted_at": nil}}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+	return aggregate(ctx, r.notes, pipeline)
+}
+
+func aggregate(ctx context.Context, col *mongo.Collection, pipeline mongo.Pipeline) ([]bson.M, error) {
+	cur, err := col.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
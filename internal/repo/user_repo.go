@@ -3,14 +3,25 @@ package repo
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrDuplicate is returned when a write collides with a unique index.
+var ErrDuplicate = errors.New("duplicate key")
+
+// emailCollation compares emails case-insensitively. Lookups use it too, so
+// they match accounts stored before emails were normalized and use the index.
+var emailCollation = &options.Collation{Locale: "en", Strength: 2}
+
 type UserRepo struct {
 	col *mongo.Collection
 }
@@ -22,27 +33,374 @@ func NewUserRepo(db *mongo.Database) *UserRepo {
 }
 
 func (r *UserRepo) Create(ctx context.Context, user *models.User) error {
+	user.ID = primitive.NewObjectID()
 	user.CreatedAt = time.Now().UTC()
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	if user.Username != "" {
+		user.UsernameKey = models.FoldUsername(user.Username)
+	}
 	_, err := r.col.InsertOne(ctx, user)
 	if mongo.IsDuplicateKeyError(err) {
-		return errors.New("email already exists")
+		return ErrDuplicate
 	}
 	return err
 }
 
+// FindByUsername looks a user up by handle, ignoring case.
+func (r *UserRepo) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	var u models.User
+	err := r.col.FindOne(ctx, bson.M{"username_key": models.FoldUsername(username)}).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &u, err
+}
+
 func (r *UserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
 	var u models.User
-	err := r.col.FindOne(ctx, bson.M{"email": email}).Decode(&u)
+	err := r.col.FindOne(ctx, bson.M{"email": email}, options.FindOne().SetCollation(emailCollation)).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &u, err
+}
+
+func (r *UserRepo) UpdatePassword(ctx context.Context, id primitive.ObjectID, hash string) error {
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"password": hash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *UserRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	var u models.User
+	err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return &u, err
+}
+
+// Update applies set to the user and returns the updated document, or nil if
+// there is no such user. A new username also updates its folded key.
+func (r *UserRepo) Update(ctx context.Context, id primitive.ObjectID, set bson.M) (*models.User, error) {
+	if username, ok := set["username"].(string); ok {
+		set["username_key"] = models.FoldUsername(username)
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var u models.User
+	err := r.col.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": set}, opts).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrDuplicate
+	}
+	return &u, err
+}
+
+// ChangeEmail switches the account from oldEmail to newEmail, which counts as
+// verified since the change is confirmed from the new inbox. It reports false
+// if the account no longer has oldEmail.
+func (r *UserRepo) ChangeEmail(ctx context.Context, id primitive.ObjectID, oldEmail, newEmail string) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "email": oldEmail},
+		bson.M{"$set": bson.M{"email": newEmail, "email_verified": true, "email_verified_at": time.Now().UTC()}},
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, ErrDuplicate
+	}
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// SetRole changes a user's role, reporting whether the user exists.
+func (r *UserRepo) SetRole(ctx context.Context, id primitive.ObjectID, role models.Role) (bool, error) {
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// MarkEmailVerified flags the user's email as verified, provided it is still
+// the address the verification was issued for.
+func (r *UserRepo) MarkEmailVerified(ctx context.Context, id primitive.ObjectID, email string) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "email": email},
+		bson.M{"$set": bson.M{"email_verified": true, "email_verified_at": time.Now().UTC()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// SetPendingTOTP stores a new secret for an account that has not enabled TOTP yet.
+func (r *UserRepo) SetPendingTOTP(ctx context.Context, id primitive.ObjectID, secret string) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "totp_enabled": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"totp_secret": secret}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+func (r *UserRepo) EnableTOTP(ctx context.Context, id primitive.ObjectID, step int64, recoveryCodes []string) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+		"recovery_codes": recoveryCodes,
+	}})
+	return err
+}
+
+func (r *UserRepo) DisableTOTP(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set":   bson.M{"totp_enabled": false},
+		"$unset": bson.M{"totp_secret": "", "totp_last_step": "", "recovery_codes": ""},
+	})
+	return err
+}
+
+// AdvanceTOTPStep records step as the last used one. It reports false if an
+// equal or later step was already used, i.e. the code is being replayed.
+func (r *UserRepo) AdvanceTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "$or": bson.A{
+			bson.M{"totp_last_step": bson.M{"$lt": step}},
+			bson.M{"totp_last_step": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{"totp_last_step": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// ConsumeRecoveryCode removes a recovery code hash, reporting whether it was present.
+func (r *UserRepo) ConsumeRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "recovery_codes": hash},
+		bson.M{"$pull": bson.M{"recovery_codes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *UserRepo) FindByIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	var u models.User
+	filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"issuer": issuer, "subject": subject}}}
+	err := r.col.FindOne(ctx, filter).Decode(&u)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
 	return &u, err
 }
 
+// LinkIdentity attaches an external identity to an account. The provider has
+// vouched for the email, so it is marked verified as well.
+func (r *UserRepo) LinkIdentity(ctx context.Context, id primitive.ObjectID, identity models.Identity) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$addToSet": bson.M{"identities": identity},
+		"$set":      bson.M{"email_verified": true},
+	})
+	return err
+}
+
+// MarkDirectoryManaged hands an account's password and role to the LDAP
+// directory that created it.
+func (r *UserRepo) MarkDirectoryManaged(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"directory_managed": true}})
+	return err
+}
+
+// ScheduleDeletion records a deletion request to be carried out at when.
+func (r *UserRepo) ScheduleDeletion(ctx context.Context, id primitive.ObjectID, when time.Time) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"deletion_requested_at":  time.Now().UTC(),
+		"deletion_scheduled_for": when,
+	}})
+	return err
+}
+
+// CancelDeletion withdraws a pending deletion request, reporting whether there
+// was one that had not started yet.
+func (r *UserRepo) CancelDeletion(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "deletion_scheduled_for": bson.M{"$exists": true}, "deletion_started_at": bson.M{"$exists": false}},
+		bson.M{"$unset": bson.M{"deletion_requested_at": "", "deletion_scheduled_for": ""}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// StartDeletion marks a due deletion as in progress so it can no longer be
+// cancelled. It reports false if the deletion was cancelled meanwhile.
+func (r *UserRepo) StartDeletion(ctx context.Context, id primitive.ObjectID, now time.Time) (bool, error) {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "deletion_scheduled_for": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"deletion_started_at": now}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// ListDueForDeletion returns up to limit users whose grace period ended before now.
+func (r *UserRepo) ListDueForDeletion(ctx context.Context, now time.Time, limit int64) ([]models.User, error) {
+	cur, err := r.col.Find(ctx, bson.M{"deletion_scheduled_for": bson.M{"$lte": now}}, options.Find().SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var out []models.User
+	for cur.Next(ctx) {
+		var u models.User
+		if err := cur.Decode(&u); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, cur.Err()
+}
+
+func (r *UserRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.col.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
 func (r *UserRepo) EnsureIndexes(ctx context.Context) error {
-	_, err := r.col.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.M{"email": 1},
-		Options: options.Index().SetUnique(true),
+	if err := r.backfillUsernameKeys(ctx); err != nil {
+		return err
+	}
+	_, err := r.col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"email": 1}, Options: options.Index().SetUnique(true).SetCollation(emailCollation)},
+		{
+			// accounts without a username have no key
+			Keys: bson.M{"username_key": 1},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"username_key": bson.M{"$exists": true}}),
+		},
 	})
+	if mongo.IsDuplicateKeyError(err) {
+		return r.duplicateEmailsError(ctx, err)
+	}
 	return err
 }
+
+// duplicateEmailsError explains a failed build of the email index, which is
+// due to accounts stored before emails were compared case-insensitively. It
+// logs every set of accounts sharing an email so they can be merged or
+// changed; a healthy start does not pay for the scan.
+func (r *UserRepo) duplicateEmailsError(ctx context.Context, indexErr error) error {
+	cur, err := r.col.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$email",
+			"users": bson.M{"$push": bson.M{"id": "$_id", "email": "$email"}},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}, options.Aggregate().SetCollation(emailCollation))
+	if err != nil {
+		return fmt.Errorf("%w (and listing duplicate emails failed: %v)", indexErr, err)
+	}
+	defer cur.Close(ctx)
+
+	sets := 0
+	for cur.Next(ctx) {
+		var group struct {
+			Users []struct {
+				ID    primitive.ObjectID `bson:"id"`
+				Email string             `bson:"email"`
+			} `bson:"users"`
+		}
+		if err := cur.Decode(&group); err != nil {
+			return err
+		}
+		accounts := make([]string, len(group.Users))
+		for i, u := range group.Users {
+			accounts[i] = fmt.Sprintf("%s (%q)", u.ID.Hex(), u.Email)
+		}
+		log.Printf("users: emails differ only in case: %s", strings.Join(accounts, ", "))
+		sets++
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	if sets == 0 {
+		return indexErr
+	}
+	return fmt.Errorf("%d emails are used by several accounts when compared case-insensitively (listed above); change or merge them before starting: %w", sets, indexErr)
+}
+
+// backfillUsernameKeys gives usernames stored before handles were compared
+// case-insensitively their username_key, so the unique index covers them. If
+// two accounts have handles differing only in case it fails, and one of them
+// has to be renamed before the API can start.
+func (r *UserRepo) backfillUsernameKeys(ctx context.Context) error {
+	cur, err := r.col.Find(ctx,
+		bson.M{"username": bson.M{"$nin": bson.A{nil, ""}}, "username_key": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"username": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	keys := map[primitive.ObjectID]string{}
+	owners := map[string]models.User{}
+	for cur.Next(ctx) {
+		var u models.User
+		if err := cur.Decode(&u); err != nil {
+			return err
+		}
+		key := models.FoldUsername(u.Username)
+		if other, ok := owners[key]; ok {
+			return duplicateUsernameError(u, other)
+		}
+		var other models.User
+		err := r.col.FindOne(ctx, bson.M{"username_key": key}).Decode(&other)
+		if err == nil {
+			return duplicateUsernameError(u, other)
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+		keys[u.ID] = key
+		owners[key] = u
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	for id, key := range keys {
+		if _, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"username_key": key}}); err != nil {
+			return err
+		}
+	}
+	if len(keys) > 0 {
+		log.Printf("users: backfilled username_key of %d accounts", len(keys))
+	}
+	return nil
+}
+
+func duplicateUsernameError(a, b models.User) error {
+	return fmt.Errorf("users %s (%q) and %s (%q) have usernames that differ only in case; rename one of them",
+		a.ID.Hex(), a.Username, b.ID.Hex(), b.Username)
+}
@@ -1,28 +1,122 @@
 package router
 
 import (
+	"context"
+	"log"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 
+	"github.com/saurabhraut1212/notes_sharing_api/internal/accounts"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/audit"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/authn"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/authz"
 	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
 	"github.com/saurabhraut1212/notes_sharing_api/internal/handlers"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/lockout"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/mailer"
 	"github.com/saurabhraut1212/notes_sharing_api/internal/middleware"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/password"
 	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/tokens"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/trash"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func Setup(client *mongo.Client, cfg *config.Config) *fiber.App {
-	app := fiber.New()
+// Setup wires the repositories, services and routes. Mail goes through mail,
+// which callers build with mailer.New or replace in tests.
+func Setup(client *mongo.Client, cfg *config.Config, mail mailer.Mailer) *fiber.App {
+	app := fiber.New(fiber.Config{
+		ProxyHeader:             cfg.ProxyHeader,
+		EnableTrustedProxyCheck: len(cfg.TrustedProxies) > 0,
+		TrustedProxies:          cfg.TrustedProxies,
+		EnableIPValidation:      true,
+	})
 	app.Use(logger.New())
 
 	//repos
 	userRepo := repo.NewUserRepo(client.Database(cfg.DBName))
 	noteRepo := repo.NewNoteRepo(client.Database(cfg.DBName))
+	revisionRepo := repo.NewNoteRevisionRepo(client.Database(cfg.DBName))
 	tagRepo := repo.NewTagRepo(client.Database(cfg.DBName))
+	refreshRepo := repo.NewRefreshTokenRepo(client.Database(cfg.DBName))
+	oneTimeRepo := repo.NewOneTimeTokenRepo(client.Database(cfg.DBName))
+	accessTokenRepo := repo.NewAccessTokenRepo(client.Database(cfg.DBName))
+	throttleRepo := repo.NewLoginThrottleRepo(client.Database(cfg.DBName))
+	sessionRepo := repo.NewSessionRepo(client.Database(cfg.DBName))
+	inviteRepo := repo.NewInviteRepo(client.Database(cfg.DBName))
+	passkeyRepo := repo.NewPasskeyRepo(client.Database(cfg.DBName))
+	ceremonyRepo := repo.NewPasskeyCeremonyRepo(client.Database(cfg.DBName))
+	auditRepo := repo.NewAuditRepo(client.Database(cfg.DBName))
+
+	indexes := map[string]indexed{
+		"users":              userRepo,
+		"notes":              noteRepo,
+		"note revisions":     revisionRepo,
+		"refresh tokens":     refreshRepo,
+		"one-time tokens":    oneTimeRepo,
+		"access tokens":      accessTokenRepo,
+		"login throttle":     throttleRepo,
+		"sessions":           sessionRepo,
+		"invites":            inviteRepo,
+		"passkeys":           passkeyRepo,
+		"passkey ceremonies": ceremonyRepo,
+		"audit events":       auditRepo,
+	}
+
+	var revocations tokens.RevocationStore
+	if cfg.RevocationStore == "memory" {
+		revocations = tokens.NewMemoryRevocationStore()
+	} else {
+		revocationRepo := repo.NewRevocationRepo(client.Database(cfg.DBName))
+		indexes["revoked tokens"] = revocationRepo
+		revocations = revocationRepo
+	}
+	ensureIndexes(indexes)
+
+	keys, err := tokens.NewKeyRing(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tokenSvc := tokens.NewService(keys, cfg, userRepo, refreshRepo, accessTokenRepo, sessionRepo, revocations)
+
+	guard := lockout.NewGuard(throttleRepo, cfg)
+
+	passwords, err := password.NewHasher(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authenticator, err := authn.New(cfg, userRepo, passwords)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	recorder := audit.NewRecorder(auditRepo)
+
+	authH := handlers.NewAuthHandler(userRepo, oneTimeRepo, inviteRepo, tokenSvc, mail, guard, passwords, authenticator, recorder, cfg)
+	policy := authz.NewPolicy()
 
-	authH := handlers.NewAuthHandler(userRepo, cfg.JWTSecret)
-	noteH := handlers.NewNoteHandler(noteRepo, cfg)
+	noteH := handlers.NewNoteHandler(noteRepo, revisionRepo, userRepo, policy, recorder, cfg)
 	tagH := handlers.NewTagHandler(tagRepo)
+	accessTokenH := handlers.NewAccessTokenHandler(accessTokenRepo, recorder)
+	sessionH := handlers.NewSessionHandler(sessionRepo, tokenSvc, recorder)
+	userH := handlers.NewUserHandler(userRepo, noteRepo)
+	inviteH := handlers.NewInviteHandler(inviteRepo, policy, cfg)
+	passkeyH, err := handlers.NewPasskeyHandler(authH, passkeyRepo, ceremonyRepo, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	accountH := handlers.NewAccountHandler(authH, noteRepo, revisionRepo, accessTokenRepo, sessionRepo, passkeyRepo, auditRepo)
+	auditH := handlers.NewAuditHandler(auditRepo)
+
+	// auth accepts login tokens and personal access tokens; scope restricts
+	// the latter per route, and account-level routes are closed to them.
+	auth := middleware.RequireAuth(tokenSvc)
+	scope := middleware.RequireScope
+	account := scope(tokens.ScopeAccount)
 
 	api := app.Group("/api")
 
@@ -30,20 +124,178 @@ func Setup(client *mongo.Client, cfg *config.Config) *fiber.App {
 	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("Server running") })
 	app.Get("/health", func(c *fiber.Ctx) error { return c.SendString("OK") })
 
+	// public keys for services that verify our tokens themselves
+	app.Get("/.well-known/jwks.json", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(tokenSvc.JWKS())
+	})
+
 	// auth
 	api.Post("/register", authH.Register)
 	api.Post("/login", authH.Login)
+	api.Post("/login/mfa", authH.LoginMFA)
+	api.Post("/login/magic", authH.RequestMagicLink)
+	api.Post("/login/magic/verify", authH.VerifyMagicLink)
+	api.Post("/token/refresh", authH.Refresh)
+	api.Post("/logout", auth, account, authH.Logout)
+	api.Post("/logout-all", auth, account, authH.LogoutAll)
+	api.Post("/password/forgot", authH.ForgotPassword)
+	api.Post("/password/reset", authH.ResetPassword)
+	api.Get("/verify-email", authH.VerifyEmail)
+	api.Post("/verify-email/resend", authH.ResendVerification)
+
+	// own account
+	api.Get("/me", auth, account, accountH.GetMe)
+	api.Patch("/me", auth, account, accountH.UpdateMe)
+	api.Post("/me/password", auth, account, accountH.ChangePassword)
+	api.Post("/me/email", auth, account, accountH.RequestEmailChange)
+	api.Get("/me/email/confirm", accountH.ConfirmEmailChange)
+	api.Get("/me/export", auth, account, accountH.Export)
+	api.Post("/me/deletion", auth, account, accountH.RequestDeletion)
+	api.Delete("/me/deletion", auth, account, accountH.CancelDeletion)
+
+	// two-factor authentication
+	api.Post("/mfa/totp/enroll", auth, account, authH.EnrollTOTP)
+	api.Post("/mfa/totp/confirm", auth, account, authH.ConfirmTOTP)
+	api.Post("/mfa/totp/disable", auth, account, authH.DisableTOTP)
+
+	// passkeys (WebAuthn)
+	api.Post("/login/passkey/begin", passkeyH.BeginLogin)
+	api.Post("/login/passkey/finish", passkeyH.FinishLogin)
+	api.Post("/passkeys/register/begin", auth, account, passkeyH.BeginRegistration)
+	api.Post("/passkeys/register/finish", auth, account, passkeyH.FinishRegistration)
+	api.Get("/passkeys", auth, account, passkeyH.ListPasskeys)
+	api.Delete("/passkeys/:id", auth, account, passkeyH.DeletePasskey)
+
+	// OpenID Connect login, and confirming a link that an OIDC or directory
+	// login asked for
+	oidcH := handlers.NewOIDCHandler(authH, cfg)
+	api.Post("/identities/link", auth, account, oidcH.Link)
+	if cfg.OIDCIssuer != "" {
+		api.Get("/oidc/login", oidcH.Login)
+		api.Get("/oidc/callback", oidcH.Callback)
+		api.Post("/oidc/link", auth, account, oidcH.Link)
+	}
+
+	// sessions (one per login and device)
+	api.Get("/sessions", auth, account, sessionH.ListSessions)
+	api.Delete("/sessions/:id", auth, account, sessionH.RevokeSession)
+
+	// invites (for REGISTRATION_MODE=invite-only)
+	api.Post("/invites", auth, account, inviteH.CreateInvite)
+	api.Get("/invites", auth, account, inviteH.ListInvites)
+	api.Delete("/invites/:id", auth, account, inviteH.RevokeInvite)
+
+	// personal access tokens
+	api.Post("/tokens", auth, account, accessTokenH.CreateToken)
+	api.Get("/tokens", auth, account, accessTokenH.ListTokens)
+	api.Delete("/tokens/:id", auth, account, accessTokenH.RevokeToken)
 
 	// notes (protected for create/update/delete)
-	api.Post("/notes", middleware.RequireAuth(cfg), noteH.CreateNote)
-	api.Get("/notes", middleware.RequireAuth(cfg), noteH.GetMyNotes)
+	api.Post("/notes", auth, scope(tokens.ScopeNotesWrite), noteH.CreateNote)
+	api.Get("/notes", auth, scope(tokens.ScopeNotesRead), noteH.GetMyNotes)
 	api.Get("/notes/public", noteH.GetPublicNotes)
-	api.Get("/notes/:id", middleware.RequireAuth(cfg), noteH.GetNoteByID)
-	api.Put("/notes/:id", middleware.RequireAuth(cfg), noteH.UpdateNote)
-	api.Delete("/notes/:id", middleware.RequireAuth(cfg), noteH.DeleteNote)
+	api.Get("/notes/:id", auth, scope(tokens.ScopeNotesRead), noteH.GetNoteByID)
+	api.Put("/notes/:id", auth, scope(tokens.ScopeNotesWrite), noteH.UpdateNote)
+	api.Patch("/notes/:id", auth, scope(tokens.ScopeNotesWrite), noteH.PatchNote)
+	api.Delete("/notes/:id", auth, scope(tokens.ScopeNotesWrite), noteH.DeleteNote)
+
+	// note history
+	api.Get("/notes/:id/revisions", auth, scope(tokens.ScopeNotesRead), noteH.ListRevisions)
+	api.Get("/notes/:id/revisions/:version", auth, scope(tokens.ScopeNotesRead), noteH.GetRevision)
+	api.Post("/notes/:id/revisions/:version/restore", auth, scope(tokens.ScopeNotesWrite), noteH.RestoreRevision)
+	api.Get("/notes/:id/diff", auth, scope(tokens.ScopeNotesRead), noteH.DiffRevisions)
+
+	// trash (deleted notes, kept for TRASH_RETENTION)
+	api.Get("/trash", auth, scope(tokens.ScopeNotesRead), noteH.ListTrash)
+	api.Delete("/trash", auth, scope(tokens.ScopeNotesWrite), noteH.EmptyTrash)
+	api.Post("/trash/:id/restore", auth, scope(tokens.ScopeNotesWrite), noteH.RestoreNote)
+	api.Delete("/trash/:id", auth, scope(tokens.ScopeNotesWrite), noteH.PurgeNote)
+
+	// public profiles
+	api.Get("/users/:username", userH.GetProfile)
+	api.Get("/users/:username/notes", userH.GetUserNotes)
+
+	// admin
+	api.Post("/admin/lockouts/unlock", auth, account, middleware.Authorize(policy, authz.LockoutManage), authH.UnlockLogin)
+	api.Put("/admin/users/:id/role", auth, account, middleware.Authorize(policy, authz.UserManage), authH.SetUserRole)
+	api.Get("/admin/audit", auth, account, middleware.Authorize(policy, authz.AuditRead), auditH.ListEvents)
 
 	// tags
+	api.Get("/tags", auth, scope(tokens.ScopeTagsRead), tagH.MyTags)
 	api.Get("/tags/top", tagH.TopTags)
 
+	if cfg.BootstrapAdminEmail != "" {
+		bootstrapAdmin(userRepo, cfg.BootstrapAdminEmail)
+	}
+
+	// carry out account deletions once their grace period has passed
+	purger := accounts.NewPurger(userRepo, map[string]accounts.DataOwner{
+		"notes":              noteRepo,
+		"note revisions":     revisionRepo,
+		"refresh tokens":     refreshRepo,
+		"access tokens":      accessTokenRepo,
+		"one-time tokens":    oneTimeRepo,
+		"sessions":           sessionRepo,
+		"invites":            inviteRepo,
+		"passkeys":           passkeyRepo,
+		"passkey ceremonies": ceremonyRepo,
+	}, map[string]accounts.Eraser{
+		"audit events":    auditRepo,
+		"login throttles": guard,
+	})
+	stopPurge := purger.Start(cfg.AccountPurgeInterval)
+
+	// and remove notes that have been in the trash too long
+	stopTrashPurge := trash.NewPurger(noteRepo, revisionRepo, cfg.TrashRetention).Start(cfg.TrashPurgeInterval)
+
+	app.Hooks().OnShutdown(func() error {
+		stopPurge()
+		stopTrashPurge()
+		return nil
+	})
+
 	return app
 }
+
+// indexed is implemented by repos that declare the indexes they rely on.
+type indexed interface {
+	EnsureIndexes(ctx context.Context) error
+}
+
+// ensureIndexes creates missing indexes before serving. Unique indexes back
+// checks like one account per email, so the server does not start without them.
+func ensureIndexes(repos map[string]indexed) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for name, r := range repos {
+		if err := r.EnsureIndexes(ctx); err != nil {
+			log.Fatalf("ensure %s indexes: %v", name, err)
+		}
+	}
+}
+
+// bootstrapAdmin promotes the configured account to admin if it exists yet.
+func bootstrapAdmin(userRepo *repo.UserRepo, email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	u, err := userRepo.FindByEmail(ctx, email)
+	if err != nil {
+		log.Printf("bootstrap admin: %v", err)
+		return
+	}
+	if u == nil {
+		log.Printf("bootstrap admin: no account for %s yet", email)
+		return
+	}
+	if u.Role == models.RoleAdmin {
+		return
+	}
+	if _, err := userRepo.SetRole(ctx, u.ID, models.RoleAdmin); err != nil {
+		log.Printf("bootstrap admin: %v", err)
+		return
+	}
+	log.Printf("bootstrap admin: %s is now an admin", email)
+}
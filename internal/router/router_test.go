@@ -0,0 +1,123 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/db"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/mailer"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestApp sets up the whole API against a scratch database on the MongoDB
+// server in TEST_MONGO_URI, sending mail to the returned MemoryMailer. Tests
+// using it are skipped when the variable is unset.
+func newTestApp(t *testing.T) (*fiber.App, *mailer.MemoryMailer) {
+	t.Helper()
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI not set")
+	}
+	t.Setenv("MONGO_URI", uri)
+	cfg := config.Load()
+	cfg.DBName = "notes_test_" + primitive.NewObjectID().Hex()
+	cfg.RegistrationMode = config.RegistrationOpen
+
+	client, err := db.New(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := mailer.NewMemoryMailer()
+	app := Setup(client, cfg, mail)
+	t.Cleanup(func() {
+		app.Shutdown()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		client.Database(cfg.DBName).Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return app, mail
+}
+
+func postJSON(t *testing.T, app *fiber.App, path string, body any) (int, map[string]any) {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]any
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+// waitForMail returns the first message to the address with the subject.
+// Handlers send mail in the background, so it polls for a while.
+func waitForMail(t *testing.T, mail *mailer.MemoryMailer, to, subject string) mailer.Message {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, m := range mail.Messages() {
+			if m.To == to && m.Subject == subject {
+				return m
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("no %q mail to %s", subject, to)
+	return mailer.Message{}
+}
+
+var linkToken = regexp.MustCompile(`[?&]token=([^\s&]+)`)
+
+func TestMagicLinkLogin(t *testing.T) {
+	app, mail := newTestApp(t)
+	const email = "magic@example.com"
+
+	status, body := postJSON(t, app, "/api/register", fiber.Map{"email": email, "password": "a long enough passphrase 42"})
+	if status != 201 {
+		t.Fatalf("register status = %d (%v)", status, body)
+	}
+	if status, body := postJSON(t, app, "/api/login/magic", fiber.Map{"email": email}); status != 202 {
+		t.Fatalf("request status = %d (%v)", status, body)
+	}
+
+	msg := waitForMail(t, mail, email, "Your login link")
+	m := linkToken.FindStringSubmatch(msg.Body)
+	if m == nil {
+		t.Fatalf("no token in mail body:\n%s", msg.Body)
+	}
+	token, err := url.QueryUnescape(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body = postJSON(t, app, "/api/login/magic/verify", fiber.Map{"token": token})
+	if status != 200 || body["token"] == nil || body["refresh_token"] == nil {
+		t.Fatalf("exchange status = %d (%v)", status, body)
+	}
+	status, body = postJSON(t, app, "/api/login/magic/verify", fiber.Map{"token": token})
+	if status != 400 {
+		t.Errorf("second exchange status = %d (%v), want 400", status, body)
+	}
+
+	// an unknown address gets the same answer and no mail
+	if status, _ := postJSON(t, app, "/api/login/magic", fiber.Map{"email": "nobody@example.com"}); status != 202 {
+		t.Errorf("unknown email status = %d, want 202", status)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := mail.Last("nobody@example.com"); ok {
+		t.Error("mail sent to an unregistered address")
+	}
+}
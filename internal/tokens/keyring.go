@@ -0,0 +1,200 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+)
+
+// key is one entry of the key ring. Asymmetric keys may lack the private half
+// when they are only kept around to verify tokens during a rotation.
+type key struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeyRing signs tokens with the current key and verifies them with any key it
+// knows, chosen by the kid header. Without an asymmetric signing key it falls
+// back to HS256 with JWT_SECRET, which is also accepted for tokens without a
+// kid so switching to asymmetric keys does not log everybody out.
+type KeyRing struct {
+	signing *key
+	keys    map[string]*key
+	secret  []byte
+}
+
+func NewKeyRing(cfg *config.Config) (*KeyRing, error) {
+	r := &KeyRing{keys: make(map[string]*key)}
+	if cfg.JWTSecret != "" {
+		r.secret = []byte(cfg.JWTSecret)
+	}
+
+	if cfg.JWTSigningKeyFile != "" {
+		k, err := loadKey(cfg.JWTSigningKeyFile, cfg.JWTSigningKeyID)
+		if err != nil {
+			return nil, fmt.Errorf("signing key: %w", err)
+		}
+		if k.private == nil {
+			return nil, errors.New("signing key: file has no private key")
+		}
+		r.signing = k
+		r.keys[k.id] = k
+	} else if r.secret == nil {
+		return nil, errors.New("either JWT_SECRET or JWT_SIGNING_KEY_FILE is required")
+	}
+
+	for _, entry := range cfg.JWTVerificationKeys {
+		kid, path, ok := strings.Cut(entry, "=")
+		if !ok {
+			kid, path = "", entry
+		}
+		k, err := loadKey(path, kid)
+		if err != nil {
+			return nil, fmt.Errorf("verification key %s: %w", path, err)
+		}
+		if _, dup := r.keys[k.id]; dup {
+			return nil, fmt.Errorf("verification key %s: duplicate kid %q", path, k.id)
+		}
+		r.keys[k.id] = k
+	}
+	return r, nil
+}
+
+// Sign signs claims with the current key, naming it in the kid header.
+func (r *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	if r.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(r.secret)
+	}
+	t := jwt.NewWithClaims(r.signing.method, claims)
+	t.Header["kid"] = r.signing.id
+	return t.SignedString(r.signing.private)
+}
+
+// Parse verifies a token signed by any key in the ring.
+func (r *KeyRing) Parse(tokenStr string) (*jwt.Token, error) {
+	return jwt.Parse(tokenStr, r.keyFor, jwt.WithExpirationRequired())
+}
+
+// keyFor picks the verification key and pins the algorithm to that key's, so a
+// token cannot pick a weaker algorithm for a known key.
+func (r *KeyRing) keyFor(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		if r.secret == nil || t.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		return r.secret, nil
+	}
+	k, ok := r.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if t.Method.Alg() != k.method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return k.public, nil
+}
+
+// JWKS is the public half of the ring as a JSON Web Key Set (RFC 7517).
+func (r *KeyRing) JWKS() map[string]interface{} {
+	keys := make([]map[string]string, 0, len(r.keys))
+	for _, k := range r.keys {
+		jwk := publicJWK(k.public)
+		jwk["kid"] = k.id
+		jwk["use"] = "sig"
+		jwk["alg"] = k.method.Alg()
+		keys = append(keys, jwk)
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+func loadKey(path, kid string) (*key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	k := &key{}
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.private, k.public = v, &v.PublicKey
+	case ed25519.PrivateKey:
+		k.private, k.public = v, v.Public()
+	case *rsa.PublicKey, ed25519.PublicKey:
+		k.public = v
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		k.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		k.method = jwt.SigningMethodEdDSA
+	}
+
+	k.id = kid
+	if k.id == "" {
+		k.id = thumbprint(k.public)
+	}
+	return k, nil
+}
+
+func publicJWK(pub crypto.PublicKey) map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch p := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "n": enc(p.N.Bytes()), "e": enc(big.NewInt(int64(p.E)).Bytes())}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "crv": "Ed25519", "x": enc(p)}
+	}
+	return nil
+}
+
+// thumbprint is the RFC 7638 JWK thumbprint, used as kid when none is configured.
+func thumbprint(pub crypto.PublicKey) string {
+	jwk := publicJWK(pub)
+	// json.Marshal sorts map keys, which is the member order RFC 7638 requires
+	b, _ := json.Marshal(jwk)
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,203 @@
+package tokens
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+)
+
+// writeKey stores key as PEM in dir and returns the path. Private keys are
+// written as PKCS #8, public keys as PKIX.
+func writeKey(t *testing.T, dir, name string, key any) string {
+	t.Helper()
+	var block *pem.Block
+	switch k := key.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"sub": "x", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestKeyRingSignAndParse(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPath := writeKey(t, dir, "rsa.pem", rsaKey)
+	edPath := writeKey(t, dir, "ed.pem", edKey)
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantAlg string
+		wantKid string
+	}{
+		{"secret only", config.Config{JWTSecret: "s"}, "HS256", ""},
+		{"rsa", config.Config{JWTSigningKeyFile: rsaPath, JWTSigningKeyID: "r1"}, "RS256", "r1"},
+		{"ed25519", config.Config{JWTSigningKeyFile: edPath, JWTSigningKeyID: "e1"}, "EdDSA", "e1"},
+		{"thumbprint kid", config.Config{JWTSigningKeyFile: edPath}, "EdDSA", thumbprint(edKey.Public())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewKeyRing(&tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signed, err := r.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			tok, err := r.Parse(signed)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if tok.Method.Alg() != tt.wantAlg {
+				t.Errorf("alg = %s, want %s", tok.Method.Alg(), tt.wantAlg)
+			}
+			if kid, _ := tok.Header["kid"].(string); kid != tt.wantKid {
+				t.Errorf("kid = %q, want %q", kid, tt.wantKid)
+			}
+		})
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	oldPriv := writeKey(t, dir, "old.pem", oldKey)
+	oldPub := writeKey(t, dir, "old.pub.pem", &oldKey.PublicKey)
+	newPriv := writeKey(t, dir, "new.pem", newKey)
+
+	hmacRing, _ := NewKeyRing(&config.Config{JWTSecret: "s"})
+	oldRing, _ := NewKeyRing(&config.Config{JWTSigningKeyFile: oldPriv, JWTSigningKeyID: "old"})
+	legacy, _ := hmacRing.Sign(testClaims())
+	previous, _ := oldRing.Sign(testClaims())
+
+	r, err := NewKeyRing(&config.Config{
+		JWTSecret:           "s",
+		JWTSigningKeyFile:   newPriv,
+		JWTSigningKeyID:     "new",
+		JWTVerificationKeys: []string{"old=" + oldPub},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, _ := r.Sign(testClaims())
+	for name, tok := range map[string]string{"current": current, "previous key": previous, "legacy HS256": legacy} {
+		if _, err := r.Parse(tok); err != nil {
+			t.Errorf("%s token rejected: %v", name, err)
+		}
+	}
+
+	jwks := r.JWKS()["keys"].([]map[string]string)
+	if len(jwks) != 2 {
+		t.Fatalf("JWKS has %d keys, want 2", len(jwks))
+	}
+	for _, k := range jwks {
+		if k["kty"] != "RSA" || k["alg"] != "RS256" || k["use"] != "sig" || (k["kid"] != "new" && k["kid"] != "old") {
+			t.Errorf("unexpected JWK %v", k)
+		}
+		if _, ok := k["d"]; ok {
+			t.Error("JWKS leaks a private key")
+		}
+	}
+}
+
+func TestKeyRingRejects(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaPath := writeKey(t, dir, "rsa.pem", rsaKey)
+
+	r, err := NewKeyRing(&config.Config{JWTSigningKeyFile: rsaPath, JWTSigningKeyID: "r1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+		tok := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			tok.Header["kid"] = kid
+		}
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pubDER := x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)
+
+	tests := map[string]string{
+		// the classic confusion attack: HMAC keyed with the public key
+		"HS256 under an RSA kid": sign(jwt.SigningMethodHS256, "r1", pubDER, testClaims()),
+		"HS256 without secret":   sign(jwt.SigningMethodHS256, "", []byte("s"), testClaims()),
+		"unknown kid":            sign(jwt.SigningMethodRS256, "r2", rsaKey, testClaims()),
+		"wrong key":              sign(jwt.SigningMethodRS256, "r1", other, testClaims()),
+		"no exp":                 sign(jwt.SigningMethodRS256, "r1", rsaKey, jwt.MapClaims{"sub": "x"}),
+		"expired": sign(jwt.SigningMethodRS256, "r1", rsaKey, jwt.MapClaims{
+			"exp": time.Now().Add(-time.Minute).Unix(),
+		}),
+	}
+	for name, tok := range tests {
+		if _, err := r.Parse(tok); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+func TestNewKeyRingErrors(t *testing.T) {
+	dir := t.TempDir()
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	smallPath := writeKey(t, dir, "small.pem", small)
+	pubPath := writeKey(t, dir, "pub.pem", pub)
+	garbage := filepath.Join(dir, "garbage.pem")
+	os.WriteFile(garbage, []byte("not a key"), 0o600)
+
+	tests := map[string]config.Config{
+		"nothing configured":   {},
+		"short RSA key":        {JWTSigningKeyFile: smallPath},
+		"public signing key":   {JWTSigningKeyFile: pubPath},
+		"not PEM":              {JWTSigningKeyFile: garbage},
+		"missing file":         {JWTSigningKeyFile: filepath.Join(dir, "missing.pem")},
+		"duplicate kid":        {JWTSecret: "s", JWTVerificationKeys: []string{"a=" + pubPath, "a=" + pubPath}},
+		"bad verification key": {JWTSecret: "s", JWTVerificationKeys: []string{"a=" + garbage}},
+	}
+	for name, cfg := range tests {
+		if _, err := NewKeyRing(&cfg); err == nil {
+			t.Errorf("%s: NewKeyRing succeeded", name)
+		}
+	}
+}
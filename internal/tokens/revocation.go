@@ -0,0 +1,83 @@
+package tokens
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RevocationStore remembers access tokens that must stop working before their
+// exp claim. Entries only need to live until the tokens they cover expire.
+type RevocationStore interface {
+	// RevokeToken invalidates a single token by its jti.
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// RevokeUserTokens invalidates every token of userID issued before issuedBefore.
+	RevokeUserTokens(ctx context.Context, userID primitive.ObjectID, issuedBefore, expiresAt time.Time) error
+	// IsRevoked reports whether a token matches either kind of revocation.
+	IsRevoked(ctx context.Context, jti string, userID primitive.ObjectID, issuedAt time.Time) (bool, error)
+}
+
+// MemoryRevocationStore is a process-local RevocationStore for single-instance
+// deployments and tests.
+type MemoryRevocationStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	users  map[primitive.ObjectID]memoryUserRevocation
+}
+
+type memoryUserRevocation struct {
+	issuedBefore time.Time
+	expiresAt    time.Time
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[primitive.ObjectID]memoryUserRevocation),
+	}
+}
+
+func (m *MemoryRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune(time.Now())
+	m.tokens[jti] = expiresAt
+	return nil
+}
+
+func (m *MemoryRevocationStore) RevokeUserTokens(ctx context.Context, userID primitive.ObjectID, issuedBefore, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune(time.Now())
+	m.users[userID] = memoryUserRevocation{issuedBefore: issuedBefore, expiresAt: expiresAt}
+	return nil
+}
+
+func (m *MemoryRevocationStore) IsRevoked(ctx context.Context, jti string, userID primitive.ObjectID, issuedAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if exp, ok := m.tokens[jti]; ok && now.Before(exp) {
+		return true, nil
+	}
+	if u, ok := m.users[userID]; ok && now.Before(u.expiresAt) && issuedAt.Before(u.issuedBefore) {
+		return true, nil
+	}
+	return false, nil
+}
+
+// prune drops entries whose tokens have expired anyway. Callers hold m.mu.
+func (m *MemoryRevocationStore) prune(now time.Time) {
+	for jti, exp := range m.tokens {
+		if !now.Before(exp) {
+			delete(m.tokens, jti)
+		}
+	}
+	for id, u := range m.users {
+		if !now.Before(u.expiresAt) {
+			delete(m.users, id)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package tokens
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMemoryRevocationStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+
+	m := NewMemoryRevocationStore()
+	if err := m.RevokeToken(ctx, "revoked", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RevokeToken(ctx, "expired", now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RevokeUserTokens(ctx, alice, now, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		jti      string
+		userID   primitive.ObjectID
+		issuedAt time.Time
+		want     bool
+	}{
+		{"revoked jti", "revoked", bob, now, true},
+		{"other jti", "other", bob, now.Add(-time.Minute), false},
+		{"jti past its expiry", "expired", bob, now, false},
+		{"user token issued before cutoff", "a", alice, now.Add(-time.Minute), true},
+		{"user token issued after cutoff", "b", alice, now.Add(time.Second), false},
+		{"user token issued at cutoff", "c", alice, now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.IsRevoked(ctx, tt.jti, tt.userID, tt.issuedAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("IsRevoked() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRevocationStoreUserRevocationExpires(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	id := primitive.NewObjectID()
+
+	m := NewMemoryRevocationStore()
+	if err := m.RevokeUserTokens(ctx, id, now, now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := m.IsRevoked(ctx, "x", id, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked {
+		t.Error("revocation past its expiry still applies")
+	}
+}
+
+func TestMemoryRevocationStorePrunes(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	m := NewMemoryRevocationStore()
+	m.RevokeToken(ctx, "old", now.Add(-time.Second))
+	m.RevokeUserTokens(ctx, primitive.NewObjectID(), now, now.Add(-time.Second))
+	m.RevokeToken(ctx, "live", now.Add(time.Hour))
+
+	if _, ok := m.tokens["old"]; ok {
+		t.Error("expired token revocation was not pruned")
+	}
+	if len(m.users) != 0 {
+		t.Errorf("expired user revocations were not pruned: %d left", len(m.users))
+	}
+	if _, ok := m.tokens["live"]; !ok {
+		t.Error("live token revocation is missing")
+	}
+}
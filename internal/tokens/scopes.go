@@ -0,0 +1,37 @@
+package tokens
+
+import (
+	"slices"
+	"strings"
+)
+
+// Scopes limit what a personal access token may do. Access tokens from a
+// login carry no scope list and may do everything.
+const (
+	ScopeNotesRead  = "notes:read"
+	ScopeNotesWrite = "notes:write"
+	ScopeTagsRead   = "tags:read"
+
+	// ScopeAccount guards account and credential management. It cannot be
+	// granted to personal access tokens.
+	ScopeAccount = "account"
+)
+
+// GrantableScopes are the scopes a personal access token may be created with.
+var GrantableScopes = []string{ScopeNotesRead, ScopeNotesWrite, ScopeTagsRead}
+
+// PATPrefix marks personal access tokens so they are never parsed as JWTs.
+const PATPrefix = "nsa_pat_"
+
+// IsPAT reports whether a bearer token is a personal access token.
+func IsPAT(token string) bool {
+	return strings.HasPrefix(token, PATPrefix)
+}
+
+// HasScope reports whether the claims allow scope.
+func (c *Claims) HasScope(scope string) bool {
+	if c.Scopes == nil {
+		return true
+	}
+	return slices.Contains(c.Scopes, scope)
+}
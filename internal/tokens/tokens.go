@@ -0,0 +1,461 @@
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrTokenRevoked        = errors.New("token revoked")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// ReuseError is returned by Refresh for a replayed refresh token, once the
+// session it belongs to has been revoked. It unwraps to ErrRefreshTokenReused.
+type ReuseError struct {
+	UserID    primitive.ObjectID
+	SessionID primitive.ObjectID
+}
+
+func (e *ReuseError) Error() string { return ErrRefreshTokenReused.Error() }
+
+func (e *ReuseError) Unwrap() error { return ErrRefreshTokenReused }
+
+// sessionTouchInterval limits how often last-seen times are written.
+const sessionTouchInterval = time.Minute
+
+// typAccess marks access tokens; purpose tokens carry their purpose in the
+// same claim so one can never be used in place of the other.
+const typAccess = "access"
+
+// Pair is what a client receives after a successful login or refresh.
+type Pair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// Claims are the verified contents of an access token or personal access token.
+type Claims struct {
+	ID        string
+	UserID    primitive.ObjectID
+	Role      models.Role
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Scopes is nil for login tokens, which are not restricted.
+	Scopes []string
+	// AccessTokenID is set when authenticated with a personal access token.
+	AccessTokenID primitive.ObjectID
+	// SessionID is the login session of an access token; zero for personal
+	// access tokens and for tokens minted before sessions existed.
+	SessionID primitive.ObjectID
+}
+
+// Client describes the device a login came from.
+type Client struct {
+	UserAgent string
+	IP        string
+}
+
+// UserStore is the part of repo.UserRepo the service needs.
+type UserStore interface {
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error)
+}
+
+// RefreshTokenStore is the part of repo.RefreshTokenRepo the service needs.
+type RefreshTokenStore interface {
+	Create(ctx context.Context, t *models.RefreshToken) error
+	FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	MarkRotated(ctx context.Context, id primitive.ObjectID) (bool, error)
+	RevokeFamily(ctx context.Context, familyID primitive.ObjectID) error
+	RevokeUser(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// SessionStore is the part of repo.SessionRepo the service needs.
+type SessionStore interface {
+	Create(ctx context.Context, s *models.Session) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error)
+	Touch(ctx context.Context, id primitive.ObjectID, ip string) error
+	Extend(ctx context.Context, id primitive.ObjectID, expiresAt time.Time) error
+	Revoke(ctx context.Context, id, userID primitive.ObjectID) (bool, error)
+	RevokeUser(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// Service mints short-lived access tokens and rotating refresh tokens, and
+// verifies access tokens against the revocation store.
+type Service struct {
+	keys          *KeyRing
+	accessTTL     time.Duration
+	refreshTTL    time.Duration
+	users         UserStore
+	refreshTokens RefreshTokenStore
+	accessTokens  *repo.AccessTokenRepo
+	sessions      SessionStore
+	revocations   RevocationStore
+}
+
+func NewService(keys *KeyRing, cfg *config.Config, users UserStore, refreshTokens RefreshTokenStore, accessTokens *repo.AccessTokenRepo, sessions SessionStore, revocations RevocationStore) *Service {
+	return &Service{
+		keys:          keys,
+		users:         users,
+		accessTTL:     cfg.AccessTokenTTL,
+		refreshTTL:    cfg.RefreshTokenTTL,
+		refreshTokens: refreshTokens,
+		accessTokens:  accessTokens,
+		sessions:      sessions,
+		revocations:   revocations,
+	}
+}
+
+// Issue records a new session for a freshly authenticated user and starts its
+// refresh token family.
+func (s *Service) Issue(ctx context.Context, user *models.User, client Client) (*Pair, error) {
+	sess := &models.Session{
+		UserID:    user.ID,
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+		ExpiresAt: time.Now().UTC().Add(s.refreshTTL),
+	}
+	if err := s.sessions.Create(ctx, sess); err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, user, sess.ID)
+}
+
+// Refresh exchanges a refresh token for a new pair. Presenting a token that was
+// already rotated revokes its whole family, since either the client or an
+// attacker is holding a stolen copy.
+func (s *Service) Refresh(ctx context.Context, raw string) (*Pair, error) {
+	if raw == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+	t, err := s.refreshTokens.FindByHash(ctx, HashToken(raw))
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || t.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if t.RotatedAt != nil {
+		return nil, s.revokeStolenFamily(ctx, t)
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// reload the user so role changes apply and deleted accounts cannot refresh
+	user, err := s.users.FindByID(ctx, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	sess, err := s.sessions.FindByID(ctx, t.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	// families started before sessions existed have no session document
+	if sess != nil && (sess.RevokedAt != nil || time.Now().After(sess.ExpiresAt)) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// only rotate once the token is known to be redeemable, so a refused or
+	// failed lookup does not burn the client's token
+	ok, err := s.refreshTokens.MarkRotated(ctx, t.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// lost a race against another refresh with the same token
+		return nil, s.revokeStolenFamily(ctx, t)
+	}
+	if sess != nil {
+		if err := s.sessions.Extend(ctx, sess.ID, time.Now().UTC().Add(s.refreshTTL)); err != nil {
+			return nil, err
+		}
+	}
+	return s.issue(ctx, user, t.FamilyID)
+}
+
+// revokeStolenFamily ends the session of a refresh token family that was
+// replayed, along with all of its refresh tokens, and returns the *ReuseError
+// for Refresh to report.
+func (s *Service) revokeStolenFamily(ctx context.Context, t *models.RefreshToken) error {
+	if _, err := s.sessions.Revoke(ctx, t.FamilyID, t.UserID); err != nil {
+		return err
+	}
+	if err := s.refreshTokens.RevokeFamily(ctx, t.FamilyID); err != nil {
+		return err
+	}
+	return &ReuseError{UserID: t.UserID, SessionID: t.FamilyID}
+}
+
+// Parse verifies an access token or personal access token and rejects it if
+// it, or the session it belongs to, has been revoked.
+func (s *Service) Parse(ctx context.Context, tokenStr string, ip string) (*Claims, error) {
+	if IsPAT(tokenStr) {
+		return s.parsePAT(ctx, tokenStr)
+	}
+	token, err := s.keys.Parse(tokenStr)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	mc, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	// tokens minted before the typ claim existed have none
+	if typ, ok := mc["typ"]; ok && typ != typAccess {
+		return nil, ErrInvalidToken
+	}
+	uidStr, _ := mc["user_id"].(string)
+	uid, err := primitive.ObjectIDFromHex(uidStr)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims := &Claims{UserID: uid, Role: models.RoleUser}
+	claims.ID, _ = mc["jti"].(string)
+	if role, ok := mc["role"].(string); ok && models.Role(role).Valid() {
+		claims.Role = models.Role(role)
+	}
+	if exp, err := mc.GetExpirationTime(); err == nil && exp != nil {
+		claims.ExpiresAt = exp.Time
+	}
+	if iat, err := mc.GetIssuedAt(); err == nil && iat != nil {
+		claims.IssuedAt = iat.Time
+	}
+
+	revoked, err := s.revocations.IsRevoked(ctx, claims.ID, claims.UserID, claims.IssuedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	if sid, ok := mc["sid"].(string); ok {
+		if claims.SessionID, err = primitive.ObjectIDFromHex(sid); err != nil {
+			return nil, ErrInvalidToken
+		}
+		if err := s.checkSession(ctx, claims, ip); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// checkSession rejects tokens of revoked or expired sessions and records
+// activity on live ones.
+func (s *Service) checkSession(ctx context.Context, claims *Claims, ip string) error {
+	sess, err := s.sessions.FindByID(ctx, claims.SessionID)
+	if err != nil {
+		return err
+	}
+	if sess == nil || sess.UserID != claims.UserID || sess.RevokedAt != nil {
+		return ErrTokenRevoked
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		return ErrTokenRevoked
+	}
+	if time.Since(sess.LastSeenAt) < sessionTouchInterval && sess.IP == ip {
+		return nil
+	}
+	return s.sessions.Touch(ctx, sess.ID, ip)
+}
+
+func (s *Service) parsePAT(ctx context.Context, tokenStr string) (*Claims, error) {
+	t, err := s.accessTokens.FindByHash(ctx, HashToken(tokenStr))
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrInvalidToken
+	}
+	if t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+	if err := s.accessTokens.TouchLastUsed(ctx, t.ID); err != nil {
+		return nil, err
+	}
+	user, err := s.users.FindByID(ctx, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidToken
+	}
+	claims := &Claims{
+		UserID:        t.UserID,
+		Role:          user.EffectiveRole(),
+		IssuedAt:      t.CreatedAt,
+		Scopes:        t.Scopes,
+		AccessTokenID: t.ID,
+	}
+	if claims.Scopes == nil {
+		claims.Scopes = []string{}
+	}
+	if t.ExpiresAt != nil {
+		claims.ExpiresAt = *t.ExpiresAt
+	}
+	return claims, nil
+}
+
+// Revoke invalidates a single access token together with its session and, when
+// given, the refresh token family it was issued with.
+func (s *Service) Revoke(ctx context.Context, claims *Claims, refreshToken string) error {
+	if claims.ID != "" {
+		if err := s.revocations.RevokeToken(ctx, claims.ID, claims.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	if !claims.SessionID.IsZero() {
+		if err := s.RevokeSession(ctx, claims.UserID, claims.SessionID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			return err
+		}
+	}
+	if refreshToken == "" {
+		return nil
+	}
+	t, err := s.refreshTokens.FindByHash(ctx, HashToken(refreshToken))
+	if err != nil {
+		return err
+	}
+	if t == nil || t.UserID != claims.UserID {
+		return nil
+	}
+	return s.refreshTokens.RevokeFamily(ctx, t.FamilyID)
+}
+
+// RevokeAll invalidates every access and refresh token the user currently holds.
+func (s *Service) RevokeAll(ctx context.Context, userID primitive.ObjectID) error {
+	// iat has whole seconds, so a cutoff inside this second would also catch
+	// tokens issued right after; earlier tokens of the second still die with
+	// their session
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := s.revocations.RevokeUserTokens(ctx, userID, now, now.Add(s.accessTTL)); err != nil {
+		return err
+	}
+	if err := s.sessions.RevokeUser(ctx, userID); err != nil {
+		return err
+	}
+	return s.refreshTokens.RevokeUser(ctx, userID)
+}
+
+// RevokeSession ends one of the user's sessions: its access tokens stop being
+// accepted and its refresh tokens can no longer be used.
+func (s *Service) RevokeSession(ctx context.Context, userID, sessionID primitive.ObjectID) error {
+	ok, err := s.sessions.Revoke(ctx, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return s.refreshTokens.RevokeFamily(ctx, sessionID)
+}
+
+func (s *Service) issue(ctx context.Context, user *models.User, familyID primitive.ObjectID) (*Pair, error) {
+	access, err := s.accessToken(user, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, hash, err := NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	rt := &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().UTC().Add(s.refreshTTL),
+	}
+	if err := s.refreshTokens.Create(ctx, rt); err != nil {
+		return nil, err
+	}
+
+	return &Pair{
+		AccessToken:  access,
+		RefreshToken: raw,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessTTL / time.Second),
+	}, nil
+}
+
+func (s *Service) accessToken(user *models.User, sessionID primitive.ObjectID) (string, error) {
+	now := time.Now()
+	return s.keys.Sign(jwt.MapClaims{
+		"jti":     uuid.NewString(),
+		"typ":     typAccess,
+		"sid":     sessionID.Hex(),
+		"user_id": user.ID,
+		"role":    user.EffectiveRole(),
+		"iat":     now.Unix(),
+		"exp":     now.Add(s.accessTTL).Unix(),
+	})
+}
+
+// SignPurpose mints a signed token that is only valid for purpose, such as an
+// email verification link. Parse never accepts it as an access token.
+func (s *Service) SignPurpose(purpose string, claims jwt.MapClaims, ttl time.Duration) (string, error) {
+	mc := jwt.MapClaims{}
+	for k, v := range claims {
+		mc[k] = v
+	}
+	now := time.Now()
+	mc["typ"] = purpose
+	mc["iat"] = now.Unix()
+	mc["exp"] = now.Add(ttl).Unix()
+	return s.keys.Sign(mc)
+}
+
+// ParsePurpose verifies a token minted by SignPurpose for the same purpose.
+func (s *Service) ParsePurpose(purpose, tokenStr string) (jwt.MapClaims, error) {
+	token, err := s.keys.Parse(tokenStr)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	mc, ok := token.Claims.(jwt.MapClaims)
+	if !ok || mc["typ"] != purpose {
+		return nil, ErrInvalidToken
+	}
+	return mc, nil
+}
+
+// JWKS returns the public verification keys for /.well-known/jwks.json.
+func (s *Service) JWKS() map[string]interface{} {
+	return s.keys.JWKS()
+}
+
+// NewOpaqueToken returns a random URL-safe token and the hash to store for it.
+func NewOpaqueToken() (raw, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	raw = base64.RawURLEncoding.EncodeToString(b)
+	return raw, HashToken(raw), nil
+}
+
+// HashToken is the lookup key under which opaque tokens are persisted.
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
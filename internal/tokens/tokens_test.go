@@ -0,0 +1,429 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/config"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeUsers map[primitive.ObjectID]*models.User
+
+func (f fakeUsers) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	return f[id], nil
+}
+
+// fakeRefreshTokens keeps refresh tokens in memory with the repo's semantics.
+// gate, when set, holds every FindByHash until it is released, so that tests
+// can line refreshes up to race each other.
+type fakeRefreshTokens struct {
+	mu     sync.Mutex
+	tokens []*models.RefreshToken
+	gate   *sync.WaitGroup
+}
+
+func (f *fakeRefreshTokens) Create(ctx context.Context, t *models.RefreshToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t.ID = primitive.NewObjectID()
+	t.CreatedAt = time.Now().UTC()
+	c := *t
+	f.tokens = append(f.tokens, &c)
+	return nil
+}
+
+func (f *fakeRefreshTokens) FindByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	f.mu.Lock()
+	var found *models.RefreshToken
+	for _, t := range f.tokens {
+		if t.TokenHash == hash {
+			c := *t
+			found = &c
+		}
+	}
+	f.mu.Unlock()
+	if f.gate != nil {
+		f.gate.Done()
+		f.gate.Wait()
+	}
+	return found, nil
+}
+
+func (f *fakeRefreshTokens) MarkRotated(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.tokens {
+		if t.ID == id && t.RotatedAt == nil && t.RevokedAt == nil {
+			now := time.Now().UTC()
+			t.RotatedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeRefreshTokens) RevokeFamily(ctx context.Context, familyID primitive.ObjectID) error {
+	return f.revoke(func(t *models.RefreshToken) bool { return t.FamilyID == familyID })
+}
+
+func (f *fakeRefreshTokens) RevokeUser(ctx context.Context, userID primitive.ObjectID) error {
+	return f.revoke(func(t *models.RefreshToken) bool { return t.UserID == userID })
+}
+
+func (f *fakeRefreshTokens) revoke(match func(*models.RefreshToken) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now().UTC()
+	for _, t := range f.tokens {
+		if match(t) && t.RevokedAt == nil {
+			t.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+// live reports how many tokens of the family could still be redeemed.
+func (f *fakeRefreshTokens) live(familyID primitive.ObjectID) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, t := range f.tokens {
+		if t.FamilyID == familyID && t.RotatedAt == nil && t.RevokedAt == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeRefreshTokens) find(raw string) *models.RefreshToken {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.tokens {
+		if t.TokenHash == HashToken(raw) {
+			return t
+		}
+	}
+	return nil
+}
+
+type fakeSessions struct {
+	mu       sync.Mutex
+	sessions map[primitive.ObjectID]*models.Session
+}
+
+func (f *fakeSessions) Create(ctx context.Context, s *models.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now().UTC()
+	s.ID = primitive.NewObjectID()
+	s.CreatedAt, s.LastSeenAt = now, now
+	c := *s
+	f.sessions[s.ID] = &c
+	return nil
+}
+
+func (f *fakeSessions) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	c := *s
+	return &c, nil
+}
+
+func (f *fakeSessions) Touch(ctx context.Context, id primitive.ObjectID, ip string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.sessions[id]; ok {
+		s.LastSeenAt, s.IP = time.Now().UTC(), ip
+	}
+	return nil
+}
+
+func (f *fakeSessions) Extend(ctx context.Context, id primitive.ObjectID, expiresAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.sessions[id]; ok && s.RevokedAt == nil {
+		s.ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+func (f *fakeSessions) Revoke(ctx context.Context, id, userID primitive.ObjectID) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.sessions[id]
+	if !ok || s.UserID != userID || s.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now().UTC()
+	s.RevokedAt = &now
+	return true, nil
+}
+
+func (f *fakeSessions) RevokeUser(ctx context.Context, userID primitive.ObjectID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now().UTC()
+	for _, s := range f.sessions {
+		if s.UserID == userID && s.RevokedAt == nil {
+			s.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (f *fakeSessions) revoked(id primitive.ObjectID) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sessions[id].RevokedAt != nil
+}
+
+type testService struct {
+	*Service
+	users    fakeUsers
+	refresh  *fakeRefreshTokens
+	sessions *fakeSessions
+}
+
+func newTestService(t *testing.T) *testService {
+	t.Helper()
+	cfg := &config.Config{JWTSecret: "test-secret", AccessTokenTTL: time.Minute, RefreshTokenTTL: time.Hour}
+	keys, err := NewKeyRing(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &testService{
+		users:    fakeUsers{},
+		refresh:  &fakeRefreshTokens{},
+		sessions: &fakeSessions{sessions: map[primitive.ObjectID]*models.Session{}},
+	}
+	ts.Service = NewService(keys, cfg, ts.users, ts.refresh, nil, ts.sessions, NewMemoryRevocationStore())
+	return ts
+}
+
+// login issues a pair for a new user and returns it with the user.
+func (ts *testService) login(t *testing.T) (*Pair, *models.User) {
+	t.Helper()
+	u := &models.User{ID: primitive.NewObjectID(), Email: "a@example.com", Role: models.RoleUser}
+	ts.users[u.ID] = u
+	pair, err := ts.Issue(context.Background(), u, Client{UserAgent: "test", IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair, u
+}
+
+func TestRefreshRotates(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	first, _ := ts.login(t)
+	family := ts.refresh.find(first.RefreshToken).FamilyID
+
+	second, err := ts.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatal("Refresh() returned the old pair")
+	}
+	if rt := ts.refresh.find(second.RefreshToken); rt == nil || rt.FamilyID != family {
+		t.Fatal("the new refresh token is not in the old token's family")
+	}
+	if n := ts.refresh.live(family); n != 1 {
+		t.Fatalf("family has %d live refresh tokens, want 1", n)
+	}
+	claims, err := ts.Parse(ctx, second.AccessToken, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Parse() of the new access token: %v", err)
+	}
+	if claims.SessionID != family {
+		t.Fatalf("access token session = %s, want %s", claims.SessionID.Hex(), family.Hex())
+	}
+	if _, err := ts.Refresh(ctx, second.RefreshToken); err != nil {
+		t.Fatalf("Refresh() of the rotated-in token: %v", err)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	stolen, user := ts.login(t)
+	family := ts.refresh.find(stolen.RefreshToken).FamilyID
+
+	current, err := ts.Refresh(ctx, stolen.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ts.Refresh(ctx, stolen.RefreshToken)
+	var reuse *ReuseError
+	if !errors.Is(err, ErrRefreshTokenReused) || !errors.As(err, &reuse) {
+		t.Fatalf("Refresh() of a rotated token: error = %v, want a *ReuseError", err)
+	}
+	if reuse.UserID != user.ID || reuse.SessionID != family {
+		t.Errorf("ReuseError = %+v, want user %s and session %s", reuse, user.ID.Hex(), family.Hex())
+	}
+	if !ts.sessions.revoked(family) {
+		t.Fatal("session survived the reuse")
+	}
+	if n := ts.refresh.live(family); n != 0 {
+		t.Fatalf("family has %d live refresh tokens after reuse, want 0", n)
+	}
+	// the legitimate holder is logged out too
+	if _, err := ts.Refresh(ctx, current.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("Refresh() of the family's newest token: error = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if _, err := ts.Parse(ctx, current.AccessToken, "127.0.0.1"); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Parse() of the family's access token: error = %v, want %v", err, ErrTokenRevoked)
+	}
+}
+
+func TestRefreshRefused(t *testing.T) {
+	past := time.Now().UTC().Add(-time.Minute)
+	tests := []struct {
+		name  string
+		spoil func(ts *testService, rt *models.RefreshToken, u *models.User)
+	}{
+		{
+			name:  "expired refresh token",
+			spoil: func(ts *testService, rt *models.RefreshToken, u *models.User) { rt.ExpiresAt = past },
+		},
+		{
+			name:  "revoked refresh token",
+			spoil: func(ts *testService, rt *models.RefreshToken, u *models.User) { rt.RevokedAt = &past },
+		},
+		{
+			name: "revoked session",
+			spoil: func(ts *testService, rt *models.RefreshToken, u *models.User) {
+				ts.sessions.sessions[rt.FamilyID].RevokedAt = &past
+			},
+		},
+		{
+			name: "expired session",
+			spoil: func(ts *testService, rt *models.RefreshToken, u *models.User) {
+				ts.sessions.sessions[rt.FamilyID].ExpiresAt = past
+			},
+		},
+		{
+			name:  "deleted user",
+			spoil: func(ts *testService, rt *models.RefreshToken, u *models.User) { delete(ts.users, u.ID) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestService(t)
+			pair, u := ts.login(t)
+			rt := ts.refresh.find(pair.RefreshToken)
+			tt.spoil(ts, rt, u)
+
+			if _, err := ts.Refresh(context.Background(), pair.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+				t.Fatalf("Refresh() error = %v, want %v", err, ErrInvalidRefreshToken)
+			}
+			// a refused token is not rotated, so it is not mistaken for a
+			// replay later
+			if rt.RotatedAt != nil {
+				t.Fatal("refused token was rotated")
+			}
+		})
+	}
+}
+
+func TestRefreshUnknownToken(t *testing.T) {
+	ts := newTestService(t)
+	for _, raw := range []string{"", "not-a-token"} {
+		if _, err := ts.Refresh(context.Background(), raw); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("Refresh(%q) error = %v, want %v", raw, err, ErrInvalidRefreshToken)
+		}
+	}
+}
+
+func TestConcurrentRefreshOnlyOneWins(t *testing.T) {
+	ts := newTestService(t)
+	pair, _ := ts.login(t)
+	family := ts.refresh.find(pair.RefreshToken).FamilyID
+
+	// both refreshes look the token up before either rotates it
+	ts.refresh.gate = &sync.WaitGroup{}
+	ts.refresh.gate.Add(2)
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := ts.Refresh(context.Background(), pair.RefreshToken)
+			errs <- err
+		}()
+	}
+	var won, reused int
+	for range 2 {
+		switch err := <-errs; {
+		case err == nil:
+			won++
+		case errors.Is(err, ErrRefreshTokenReused):
+			reused++
+		default:
+			t.Fatalf("Refresh() error = %v", err)
+		}
+	}
+	if won != 1 || reused != 1 {
+		t.Fatalf("%d refreshes won and %d were refused as reuse, want 1 and 1", won, reused)
+	}
+	// the loser cannot tell which of the two was the thief
+	if !ts.sessions.revoked(family) || ts.refresh.live(family) != 0 {
+		t.Fatal("family survived a concurrent reuse")
+	}
+}
+
+func TestParseRejectsEndedSessions(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		end  func(s *models.Session)
+	}{
+		{"revoked", func(s *models.Session) { now := time.Now(); s.RevokedAt = &now }},
+		{"expired", func(s *models.Session) { s.ExpiresAt = time.Now().Add(-time.Second) }},
+		{"someone else's", func(s *models.Session) { s.UserID = primitive.NewObjectID() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestService(t)
+			pair, _ := ts.login(t)
+			if _, err := ts.Parse(ctx, pair.AccessToken, "127.0.0.1"); err != nil {
+				t.Fatalf("Parse() of a live session: %v", err)
+			}
+			tt.end(ts.sessions.sessions[ts.refresh.find(pair.RefreshToken).FamilyID])
+			if _, err := ts.Parse(ctx, pair.AccessToken, "127.0.0.1"); !errors.Is(err, ErrTokenRevoked) {
+				t.Fatalf("Parse() error = %v, want %v", err, ErrTokenRevoked)
+			}
+		})
+	}
+}
+
+func TestRevokeAllSparesLaterTokens(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestService(t)
+	old, u := ts.login(t)
+	if err := ts.RevokeAll(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Parse(ctx, old.AccessToken, "127.0.0.1"); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Parse() of a token from before = %v, want %v", err, ErrTokenRevoked)
+	}
+	if _, err := ts.Refresh(ctx, old.RefreshToken); err == nil {
+		t.Error("Refresh() with a token from before succeeded")
+	}
+
+	// issued in the same second as the revocation, as after a password change
+	pair, err := ts.Issue(ctx, u, Client{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Parse(ctx, pair.AccessToken, "127.0.0.1"); err != nil {
+		t.Errorf("Parse() of a token from after: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestGenerateMatchesRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	// the RFC lists 8 digit codes; ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := generate(key, tt.unix/period); got != tt.want {
+			t.Errorf("generate at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / period
+	code := "050471"
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		at       time.Time
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfcSecret, code, now, 0, step, true},
+		{"lower case secret", strings.ToLower(rfcSecret), code, now, 0, step, true},
+		{"one step late", rfcSecret, code, now.Add(period * time.Second), 0, step, true},
+		{"one step early", rfcSecret, code, now.Add(-period * time.Second), 0, step, true},
+		{"two steps late", rfcSecret, code, now.Add(2 * period * time.Second), 0, 0, false},
+		{"wrong code", rfcSecret, "000000", now, 0, 0, false},
+		{"short code", rfcSecret, "05047", now, 0, 0, false},
+		{"bad secret", "not base32!", code, now, 0, 0, false},
+		{"replay in the same step", rfcSecret, code, now, step, 0, false},
+		{"replay of an older step", rfcSecret, code, now.Add(period * time.Second), step, 0, false},
+		{"earlier step already used", rfcSecret, code, now, step - 1, step, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := Validate(tt.secret, tt.code, tt.at, tt.lastStep)
+			if gotStep != tt.wantStep || gotOK != tt.wantOK {
+				t.Errorf("Validate() = %d, %v, want %d, %v", gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	if a == b {
+		t.Error("two secrets are equal")
+	}
+	key, err := encoding.DecodeString(a)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q does not decode to 20 bytes: %v", a, err)
+	}
+}
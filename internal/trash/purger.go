@@ -0,0 +1,68 @@
+// Package trash permanently removes notes that have been in the trash for
+// longer than the retention period.
+package trash
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/saurabhraut1212/notes_sharing_api/internal/periodic"
+	"github.com/saurabhraut1212/notes_sharing_api/internal/repo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const batchSize = 100
+
+// trashStore is the part of repo.NoteRepo the purge needs.
+type trashStore interface {
+	PurgeTrash(ctx context.Context, cutoff time.Time, limit int) ([]primitive.ObjectID, error)
+}
+
+// historyStore is the part of repo.NoteRevisionRepo the purge needs.
+type historyStore interface {
+	DeleteByNotes(ctx context.Context, noteIDs []primitive.ObjectID) error
+}
+
+// Purger deletes expired trash together with the notes' revisions.
+type Purger struct {
+	notes     trashStore
+	revisions historyStore
+	retention time.Duration
+}
+
+func NewPurger(notes *repo.NoteRepo, revisions *repo.NoteRevisionRepo, retention time.Duration) *Purger {
+	return &Purger{
+		notes:     notes,
+		revisions: revisions,
+		retention: retention,
+	}
+}
+
+// Start runs the purge every interval until the returned stop func is called.
+func (p *Purger) Start(interval time.Duration) (stop func()) {
+	return periodic.Start("trash purge", interval, time.Minute, p.Purge)
+}
+
+// Purge deletes notes trashed more than the retention period ago, in batches
+// until none are left.
+func (p *Purger) Purge(ctx context.Context) error {
+	cutoff := time.Now().UTC().Add(-p.retention)
+	for {
+		// a failed batch still reports the notes it deleted, whose history
+		// must go too
+		ids, err := p.notes.PurgeTrash(ctx, cutoff, batchSize)
+		if err := p.revisions.DeleteByNotes(ctx, ids); err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			log.Printf("trash purge: deleted %d notes", len(ids))
+		}
+		if err != nil {
+			return err
+		}
+		if len(ids) < batchSize {
+			return nil
+		}
+	}
+}
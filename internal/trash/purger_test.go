@@ -0,0 +1,109 @@
+package trash
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeTrash hands out the given batches, then an empty one.
+type fakeTrash struct {
+	batches [][]primitive.ObjectID
+	errs    []error
+	calls   int
+}
+
+func (f *fakeTrash) PurgeTrash(ctx context.Context, cutoff time.Time, limit int) ([]primitive.ObjectID, error) {
+	i := f.calls
+	f.calls++
+	if i >= len(f.batches) {
+		return []primitive.ObjectID{}, nil
+	}
+	return f.batches[i], f.errs[i]
+}
+
+type fakeHistory struct {
+	deleted []primitive.ObjectID
+	err     error
+}
+
+func (f *fakeHistory) DeleteByNotes(ctx context.Context, noteIDs []primitive.ObjectID) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.deleted = append(f.deleted, noteIDs...)
+	return nil
+}
+
+func ids(n int) []primitive.ObjectID {
+	out := make([]primitive.ObjectID, n)
+	for i := range out {
+		out[i] = primitive.NewObjectID()
+	}
+	return out
+}
+
+func TestPurge(t *testing.T) {
+	full, partial, last := ids(batchSize), ids(3), ids(2)
+	failed := errors.New("connection reset")
+
+	tests := []struct {
+		name        string
+		batches     [][]primitive.ObjectID
+		errs        []error
+		historyErr  error
+		wantErr     error
+		wantCalls   int
+		wantDeleted []primitive.ObjectID
+	}{
+		{
+			name:      "nothing expired",
+			wantCalls: 1,
+		},
+		{
+			name:        "stops after a short batch",
+			batches:     [][]primitive.ObjectID{full, last},
+			errs:        []error{nil, nil},
+			wantCalls:   2,
+			wantDeleted: append(slices.Clone(full), last...),
+		},
+		{
+			name:        "failed batch still drops the history of what it deleted",
+			batches:     [][]primitive.ObjectID{partial, last},
+			errs:        []error{failed, nil},
+			wantErr:     failed,
+			wantCalls:   1,
+			wantDeleted: partial,
+		},
+		{
+			name:       "history failure stops the purge",
+			batches:    [][]primitive.ObjectID{full, last},
+			errs:       []error{nil, nil},
+			historyErr: failed,
+			wantErr:    failed,
+			wantCalls:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notes := &fakeTrash{batches: tt.batches, errs: tt.errs}
+			history := &fakeHistory{err: tt.historyErr}
+			p := &Purger{notes: notes, revisions: history, retention: time.Hour}
+
+			err := p.Purge(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Purge() error = %v, want %v", err, tt.wantErr)
+			}
+			if notes.calls != tt.wantCalls {
+				t.Errorf("PurgeTrash called %d times, want %d", notes.calls, tt.wantCalls)
+			}
+			if !slices.Equal(history.deleted, tt.wantDeleted) {
+				t.Errorf("deleted history of %d notes, want %d", len(history.deleted), len(tt.wantDeleted))
+			}
+		})
+	}
+}